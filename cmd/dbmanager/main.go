@@ -0,0 +1,362 @@
+// Command dbmanager manages databases, users and permissions on a PostgreSQL or MySQL server from a
+// configuration file.
+//
+// Usage:
+//
+//	dbmanager <command> [flags]
+//
+// The commands are:
+//
+//	plan    show the changes needed to bring the server in line with the config file
+//	apply   make the changes needed to bring the server in line with the config file
+//	diff    show the differences between the config file and the server, exits 2 if there are any
+//	export  print the databases and users on the server as a config file
+//
+// Connection settings are read from the flags, then the DBMANAGER_* environment variables and finally the
+// connection section of the config file.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/shoekstra/go-dbmanager"
+	"github.com/shoekstra/go-dbmanager/config"
+	"gopkg.in/yaml.v3"
+)
+
+// Exit codes returned by the commands.
+const (
+	exitOK    = 0
+	exitError = 1
+	exitDrift = 2
+)
+
+const usage = `Usage: dbmanager <command> [flags]
+
+Commands:
+  plan    show the changes needed to bring the server in line with the config file
+  apply   make the changes needed to bring the server in line with the config file
+  diff    show the differences between the config file and the server, exits 2 if there are any
+  export  print the databases and users on the server as a config file
+
+Run "dbmanager <command> -h" for the flags of a command.
+`
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	os.Exit(run(ctx, os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run runs the command in args and returns the exit code.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return exitError
+	}
+
+	command, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q\n\n%s", args[0], usage)
+		return exitError
+	}
+
+	opts := &options{stderr: stderr}
+	fs := opts.flagSet(args[0], stderr)
+	if err := fs.Parse(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitError
+	}
+
+	code, err := command(ctx, opts, stdout)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+	}
+	return code
+}
+
+// commands maps each command name to the function that runs it.
+var commands = map[string]func(ctx context.Context, opts *options, stdout io.Writer) (int, error){
+	"plan":   runPlan,
+	"apply":  runApply,
+	"diff":   runDiff,
+	"export": runExport,
+}
+
+// options holds the flags shared by every command.
+type options struct {
+	configFile  string
+	output      string
+	prune       bool
+	concurrency int
+	lockTimeout time.Duration
+	verbose     bool
+	connection  config.Connection
+
+	// stderr is where logs are written.
+	stderr io.Writer
+}
+
+// flagSet returns the flags for a command, parsed into opts.
+func (o *options) flagSet(name string, output io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(output)
+
+	fs.StringVar(&o.configFile, "config", os.Getenv("DBMANAGER_CONFIG"), "config file, JSON or YAML (env DBMANAGER_CONFIG)")
+	fs.StringVar(&o.output, "output", "text", "output format: text or json")
+	fs.BoolVar(&o.verbose, "verbose", false, "log every query and change to stderr")
+	if name == "plan" || name == "apply" {
+		fs.BoolVar(&o.prune, "prune", false, "remove users, databases and grants that are not in the config file")
+		fs.IntVar(&o.concurrency, "concurrency", 1, "number of databases to plan and apply at the same time")
+	}
+	if name == "apply" {
+		fs.DurationVar(&o.lockTimeout, "lock-timeout", time.Minute, "how long to wait for another apply against the same server to finish")
+	}
+
+	fs.StringVar(&o.connection.Engine, "engine", "", "database engine: postgres or mysql (env DBMANAGER_ENGINE)")
+	fs.StringVar(&o.connection.Host, "host", "", "server host (env DBMANAGER_HOST)")
+	fs.StringVar(&o.connection.Port, "port", "", "server port (env DBMANAGER_PORT)")
+	fs.StringVar(&o.connection.Database, "database", "", "database to connect to (env DBMANAGER_DATABASE)")
+	fs.StringVar(&o.connection.Username, "username", "", "user to connect as (env DBMANAGER_USERNAME)")
+	fs.StringVar(&o.connection.Password, "password", "", "password to connect with, prefer the environment variable (env DBMANAGER_PASSWORD)")
+	fs.StringVar(&o.connection.SSLMode, "sslmode", "", "PostgreSQL sslmode (env DBMANAGER_SSLMODE)")
+
+	return fs
+}
+
+// load reads the config file, which is required unless optional is set, and returns it with the connection
+// settings from the flags and environment variables applied.
+func (o *options) load(optional bool) (*config.Config, error) {
+	if o.output != "text" && o.output != "json" {
+		return nil, fmt.Errorf("unsupported output format %q, expected text or json", o.output)
+	}
+
+	cfg := &config.Config{}
+	switch {
+	case o.configFile != "":
+		var err error
+		if cfg, err = config.Load(o.configFile); err != nil {
+			return nil, err
+		}
+	case !optional:
+		return nil, errors.New("a config file is required, set --config or DBMANAGER_CONFIG")
+	}
+
+	cfg.Connection = resolveConnection(o.connection, cfg.Connection, os.Getenv)
+	if cfg.Connection.Engine == "" {
+		return nil, errors.New("an engine is required, set --engine, DBMANAGER_ENGINE or connection.engine in the config file")
+	}
+
+	return cfg, nil
+}
+
+// resolveConnection returns the connection settings using the flags first, then the environment variables
+// and finally the config file.
+func resolveConnection(flags, file config.Connection, getenv func(string) string) config.Connection {
+	first := func(values ...string) string {
+		for _, value := range values {
+			if value != "" {
+				return value
+			}
+		}
+		return ""
+	}
+
+	return config.Connection{
+		Engine:   first(flags.Engine, getenv("DBMANAGER_ENGINE"), file.Engine),
+		Host:     first(flags.Host, getenv("DBMANAGER_HOST"), file.Host),
+		Port:     first(flags.Port, getenv("DBMANAGER_PORT"), file.Port),
+		Database: first(flags.Database, getenv("DBMANAGER_DATABASE"), file.Database),
+		Username: first(flags.Username, getenv("DBMANAGER_USERNAME"), file.Username),
+		Password: first(flags.Password, getenv("DBMANAGER_PASSWORD"), file.Password),
+		SSLMode:  first(flags.SSLMode, getenv("DBMANAGER_SSLMODE"), file.SSLMode),
+		SSL:      file.SSL,
+	}
+}
+
+// connect returns a manager connected to the server in the config.
+func (o *options) connect(ctx context.Context, cfg *config.Config) (dbmanager.Manager, error) {
+	level := slog.LevelWarn
+	if o.verbose {
+		level = slog.LevelDebug
+	}
+	logger := slog.New(slog.NewTextHandler(o.stderr, &slog.HandlerOptions{Level: level}))
+
+	manager, err := cfg.Manager(dbmanager.WithLogger(logger))
+	if err != nil {
+		return nil, err
+	}
+	if err := manager.ConnectContext(ctx); err != nil {
+		return nil, err
+	}
+	return manager, nil
+}
+
+// manageOptions returns the Manage options set by the flags.
+func (o *options) manageOptions() []dbmanager.ManageOption {
+	var options []dbmanager.ManageOption
+	if o.prune {
+		options = append(options, dbmanager.WithPrune())
+	}
+	if o.concurrency > 1 {
+		options = append(options, dbmanager.WithConcurrency(o.concurrency))
+	}
+	if o.lockTimeout > 0 {
+		options = append(options, dbmanager.WithLockTimeout(o.lockTimeout))
+	}
+	return options
+}
+
+// runPlan prints the changes needed to bring the server in line with the config file.
+func runPlan(ctx context.Context, opts *options, stdout io.Writer) (int, error) {
+	cfg, err := opts.load(false)
+	if err != nil {
+		return exitError, err
+	}
+
+	manager, err := opts.connect(ctx, cfg)
+	if err != nil {
+		return exitError, err
+	}
+	defer manager.Disconnect()
+
+	plan, err := manager.PlanContext(ctx, cfg.Databases, cfg.Users, opts.manageOptions()...)
+	if err != nil {
+		return exitError, err
+	}
+
+	return exitOK, opts.print(stdout, plan)
+}
+
+// runApply makes the changes needed to bring the server in line with the config file and prints what was
+// done. What was done is printed even if a change fails.
+func runApply(ctx context.Context, opts *options, stdout io.Writer) (int, error) {
+	cfg, err := opts.load(false)
+	if err != nil {
+		return exitError, err
+	}
+
+	manager, err := opts.connect(ctx, cfg)
+	if err != nil {
+		return exitError, err
+	}
+	defer manager.Disconnect()
+
+	report := &dbmanager.Report{}
+	err = manager.ManageContext(ctx, cfg.Databases, cfg.Users, append(opts.manageOptions(), dbmanager.WithReport(report))...)
+	if printErr := opts.print(stdout, report); printErr != nil && err == nil {
+		err = printErr
+	}
+	if err != nil {
+		return exitError, err
+	}
+
+	return exitOK, nil
+}
+
+// runDiff prints the differences between the config file and the server.
+func runDiff(ctx context.Context, opts *options, stdout io.Writer) (int, error) {
+	cfg, err := opts.load(false)
+	if err != nil {
+		return exitError, err
+	}
+
+	manager, err := opts.connect(ctx, cfg)
+	if err != nil {
+		return exitError, err
+	}
+	defer manager.Disconnect()
+
+	report, err := manager.DiffContext(ctx, cfg.Databases, cfg.Users)
+	if err != nil {
+		return exitError, err
+	}
+	if err := opts.print(stdout, report); err != nil {
+		return exitError, err
+	}
+
+	if report.HasDrift() {
+		return exitDrift, nil
+	}
+	return exitOK, nil
+}
+
+// exported is the config file printed by export, the connection is left out so credentials aren't printed.
+type exported struct {
+	Databases []dbmanager.Database `json:"databases"`
+	Users     []dbmanager.User     `json:"users"`
+}
+
+// runExport prints the databases and users on the server as a config file. The text output is YAML.
+func runExport(ctx context.Context, opts *options, stdout io.Writer) (int, error) {
+	cfg, err := opts.load(true)
+	if err != nil {
+		return exitError, err
+	}
+
+	manager, err := opts.connect(ctx, cfg)
+	if err != nil {
+		return exitError, err
+	}
+	defer manager.Disconnect()
+
+	databases, users, err := manager.ExportContext(ctx)
+	if err != nil {
+		return exitError, err
+	}
+	out := exported{Databases: databases, Users: users}
+
+	if opts.output == "json" {
+		return exitOK, printJSON(stdout, out)
+	}
+
+	// The YAML is generated from the JSON so it uses the same field names the config package expects
+	encoded, err := json.Marshal(out)
+	if err != nil {
+		return exitError, err
+	}
+	var value any
+	if err := yaml.Unmarshal(encoded, &value); err != nil {
+		return exitError, err
+	}
+	encoder := yaml.NewEncoder(stdout)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(value); err != nil {
+		return exitError, err
+	}
+	return exitOK, encoder.Close()
+}
+
+// print writes value in the selected output format, text uses the value's String method.
+func (o *options) print(w io.Writer, value fmt.Stringer) error {
+	if o.output == "json" {
+		return printJSON(w, value)
+	}
+	text := value.String()
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	_, err := io.WriteString(w, text)
+	return err
+}
+
+// printJSON writes value as indented JSON.
+func printJSON(w io.Writer, value any) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/shoekstra/go-dbmanager/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveConnection(t *testing.T) {
+	flags := config.Connection{Host: "flaghost"}
+	file := config.Connection{Engine: "postgres", Host: "filehost", Port: "5433", Username: "fileuser"}
+	env := map[string]string{"DBMANAGER_HOST": "envhost", "DBMANAGER_USERNAME": "envuser", "DBMANAGER_PASSWORD": "envpassword"}
+
+	// Flags win over environment variables, which win over the config file
+	connection := resolveConnection(flags, file, func(key string) string { return env[key] })
+	assert.Equal(t, config.Connection{
+		Engine:   "postgres",
+		Host:     "flaghost",
+		Port:     "5433",
+		Username: "envuser",
+		Password: "envpassword",
+	}, connection)
+}
+
+func TestRun_Errors(t *testing.T) {
+	t.Setenv("DBMANAGER_CONFIG", "")
+	t.Setenv("DBMANAGER_ENGINE", "")
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte("databases:\n  - nme: mydb\n"), 0o600))
+
+	tests := []struct {
+		name   string
+		args   []string
+		stderr string
+	}{
+		{"no command", nil, "Usage: dbmanager <command> [flags]"},
+		{"unknown command", []string{"destroy"}, `unknown command "destroy"`},
+		{"no config", []string{"plan", "--engine", "postgres"}, "a config file is required"},
+		{"invalid config", []string{"apply", "--config", path}, `config.yaml:2:5: unknown field "nme" in databases[0]`},
+		{"no engine", []string{"export"}, "an engine is required"},
+		{"unknown output", []string{"export", "--engine", "postgres", "--output", "xml"}, `unsupported output format "xml"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			code := run(context.Background(), tt.args, &stdout, &stderr)
+			assert.Equal(t, exitError, code)
+			assert.Contains(t, stderr.String(), tt.stderr)
+			assert.Empty(t, stdout.String())
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configType is the type checked against the root of the document.
+var configType = reflect.TypeOf(Config{})
+
+// envPattern matches ${ENV_VAR} references in string values.
+var envPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Problem is a single problem found in a configuration file.
+type Problem struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+// String returns the problem prefixed with its location.
+func (p Problem) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s", p.File, p.Line, p.Column, p.Message)
+}
+
+// ValidationError lists every problem found in a configuration file.
+type ValidationError struct {
+	Problems []Problem
+}
+
+// Error returns every problem, one per line.
+func (e *ValidationError) Error() string {
+	lines := make([]string, len(e.Problems))
+	for i, problem := range e.Problems {
+		lines[i] = problem.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// checker walks a parsed document, expanding environment variables and recording every problem it finds.
+type checker struct {
+	file     string
+	problems []Problem
+}
+
+// problem records a problem found at node.
+func (c *checker) problem(node *yaml.Node, format string, args ...any) {
+	c.problems = append(c.problems, Problem{
+		File:    c.file,
+		Line:    node.Line,
+		Column:  node.Column,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// err returns a *ValidationError if any problems were found.
+func (c *checker) err() error {
+	if len(c.problems) == 0 {
+		return nil
+	}
+	return &ValidationError{Problems: c.problems}
+}
+
+// check checks that node can be decoded into a value of type t, using the JSON tags for field names, and
+// expands environment variables in string values. path is the location of node, used in messages.
+func (c *checker) check(node *yaml.Node, t reflect.Type, path string) {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Kind == yaml.ScalarNode && node.ShortTag() == "!!null" {
+		return
+	}
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			c.problem(node, "%s must be an object", describe(path))
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			field, ok := fieldByTag(t, key.Value)
+			if !ok {
+				c.problem(key, "unknown field %q in %s", key.Value, describe(path))
+				continue
+			}
+			c.check(value, field.Type, join(path, key.Value))
+		}
+
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			c.problem(node, "%s must be a list", describe(path))
+			return
+		}
+		for i, item := range node.Content {
+			c.check(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))
+		}
+
+	case reflect.String:
+		if node.Kind != yaml.ScalarNode {
+			c.problem(node, "%s must be a string", describe(path))
+			return
+		}
+		// Unquoted values such as ports are parsed as numbers, they're strings here
+		node.Tag = "!!str"
+		node.Value = c.expand(node, node.Value)
+
+	case reflect.Bool:
+		if node.Kind != yaml.ScalarNode || node.ShortTag() != "!!bool" {
+			c.problem(node, "%s must be true or false", describe(path))
+		}
+
+	case reflect.Int, reflect.Int64:
+		if node.Kind != yaml.ScalarNode || node.ShortTag() != "!!int" {
+			c.problem(node, "%s must be a whole number", describe(path))
+		}
+	}
+}
+
+// expand replaces ${ENV_VAR} references in value with the value of the environment variable, recording a
+// problem for each variable that isn't set.
+func (c *checker) expand(node *yaml.Node, value string) string {
+	return envPattern.ReplaceAllStringFunc(value, func(reference string) string {
+		name := envPattern.FindStringSubmatch(reference)[1]
+		expanded, ok := os.LookupEnv(name)
+		if !ok {
+			c.problem(node, "environment variable %s is not set", name)
+		}
+		return expanded
+	})
+}
+
+// fieldByTag returns the field of t with the JSON name name.
+func fieldByTag(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if tag == "" {
+			tag = field.Name
+		}
+		if tag == name {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// join returns the path to a field of the object at path.
+func join(path, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
+
+// describe returns how a path is referred to in messages.
+func describe(path string) string {
+	if path == "" {
+		return "the configuration"
+	}
+	return path
+}
+
+// validate records the problems with a decoded configuration that can't be found by checking the types,
+// root is the document it was decoded from and is used to find line numbers.
+func (c *checker) validate(cfg *Config, root *yaml.Node) {
+	databases := map[string]bool{}
+	for i, database := range cfg.Databases {
+		node := lookup(root, "databases", i)
+		if database.Name != "" && databases[database.Name] {
+			c.problem(node, "database %q is defined more than once", database.Name)
+		}
+		databases[database.Name] = true
+
+		c.invalid(node, database.Validate(cfg.Connection.Engine))
+	}
+
+	// A MySQL user can be listed once for each host, users without a host can connect from any host (%)
+	users := map[string]bool{}
+	for i, user := range cfg.Users {
+		node := lookup(root, "users", i)
+		name := user.Name
+		if cfg.Connection.Engine == "mysql" && user.Host != "" && user.Host != "%" {
+			name += "@" + user.Host
+		}
+		if user.Name != "" && users[name] {
+			c.problem(node, "user %q is defined more than once", name)
+		}
+		users[name] = true
+
+		c.invalid(node, user.Validate(cfg.Connection.Engine))
+	}
+}
+
+// invalid records a problem at node for each of the errors returned by a Validate method.
+func (c *checker) invalid(node *yaml.Node, err error) {
+	if err == nil {
+		return
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, err := range joined.Unwrap() {
+			c.invalid(node, err)
+		}
+		return
+	}
+	c.problem(node, "%v", err)
+}
+
+// lookup returns the node at the path made up of field names and list indexes, or the closest parent that
+// exists if the path doesn't.
+func lookup(node *yaml.Node, path ...any) *yaml.Node {
+	for _, step := range path {
+		var next *yaml.Node
+		switch step := step.(type) {
+		case string:
+			if node.Kind == yaml.MappingNode {
+				for i := 0; i+1 < len(node.Content); i += 2 {
+					if node.Content[i].Value == step {
+						next = node.Content[i+1]
+					}
+				}
+			}
+		case int:
+			if node.Kind == yaml.SequenceNode && step < len(node.Content) {
+				next = node.Content[step]
+			}
+		}
+		if next == nil {
+			return node
+		}
+		node = next
+	}
+	return node
+}
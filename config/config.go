@@ -0,0 +1,138 @@
+// Package config loads dbmanager configuration from JSON and YAML files.
+//
+// Both formats use the same field names as the JSON tags on the dbmanager types, unknown fields are
+// rejected and ${ENV_VAR} references in string values are replaced with the value of the environment
+// variable. Every problem found is returned together, with the file and line it was found on, before the
+// configuration is used.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/shoekstra/go-dbmanager"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the root of a configuration file.
+type Config struct {
+	// Connection configures the connection to the server.
+	Connection Connection `json:"connection"`
+
+	// Databases lists the databases to manage.
+	Databases []dbmanager.Database `json:"databases"`
+
+	// Users lists the users to manage.
+	Users []dbmanager.User `json:"users"`
+}
+
+// Connection configures the connection to the server, unset fields use the engine's defaults.
+type Connection struct {
+	Engine   string `json:"engine"`
+	Host     string `json:"host"`
+	Port     string `json:"port"`
+	Database string `json:"database"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	SSLMode  string `json:"sslmode"`
+	SSL      bool   `json:"ssl"`
+}
+
+// Options returns the connection options for the fields that are set.
+func (c Connection) Options() []func(*dbmanager.Connection) {
+	var options []func(*dbmanager.Connection)
+	if c.Host != "" {
+		options = append(options, dbmanager.WithHost(c.Host))
+	}
+	if c.Port != "" {
+		options = append(options, dbmanager.WithPort(c.Port))
+	}
+	if c.Database != "" {
+		options = append(options, dbmanager.WithDatabase(c.Database))
+	}
+	if c.Username != "" {
+		options = append(options, dbmanager.WithUsername(c.Username))
+	}
+	if c.Password != "" {
+		options = append(options, dbmanager.WithPassword(c.Password))
+	}
+	if c.SSLMode != "" {
+		options = append(options, func(conn *dbmanager.Connection) { conn.SSLMode = c.SSLMode })
+	}
+	if c.SSL {
+		options = append(options, dbmanager.WithSSL(c.SSL))
+	}
+	return options
+}
+
+// Manager returns a new, unconnected, manager for the configured connection. Any extra options are applied
+// after the options from the configuration.
+func (c *Config) Manager(options ...func(*dbmanager.Connection)) (dbmanager.Manager, error) {
+	return dbmanager.New(c.Connection.Engine, append(c.Connection.Options(), options...)...)
+}
+
+// Load reads a configuration file, the format is chosen using the file extension: .json, .yaml or .yml.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json", ".yaml", ".yml":
+	default:
+		return nil, fmt.Errorf("unsupported config file extension %q, expected .json, .yaml or .yml", ext)
+	}
+
+	return Parse(path, data)
+}
+
+// Parse parses configuration in JSON or YAML format, name is only used in error messages. If any problems
+// are found a *ValidationError listing all of them is returned.
+func Parse(name string, data []byte) (*Config, error) {
+	// YAML is a superset of JSON, so both formats are parsed as YAML to get line numbers
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	checker := &checker{file: name}
+	if len(root.Content) > 0 {
+		checker.check(root.Content[0], configType, "")
+	}
+	if err := checker.err(); err != nil {
+		return nil, err
+	}
+
+	// The document is known to match the types, so converting it to JSON and decoding it can't fail
+	// other than for values that are out of range
+	var cfg Config
+	if len(root.Content) == 0 {
+		return &cfg, nil
+	}
+
+	var value any
+	if err := root.Content[0].Decode(&value); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	checker.validate(&cfg, root.Content[0])
+	if err := checker.err(); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
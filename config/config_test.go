@@ -0,0 +1,134 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/shoekstra/go-dbmanager"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse_JSON(t *testing.T) {
+	t.Setenv("TEST_PASSWORD", "secret")
+
+	cfg, err := Parse("config.json", []byte(`{
+		"connection": {"engine": "postgres", "host": "localhost"},
+		"databases": [{"name": "mydb", "owner": "myuser"}],
+		"users": [{"name": "myuser", "password": "${TEST_PASSWORD}", "options": {"inherit": false}, "grants": [{"database": "mydb", "privileges": ["ALL"]}]}]
+	}`))
+	assert.NoError(t, err)
+	assert.Equal(t, Connection{Engine: "postgres", Host: "localhost"}, cfg.Connection)
+	assert.Equal(t, []dbmanager.Database{{Name: "mydb", Owner: "myuser"}}, cfg.Databases)
+	assert.Equal(t, "secret", cfg.Users[0].Password)
+	assert.Equal(t, dbmanager.UserOptions{Inherit: dbmanager.Bool(false)}, cfg.Users[0].Options, "Options that aren't set should be nil")
+	assert.Equal(t, []dbmanager.Grant{{Database: "mydb", Privileges: []string{"ALL"}}}, cfg.Users[0].Grants)
+}
+
+func TestParse_YAML(t *testing.T) {
+	t.Setenv("TEST_PASSWORD", "secret")
+
+	cfg, err := Parse("config.yaml", []byte(`
+connection:
+  engine: mysql
+  port: 3306
+users:
+  - name: myuser
+    password: pre-${TEST_PASSWORD}-post
+    grants:
+      - database: mydb
+        privileges: [SELECT, INSERT]
+        with_grant: true
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, "3306", cfg.Connection.Port)
+	assert.Equal(t, "pre-secret-post", cfg.Users[0].Password)
+	assert.Equal(t, []dbmanager.Grant{{Database: "mydb", Privileges: []string{"SELECT", "INSERT"}, WithGrant: true}}, cfg.Users[0].Grants)
+}
+
+func TestParse_Problems(t *testing.T) {
+	_, err := Parse("config.yaml", []byte(`
+databases:
+  - name: mydb
+    ownr: myuser
+users:
+  - name: myuser
+    password: ${TEST_MISSING_PASSWORD}
+    grants:
+      - database: mydb
+        with_grant: yes please
+`))
+
+	// Every problem should be reported with its location
+	var validationErr *ValidationError
+	assert.ErrorAs(t, err, &validationErr)
+	assert.Equal(t, []Problem{
+		{File: "config.yaml", Line: 4, Column: 5, Message: `unknown field "ownr" in databases[0]`},
+		{File: "config.yaml", Line: 7, Column: 15, Message: "environment variable TEST_MISSING_PASSWORD is not set"},
+		{File: "config.yaml", Line: 10, Column: 21, Message: "users[0].grants[0].with_grant must be true or false"},
+	}, validationErr.Problems)
+}
+
+func TestParse_Validation(t *testing.T) {
+	_, err := Parse("config.json", []byte(`{
+		"databases": [{"name": "mydb"}, {"name": "mydb"}],
+		"users": [{"grants": [{"database": "mydb"}]}]
+	}`))
+
+	var validationErr *ValidationError
+	assert.ErrorAs(t, err, &validationErr)
+	assert.Equal(t, []Problem{
+		{File: "config.json", Line: 2, Column: 35, Message: `database "mydb" is defined more than once`},
+		{File: "config.json", Line: 3, Column: 13, Message: "user: a user name is required"},
+		{File: "config.json", Line: 3, Column: 13, Message: "user: grants[0]: invalid grant: at least one privilege is required"},
+	}, validationErr.Problems)
+}
+
+func TestParse_UserHosts(t *testing.T) {
+	// A MySQL user can be listed once for each host it connects from
+	cfg, err := Parse("config.yml", []byte(`connection:
+  engine: mysql
+users:
+  - name: app
+    host: "10.0.%"
+  - name: app
+    host: localhost
+  - name: other
+`))
+	assert.NoError(t, err)
+	assert.Len(t, cfg.Users, 3)
+
+	// A user without a host is the same account as one on %
+	_, err = Parse("config.yml", []byte(`connection:
+  engine: mysql
+users:
+  - name: app
+  - name: app
+    host: "%"
+  - name: app
+    host: localhost
+  - name: app
+    host: localhost
+`))
+	var validationErr *ValidationError
+	assert.ErrorAs(t, err, &validationErr)
+	assert.Equal(t, []Problem{
+		{File: "config.yml", Line: 5, Column: 5, Message: `user "app" is defined more than once`},
+		{File: "config.yml", Line: 9, Column: 5, Message: `user "app@localhost" is defined more than once`},
+	}, validationErr.Problems)
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "config.yml")
+	assert.NoError(t, os.WriteFile(path, []byte("databases:\n  - name: mydb\n"), 0o600))
+	cfg, err := Load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []dbmanager.Database{{Name: "mydb"}}, cfg.Databases)
+
+	path = filepath.Join(dir, "config.toml")
+	assert.NoError(t, os.WriteFile(path, []byte(""), 0o600))
+	_, err = Load(path)
+	assert.Error(t, err, "Unsupported extensions should be rejected")
+}
@@ -1,8 +1,14 @@
 package dbmanager
 
+import (
+	"context"
+	"log/slog"
+)
+
 // Connector represents a database connection
 type Connector interface {
 	Connect() error
+	ConnectContext(ctx context.Context) error
 	Disconnect() error
 }
 
@@ -15,6 +21,9 @@ type Connection struct {
 	Password string
 	SSLMode  string
 	SSL      bool
+
+	// logger is used for all log output, set using WithLogger.
+	logger *slog.Logger
 }
 
 // WithHost sets the host in the connection configuration
@@ -58,3 +67,11 @@ func WithSSL(ssl bool) func(*Connection) {
 		c.SSL = ssl
 	}
 }
+
+// WithLogger sets the logger used by the manager, and any connections it opens, for all log output. If not
+// set the default slog logger is used. Passwords are never logged.
+func WithLogger(logger *slog.Logger) func(*Connection) {
+	return func(c *Connection) {
+		c.logger = logger
+	}
+}
@@ -0,0 +1,106 @@
+package dbmanager
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DriftType describes how a resource on the server differs from the provided configuration.
+type DriftType string
+
+const (
+	// DriftMissing means a resource is in the configuration but not on the server.
+	DriftMissing DriftType = "missing"
+
+	// DriftExtra means a resource is on the server but not in the configuration.
+	DriftExtra DriftType = "extra"
+
+	// DriftChanged means a resource is on the server but one of its attributes differs from the configuration.
+	DriftChanged DriftType = "changed"
+)
+
+// Drift is a single difference between the provided configuration and the server.
+type Drift struct {
+	// Resource identifies the object that has drifted, e.g. "user/myuser" or "database/mydb".
+	Resource string `json:"resource"`
+
+	// Type describes how the resource has drifted.
+	Type DriftType `json:"type"`
+
+	// Attribute is the attribute that differs, only set when Type is DriftChanged.
+	Attribute string `json:"attribute,omitempty"`
+
+	// Expected is the value in the configuration, only set when Type is DriftChanged.
+	Expected string `json:"expected,omitempty"`
+
+	// Actual is the value on the server, only set when Type is DriftChanged.
+	Actual string `json:"actual,omitempty"`
+}
+
+// String returns a human readable version of the drift.
+func (d Drift) String() string {
+	if d.Type == DriftChanged {
+		return fmt.Sprintf("%s %s: %s is %s, expected %s", d.Type, d.Resource, d.Attribute, d.Actual, d.Expected)
+	}
+	return fmt.Sprintf("%s %s", d.Type, d.Resource)
+}
+
+// DriftReport lists every difference found between the provided configuration and the server.
+type DriftReport struct {
+	// Drifted is true if any differences were found.
+	Drifted bool `json:"drifted"`
+
+	// Drifts lists the differences found, in the order they were found.
+	Drifts []Drift `json:"drifts"`
+}
+
+// newDriftReport returns an empty drift report.
+func newDriftReport() *DriftReport {
+	return &DriftReport{Drifts: []Drift{}}
+}
+
+// HasDrift returns true if the server differs from the provided configuration.
+func (r *DriftReport) HasDrift() bool {
+	return len(r.Drifts) > 0
+}
+
+// String returns a human readable version of the report, one difference per line.
+func (r *DriftReport) String() string {
+	if !r.HasDrift() {
+		return "No drift"
+	}
+
+	var sb strings.Builder
+	for _, drift := range r.Drifts {
+		sb.WriteString(drift.String())
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// missing records a resource that is in the configuration but not on the server.
+func (r *DriftReport) missing(resource string) {
+	r.add(Drift{Resource: resource, Type: DriftMissing})
+}
+
+// extra records a resource that is on the server but not in the configuration.
+func (r *DriftReport) extra(resource string) {
+	r.add(Drift{Resource: resource, Type: DriftExtra})
+}
+
+// changed records an attribute that differs between the configuration and the server.
+func (r *DriftReport) changed(resource, attribute string, expected, actual any) {
+	r.add(Drift{
+		Resource:  resource,
+		Type:      DriftChanged,
+		Attribute: attribute,
+		Expected:  fmt.Sprint(expected),
+		Actual:    fmt.Sprint(actual),
+	})
+}
+
+// add appends a difference to the report.
+func (r *DriftReport) add(drift Drift) {
+	r.Drifts = append(r.Drifts, drift)
+	r.Drifted = true
+}
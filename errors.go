@@ -0,0 +1,87 @@
+package dbmanager
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+var (
+	// ErrUserNotFound is returned when a user or role referenced in the configuration does not exist and
+	// won't be created.
+	ErrUserNotFound = errors.New("user does not exist")
+
+	// ErrOwnerNotFound is returned when the owner of a database does not exist and won't be created.
+	ErrOwnerNotFound = errors.New("owner does not exist")
+
+	// ErrInvalidGrant is returned when a grant or default privilege can't be turned into a statement.
+	ErrInvalidGrant = errors.New("invalid grant")
+
+	// ErrUnsupportedEngine is returned by New when the engine isn't supported.
+	ErrUnsupportedEngine = errors.New("unsupported database engine")
+
+	// ErrLocked is returned by Manage when another run against the same server holds the lock for longer
+	// than the lock timeout.
+	ErrLocked = errors.New("another run holds the lock")
+
+	// ErrRedactedPlan is returned by Apply when a plan decoded from JSON has changes whose secrets were
+	// masked when it was encoded, as running them would set the masked value.
+	ErrRedactedPlan = errors.New("plan has masked secrets and can't be applied")
+)
+
+// ResourceError is returned when a resource couldn't be planned or a statement failed when a plan was
+// applied. Use errors.As to inspect it and errors.Is to check the underlying error.
+type ResourceError struct {
+	// Resource identifies the object that failed, e.g. "user/myuser" or "database/mydb".
+	Resource string
+
+	// Statement is the statement that failed, with any secrets masked. Empty if the error happened while
+	// planning.
+	Statement string
+
+	// SQLState is the SQLSTATE code returned by the server, if any.
+	SQLState string
+
+	// Err is the underlying error.
+	Err error
+}
+
+// newResourceError wraps err with the resource and statement it relates to.
+func newResourceError(resource, statement string, err error) *ResourceError {
+	return &ResourceError{
+		Resource:  resource,
+		Statement: statement,
+		SQLState:  sqlState(err),
+		Err:       err,
+	}
+}
+
+// Error returns the error message.
+func (e *ResourceError) Error() string {
+	if e.Statement == "" {
+		return fmt.Sprintf("error planning %s: %v", e.Resource, e.Err)
+	}
+	return fmt.Sprintf("error applying change to %s: %v", e.Resource, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *ResourceError) Unwrap() error {
+	return e.Err
+}
+
+// sqlState returns the SQLSTATE code from a driver error, or an empty string if there isn't one.
+func sqlState(err error) string {
+	// pgx errors expose the code using a method
+	var pgErr interface{ SQLState() string }
+	if errors.As(err, &pgErr) {
+		return pgErr.SQLState()
+	}
+
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.SQLState != [5]byte{} {
+		return string(mysqlErr.SQLState[:])
+	}
+
+	return ""
+}
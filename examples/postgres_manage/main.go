@@ -1,33 +1,20 @@
 package main
 
 import (
-	"encoding/json"
-	"io"
 	"log"
-	"os"
 
-	"github.com/shoekstra/go-dbmanager"
+	"github.com/shoekstra/go-dbmanager/config"
 )
 
-type config struct {
-	Databases []dbmanager.Database `json:"databases"`
-	Users     []dbmanager.User     `json:"users"`
-}
-
 func main() {
-	// Open our config file
-	cfg, err := readConfigFile()
+	// Open our config file, this fails if the file contains any unknown fields or invalid values
+	cfg, err := config.Load("config.json")
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// Create a new database manager
-	dbm, err := dbmanager.New(
-		"postgres",
-		dbmanager.WithHost("localhost"),
-		dbmanager.WithUsername("postgres"),
-		dbmanager.WithPassword("password"),
-	)
+	// Create a new database manager using the connection in the config file
+	dbm, err := cfg.Manager()
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -43,17 +30,3 @@ func main() {
 		log.Fatal(err)
 	}
 }
-
-func readConfigFile() (*config, error) {
-	file, err := os.Open("config.json")
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
-	var cfg config
-	byteValue, _ := io.ReadAll(file)
-	json.Unmarshal(byteValue, &cfg)
-
-	return &cfg, nil
-}
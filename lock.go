@@ -0,0 +1,65 @@
+package dbmanager
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"time"
+)
+
+// lockName identifies the server-wide lock held by Manage.
+const lockName = "go-dbmanager"
+
+// defaultLockTimeout is how long Manage waits for the lock if WithLockTimeout isn't used.
+const defaultLockTimeout = time.Minute
+
+// lockTimeout returns the lock timeout from the settings, or the default if it isn't set.
+func lockTimeout(settings ManageSettings) time.Duration {
+	if settings.LockTimeout <= 0 {
+		return defaultLockTimeout
+	}
+	return settings.LockTimeout
+}
+
+// errLocked returns the error for a lock that couldn't be taken within timeout.
+func errLocked(timeout time.Duration) error {
+	return fmt.Errorf("%w: waited %s for lock %q", ErrLocked, timeout, lockName)
+}
+
+// discardConn closes a connection used to hold a lock without returning it to the pool, so neither the lock
+// nor any settings changed to take it are left on a pooled connection.
+func discardConn(conn *sql.Conn) {
+	// Returning ErrBadConn from Raw closes the connection instead of returning it to the pool
+	_ = conn.Raw(func(any) error { return driver.ErrBadConn })
+}
+
+// lockFunc takes the server-wide lock, waiting up to timeout, and returns the function that releases it.
+type lockFunc func(ctx context.Context, timeout time.Duration) (func(ctx context.Context) error, error)
+
+// withLock runs fn while holding the lock taken by lock, releasing it afterwards even if ctx is cancelled.
+func withLock(ctx context.Context, lock lockFunc, timeout time.Duration, fn func() error) error {
+	unlock, err := lock(ctx, timeout)
+	if err != nil {
+		return err
+	}
+
+	err = fn()
+	if unlockErr := unlock(context.WithoutCancel(ctx)); unlockErr != nil && err == nil {
+		err = fmt.Errorf("error releasing lock: %w", unlockErr)
+	}
+	return err
+}
+
+// applyLocked runs apply while holding the lock taken by lock, waiting up to the plan's lock timeout. Plans
+// decoded from JSON don't have a lock timeout so wait for the default.
+func applyLocked(ctx context.Context, lock lockFunc, plan *Plan, apply func() (*Report, error)) (*Report, error) {
+	report := newReport()
+	err := withLock(ctx, lock, cmp.Or(plan.lockTimeout, defaultLockTimeout), func() error {
+		var err error
+		report, err = apply()
+		return err
+	})
+	return report, err
+}
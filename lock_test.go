@@ -0,0 +1,76 @@
+package dbmanager
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithLock(t *testing.T) {
+	var unlocked int
+	lock := func(ctx context.Context, timeout time.Duration) (func(ctx context.Context) error, error) {
+		assert.Equal(t, time.Second, timeout)
+		return func(ctx context.Context) error {
+			unlocked++
+			assert.NoError(t, ctx.Err(), "The lock should be released with a context that isn't cancelled")
+			return errors.New("unlock failed")
+		}, nil
+	}
+
+	// The lock is released when fn fails and fn's error is returned
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := withLock(ctx, lock, time.Second, func() error { return context.Canceled })
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, unlocked)
+
+	// A failure to release the lock is returned when fn succeeds
+	err = withLock(context.Background(), lock, time.Second, func() error { return nil })
+	assert.ErrorContains(t, err, "error releasing lock: unlock failed")
+	assert.Equal(t, 2, unlocked)
+
+	// fn isn't run when the lock can't be taken
+	locked := func(ctx context.Context, timeout time.Duration) (func(ctx context.Context) error, error) {
+		return nil, errLocked(timeout)
+	}
+	err = withLock(context.Background(), locked, time.Second, func() error {
+		t.Error("fn should not run")
+		return nil
+	})
+	assert.ErrorIs(t, err, ErrLocked)
+	assert.EqualError(t, err, `another run holds the lock: waited 1s for lock "go-dbmanager"`)
+
+	assert.Equal(t, defaultLockTimeout, lockTimeout(NewManageSettings()))
+	assert.Equal(t, time.Second, lockTimeout(NewManageSettings(WithLockTimeout(time.Second))))
+}
+
+func TestApplyLocked(t *testing.T) {
+	var timeouts []time.Duration
+	lock := func(ctx context.Context, timeout time.Duration) (func(ctx context.Context) error, error) {
+		timeouts = append(timeouts, timeout)
+		return func(ctx context.Context) error { return nil }, nil
+	}
+	apply := func() (*Report, error) { return &Report{Resources: []ResourceReport{{Resource: "user/myuser"}}}, nil }
+
+	// The plan's lock timeout is used, plans that don't have one wait for the default
+	report, err := applyLocked(context.Background(), lock, NewPlan(WithLockTimeout(time.Second)), apply)
+	assert.NoError(t, err)
+	assert.Len(t, report.Resources, 1)
+	_, err = applyLocked(context.Background(), lock, &Plan{}, apply)
+	assert.NoError(t, err)
+	assert.Equal(t, []time.Duration{time.Second, defaultLockTimeout}, timeouts)
+
+	// Nothing is applied when the lock can't be taken, but a report is still returned
+	locked := func(ctx context.Context, timeout time.Duration) (func(ctx context.Context) error, error) {
+		return nil, errLocked(timeout)
+	}
+	report, err = applyLocked(context.Background(), locked, NewPlan(), func() (*Report, error) {
+		t.Error("apply should not run")
+		return nil, nil
+	})
+	assert.ErrorIs(t, err, ErrLocked)
+	assert.NotNil(t, report)
+}
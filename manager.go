@@ -1,9 +1,13 @@
 package dbmanager
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"log/slog"
+	"path"
 	"strings"
+	"time"
 )
 
 // Manager is the main interface for managing database servers
@@ -12,22 +16,221 @@ type Manager interface {
 	CreateDatabase(databaseConfig Database) error
 	CreateUser(userConfig User) error
 	GrantPermissions(user User) error
-	Manage(databases []Database, users []User) error
+	Manage(databases []Database, users []User, options ...ManageOption) error
+	Plan(databases []Database, users []User, options ...ManageOption) (*Plan, error)
+	Apply(plan *Plan) (*Report, error)
+	Diff(databases []Database, users []User) (*DriftReport, error)
+	Export() ([]Database, []User, error)
+	DropDatabase(name string, options ...DropOption) error
+	DropUser(name string, options ...DropOption) error
+	RevokePermissions(user User, grants []Grant) error
+
+	CreateDatabaseContext(ctx context.Context, databaseConfig Database) error
+	CreateUserContext(ctx context.Context, userConfig User) error
+	GrantPermissionsContext(ctx context.Context, user User) error
+	ManageContext(ctx context.Context, databases []Database, users []User, options ...ManageOption) error
+	PlanContext(ctx context.Context, databases []Database, users []User, options ...ManageOption) (*Plan, error)
+	ApplyContext(ctx context.Context, plan *Plan) (*Report, error)
+	DiffContext(ctx context.Context, databases []Database, users []User) (*DriftReport, error)
+	ExportContext(ctx context.Context) ([]Database, []User, error)
+	DropDatabaseContext(ctx context.Context, name string, options ...DropOption) error
+	DropUserContext(ctx context.Context, name string, options ...DropOption) error
+	RevokePermissionsContext(ctx context.Context, user User, grants []Grant) error
 }
 
-// databaseManager is the internal implementation of the Manager interface
-type databaseManager struct {
+// BaseManager holds the plumbing shared by every engine: the connection configuration, the connection to the
+// server and the logger. Engines embed it in their Manager implementation.
+type BaseManager struct {
 	connection Connection
 	db         *sql.DB
+	engine     string
+}
+
+// NewBaseManager returns the plumbing for an engine, using defaults as the connection configuration with the
+// provided options applied on top.
+func NewBaseManager(engine string, defaults Connection, options ...func(*Connection)) BaseManager {
+	m := BaseManager{connection: defaults, engine: engine}
+	m.initialize(options...)
+	return m
 }
 
 // initialize initializes the database manager connection with the provided options.
-func (m *databaseManager) initialize(options ...func(*Connection)) {
+func (m *BaseManager) initialize(options ...func(*Connection)) {
 	for _, option := range options {
 		option(&m.connection)
 	}
 }
 
+// Engine returns the name the engine was registered with.
+func (m *BaseManager) Engine() string {
+	return m.engine
+}
+
+// Connection returns the connection configuration.
+func (m *BaseManager) Connection() Connection {
+	return m.connection
+}
+
+// DB returns the connection to the server, or nil if not connected.
+func (m *BaseManager) DB() *sql.DB {
+	return m.db
+}
+
+// SetDB sets the connection to the server, engines call this once they've connected.
+func (m *BaseManager) SetDB(db *sql.DB) {
+	m.db = db
+}
+
+// ManageOption configures a single Manage or Plan run.
+type ManageOption func(*ManageSettings)
+
+// ManageSettings holds the settings for a single Manage or Plan run, engines use NewManageSettings to read
+// the options they were passed.
+type ManageSettings struct {
+	// Prune is set by WithPrune.
+	Prune bool
+
+	// PruneExclude lists the patterns set by WithPrune.
+	PruneExclude []string
+
+	// Report is set by WithReport.
+	Report *Report
+
+	// ContinueOnError is set by WithContinueOnError.
+	ContinueOnError bool
+
+	// Concurrency is set by WithConcurrency.
+	Concurrency int
+
+	// LockTimeout is set by WithLockTimeout, if it isn't set Manage and Apply wait for up to a minute.
+	LockTimeout time.Duration
+}
+
+// WithPrune removes users, databases and grants that are on the server but not in the provided configuration.
+// Users and databases matching one of the exclude patterns (see path.Match), the engine's system users and
+// databases and the user we're connected as are never removed.
+func WithPrune(exclude ...string) ManageOption {
+	return func(o *ManageSettings) {
+		o.Prune = true
+		o.PruneExclude = append(o.PruneExclude, exclude...)
+	}
+}
+
+// WithReport fills report with what was done when Manage is run, including when Manage returns an error.
+func WithReport(report *Report) ManageOption {
+	return func(o *ManageSettings) {
+		o.Report = report
+	}
+}
+
+// WithContinueOnError keeps going when a user, database or statement fails instead of stopping at the first
+// failure. Every failure is returned together, use errors.As with *ResourceError to inspect them, and
+// anything that depends on a failed change will most likely fail too. When passed to Plan the plan is
+// returned along with the errors for the resources that couldn't be planned.
+func WithContinueOnError() ManageOption {
+	return func(o *ManageSettings) {
+		o.ContinueOnError = true
+	}
+}
+
+// WithConcurrency plans and applies the work for up to n databases at a time instead of one at a time. Users
+// and roles are still planned and created first, then databases and then grants, and each database's
+// changes are run in order. The plan, report and errors are listed in the same order as when running one at
+// a time, whichever database finishes first.
+func WithConcurrency(n int) ManageOption {
+	return func(o *ManageSettings) {
+		o.Concurrency = n
+	}
+}
+
+// WithLockTimeout sets how long Manage waits for another run against the same server to finish. Manage holds
+// a server-wide lock while it plans and applies changes, so two runs can't undo each other's changes, and
+// returns ErrLocked if the lock is still held after timeout. Apply holds the same lock while it applies a
+// plan, waiting for the timeout the plan was created with.
+func WithLockTimeout(timeout time.Duration) ManageOption {
+	return func(o *ManageSettings) {
+		o.LockTimeout = timeout
+	}
+}
+
+// NewManageSettings returns the settings for a run with the provided options applied.
+func NewManageSettings(options ...ManageOption) ManageSettings {
+	var o ManageSettings
+	for _, option := range options {
+		option(&o)
+	}
+	return o
+}
+
+// DropOption configures a DropDatabase or DropUser call.
+type DropOption func(*DropSettings)
+
+// DropSettings holds the settings for a DropDatabase or DropUser call, engines use NewDropSettings to read
+// the options they were passed.
+type DropSettings struct {
+	// TerminateConnections is set by WithTerminateConnections.
+	TerminateConnections bool
+
+	// ReassignOwnedTo is set by WithReassignOwnedTo.
+	ReassignOwnedTo string
+
+	// DropOwnedObjects is set by WithDropOwnedObjects.
+	DropOwnedObjects bool
+}
+
+// WithTerminateConnections terminates any connections to a database before it is dropped. Applicable to
+// PostgreSQL only.
+func WithTerminateConnections() DropOption {
+	return func(o *DropSettings) {
+		o.TerminateConnections = true
+	}
+}
+
+// WithReassignOwnedTo reassigns objects owned by a user to the specified role before the user is dropped.
+// By default objects are reassigned to the user we're connected as. Applicable to PostgreSQL only.
+func WithReassignOwnedTo(role string) DropOption {
+	return func(o *DropSettings) {
+		o.ReassignOwnedTo = role
+	}
+}
+
+// WithDropOwnedObjects drops objects owned by a user instead of reassigning them before the user is dropped.
+// Applicable to PostgreSQL only.
+func WithDropOwnedObjects() DropOption {
+	return func(o *DropSettings) {
+		o.DropOwnedObjects = true
+	}
+}
+
+// NewDropSettings returns the settings for a drop call with the provided options applied.
+func NewDropSettings(options ...DropOption) DropSettings {
+	var o DropSettings
+	for _, option := range options {
+		option(&o)
+	}
+	return o
+}
+
+// matchesAny returns true if name matches one of the patterns (see path.Match).
+func matchesAny(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Logger returns the logger set with WithLogger, or the default slog logger, with the engine and host
+// attached.
+func (m *BaseManager) Logger() *slog.Logger {
+	logger := m.connection.logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return logger.With("engine", m.engine, "host", m.connection.Host)
+}
+
 // Database represents the configuration for creating a database
 type Database struct {
 	Name              string             `json:"name"`
@@ -59,6 +262,15 @@ type Grant struct {
 	// Optional: Specify the target table
 	Table string `json:"table"`
 
+	// Optional: Specify the columns of the table the privileges apply to, e.g. SELECT (col1, col2)
+	Columns []string `json:"columns,omitempty"`
+
+	// Optional: Specify the target function, PostgreSQL functions must not be overloaded
+	Function string `json:"function,omitempty"`
+
+	// Optional: Specify the target procedure, PostgreSQL procedures must not be overloaded
+	Procedure string `json:"procedure,omitempty"`
+
 	// Optional: Specify the target parameter (PostgreSQL only)
 	Parameter string `json:"parameter"`
 
@@ -69,49 +281,152 @@ type Grant struct {
 	WithGrant bool `json:"with_grant"`
 }
 
-// UserOptions represents the configuration for creating a user
+// UserOptions represents the configuration for creating a user. Options that are nil aren't managed: new
+// users get the server's default and existing users are left as they are. Use Bool, Int and String to set
+// an option.
 type UserOptions struct {
-	// Login specifies whether the user is allowed to log in to the database. Applicable to PostgreSQL only.
-	Login bool `json:"login"`
+	// Login specifies whether the user is allowed to log in to the database. If unset, users with a password
+	// are allowed to log in. Applicable to PostgreSQL only.
+	Login *bool `json:"login,omitempty"`
 
 	// Superuser specifies whether the user will be a superuser. Applicable to PostgreSQL only.
-	Superuser bool `json:"superuser"`
+	Superuser *bool `json:"superuser,omitempty"`
 
 	// CreateDatabase specifies whether the user will be allowed to create databases. Applicable to PostgreSQL only.
-	CreateDatabase bool `json:"create_database"`
+	CreateDatabase *bool `json:"create_database,omitempty"`
 
 	// CreateRole specifies whether the user will be allowed to create roles. Applicable to PostgreSQL only.
-	CreateRole bool `json:"create_role"`
+	CreateRole *bool `json:"create_role,omitempty"`
 
 	// Inherit specifies whether the user will inherit privileges of roles that it is a member of. Applicable to PostgreSQL only.
-	Inherit bool `json:"inherit"`
+	Inherit *bool `json:"inherit,omitempty"`
 
 	// Replication specifies whether the user will be allowed to initiate streaming replication. Applicable to PostgreSQL only.
-	Replication bool `json:"replication"`
+	Replication *bool `json:"replication,omitempty"`
 
 	// BypassRLS specifies whether the user will be allowed to bypass row level security policies. Applicable to PostgreSQL only.
-	BypassRLS bool `json:"bypass_rls"`
+	BypassRLS *bool `json:"bypass_rls,omitempty"`
+
+	// MaxQueriesPerHour limits the number of queries the user can run each hour, 0 means no limit. Applicable
+	// to MySQL only.
+	MaxQueriesPerHour *int `json:"max_queries_per_hour,omitempty"`
+
+	// MaxUserConnections limits the number of connections the user can have open at once, 0 means the
+	// server's max_user_connections applies. Applicable to MySQL only.
+	MaxUserConnections *int `json:"max_user_connections,omitempty"`
+
+	// AccountLocked specifies whether the account is locked so the user can't log in. Applicable to MySQL only.
+	AccountLocked *bool `json:"account_locked,omitempty"`
+
+	// PasswordExpireDays is the number of days a password is valid for, 0 means it never expires and -1 that
+	// the server's default_password_lifetime applies. Applicable to MySQL only.
+	PasswordExpireDays *int `json:"password_expire_days,omitempty"`
+
+	// FailedLoginAttempts is the number of failed logins in a row after which the account is locked for
+	// PasswordLockTime days, 0 disables locking. Applicable to MySQL 8.0.19 and later only.
+	FailedLoginAttempts *int `json:"failed_login_attempts,omitempty"`
+
+	// PasswordLockTime is the number of days the account is locked for after too many failed logins, -1 locks
+	// it until it's unlocked. Applicable to MySQL 8.0.19 and later only.
+	PasswordLockTime *int `json:"password_lock_time,omitempty"`
+
+	// Require is the kind of connection the user must connect with: "NONE", "SSL" or "X509". Applicable to
+	// MySQL only.
+	Require *string `json:"require,omitempty"`
+
+	// RequireSubject requires the user to connect with an X.509 certificate with this subject, e.g.
+	// "/CN=myuser", and can't be combined with Require. Applicable to MySQL only.
+	RequireSubject *string `json:"require_subject,omitempty"`
+}
+
+// Bool returns a pointer to value, for setting UserOptions.
+func Bool(value bool) *bool {
+	return &value
+}
+
+// Int returns a pointer to value, for setting UserOptions.
+func Int(value int) *int {
+	return &value
+}
+
+// String returns a pointer to value, for setting UserOptions.
+func String(value string) *string {
+	return &value
 }
 
 // User represents the configuration for creating a user
 type User struct {
-	Name     string      `json:"name"`
-	Password string      `json:"password"`
-	Options  UserOptions `json:"options"`
-	Grants   []Grant     `json:"grants"`
-	Roles    []string    `json:"roles"`
+	Name string `json:"name"`
+
+	// Host is the host the user can connect from, e.g. "localhost" or "10.0.%". If not set the user can
+	// connect from any host (%). Applicable to MySQL only, list a user once for each host to manage the
+	// user on several hosts.
+	Host string `json:"host,omitempty"`
+
+	Password string `json:"password"`
+
+	// AuthPlugin is the plugin the user authenticates with, e.g. "caching_sha2_password",
+	// "mysql_native_password" or "auth_socket". If not set new users get the server's default plugin and
+	// existing users keep theirs. Users without a password authenticate with the plugin alone. Applicable to
+	// MySQL only.
+	AuthPlugin string `json:"auth_plugin,omitempty"`
+
+	Options UserOptions `json:"options"`
+	Grants  []Grant     `json:"grants"`
+
+	// Roles are the roles the user is a member of, roles the user is a member of that aren't listed are
+	// removed. For MySQL, roles that don't exist are created and every role is made a default role so it's
+	// active when the user logs in. A MySQL role on a specific host can be given as "name@host".
+	Roles []string `json:"roles"`
 }
 
-// New creates a new Manager instance based on the provided engine.
+// New creates a new Manager instance based on the provided engine, see Register for adding engines.
 func New(engine string, options ...func(*Connection)) (Manager, error) {
-	switch engine {
-	case "mysql":
-		return newMySQLManager(options...), nil
-	case "postgres":
-		return newPostgresManager(options...), nil
-	default:
-		return nil, fmt.Errorf("unsupported database engine: %s", engine)
+	enginesMu.RLock()
+	factory, ok := engines[engine]
+	enginesMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedEngine, engine)
+	}
+
+	return factory(options...), nil
+}
+
+// QuoteLiteral quotes a "literal" (e.g. a parameter, often used to pass literal
+// to DDL and other statements that do not accept parameters) to be used as part
+// of an SQL statement.  For example:
+//
+//	exp_date := pq.QuoteLiteral("2023-01-05 15:00:00Z")
+//	err := db.Exec(fmt.Sprintf("CREATE ROLE my_user VALID UNTIL %s", exp_date))
+//
+// Any single quotes in name will be escaped. Any backslashes (i.e. "\") will be
+// replaced by two backslashes (i.e. "\\") and the C-style escape identifier
+// that PostgreSQL provides ('E') will be prepended to the string.
+//
+// This is a copy of the PostgreSQL libpq function so that we don't need to
+// import the entire pq package.
+func QuoteLiteral(literal string) string {
+	// This follows the PostgreSQL internal algorithm for handling quoted literals
+	// from libpq, which can be found in the "PQEscapeStringInternal" function,
+	// which is found in the libpq/fe-exec.c source file:
+	// https://git.postgresql.org/gitweb/?p=postgresql.git;a=blob;f=src/interfaces/libpq/fe-exec.c
+	//
+	// substitute any single-quotes (') with two single-quotes ('')
+	literal = strings.Replace(literal, `'`, `''`, -1)
+	// determine if the string has any backslashes (\) in it.
+	// if it does, replace any backslashes (\) with two backslashes (\\)
+	// then, we need to wrap the entire string with a PostgreSQL
+	// C-style escape. Per how "PQEscapeStringInternal" handles this case, we
+	// also add a space before the "E"
+	if strings.Contains(literal, `\`) {
+		literal = strings.Replace(literal, `\`, `\\`, -1)
+		literal = ` E'` + literal + `'`
+	} else {
+		// otherwise, we can just wrap the literal with a pair of single quotes
+		literal = `'` + literal + `'`
 	}
+	return literal
 }
 
 // QuoteIdentifier quotes an "identifier" (e.g. a table or a column name) to be
@@ -0,0 +1,31 @@
+package dbmanager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuoteIdentifier(t *testing.T) {
+	assert.Equal(t, `"mydb"`, QuoteIdentifier("mydb"))
+	assert.Equal(t, `"My""Db"`, QuoteIdentifier(`My"Db`))
+	assert.Equal(t, `"mydb"`, QuoteIdentifier("mydb\x00; DROP DATABASE postgres"))
+}
+
+func TestQuoteLiteral(t *testing.T) {
+	assert.Equal(t, `'secret'`, QuoteLiteral("secret"))
+	assert.Equal(t, `'it''s'`, QuoteLiteral("it's"))
+	assert.Equal(t, ` E'back\\slash'`, QuoteLiteral(`back\slash`))
+}
+
+func TestDiff_Validate(t *testing.T) {
+	users := []User{{Name: "myuser", Grants: []Grant{{Privileges: []string{"SELECT"}}}}}
+
+	// The config is validated before the server is queried, so no connection is needed
+	for _, engine := range []string{"postgres", "mysql"} {
+		manager, err := New(engine)
+		assert.NoError(t, err)
+		_, err = manager.Diff(nil, users)
+		assert.ErrorIs(t, err, ErrInvalidGrant, engine)
+	}
+}
@@ -1,40 +1,47 @@
 package dbmanager
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"log"
+	"math"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 )
 
+func init() {
+	Register("mysql", newMySQLManager)
+}
+
 type mysqlManager struct {
-	databaseManager
+	BaseManager
 }
 
 // newMySQLManager creates a new MySQL manager.
 func newMySQLManager(options ...func(*Connection)) Manager {
-	manager := &mysqlManager{
-		databaseManager: databaseManager{
-			connection: Connection{
-				Port: "3306",
-			},
-		},
+	return &mysqlManager{
+		BaseManager: NewBaseManager("mysql", Connection{Port: "3306"}, options...),
 	}
-	manager.initialize(options...)
-	return manager
 }
 
 // Connect connects to the MySQL server.
 func (m *mysqlManager) Connect() error {
-	log.Printf("Connecting to %s:%s as %s\n", m.connection.Host, m.connection.Port, m.connection.Username)
+	return m.ConnectContext(context.Background())
+}
+
+// ConnectContext connects to the MySQL server using the provided context.
+func (m *mysqlManager) ConnectContext(ctx context.Context) error {
+	m.Logger().Info("Connecting", "port", m.connection.Port, "user", m.connection.Username)
 	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/", m.connection.Username, m.connection.Password, m.connection.Host, m.connection.Port)
 	db, err := sql.Open("mysql", dsn)
 	if err != nil {
 		return fmt.Errorf("failed to connect to MySQL: %w", err)
 	}
 
-	if err := db.Ping(); err != nil {
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
 		return fmt.Errorf("failed to ping MySQL: %w", err)
 	}
 
@@ -44,7 +51,7 @@ func (m *mysqlManager) Connect() error {
 
 // Disconnect disconnects from the MySQL server.
 func (m *mysqlManager) Disconnect() error {
-	log.Printf("Disconnecting from %s:%s\n", m.connection.Host, m.connection.Port)
+	m.Logger().Info("Disconnecting", "port", m.connection.Port)
 
 	if m.db == nil {
 		return nil
@@ -58,23 +65,146 @@ func (m *mysqlManager) Disconnect() error {
 }
 
 // Manage manages the databases and users based on the provided options.
-func (m *mysqlManager) Manage(databases []Database, users []User) error {
-	log.Println("Managing databases and users")
+func (m *mysqlManager) Manage(databases []Database, users []User, options ...ManageOption) error {
+	return m.ManageContext(context.Background(), databases, users, options...)
+}
+
+// ManageContext is like Manage but uses the provided context for all queries.
+func (m *mysqlManager) ManageContext(ctx context.Context, databases []Database, users []User, options ...ManageOption) error {
+	m.Logger().Info("Managing databases and users", "action", "manage")
 
-	for _, db := range databases {
-		if err := m.CreateDatabase(db); err != nil {
-			return err
+	settings := NewManageSettings(options...)
+	return withLock(ctx, m.lock, lockTimeout(settings), func() error {
+		plan, planErr := m.PlanContext(ctx, databases, users, options...)
+		if plan == nil {
+			return planErr
 		}
+
+		report, err := m.apply(ctx, plan)
+		if settings.Report != nil {
+			*settings.Report = *report
+		}
+
+		return errors.Join(planErr, err)
+	})
+}
+
+// lock takes the named lock that stops two runs managing the server at the same time, waiting up to timeout
+// for another run to release it. The lock is held by its own connection until the returned function is
+// called.
+func (m *mysqlManager) lock(ctx context.Context, timeout time.Duration) (func(ctx context.Context) error, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to take lock: %w", err)
+	}
+
+	// GET_LOCK returns 1 once the lock is taken, 0 if it timed out and NULL on error. The timeout is in
+	// whole seconds and a negative timeout waits forever, so it's at least a second.
+	m.Logger().Debug("Waiting for lock", "lock", lockName, "timeout", timeout)
+	var locked sql.NullInt64
+	seconds := max(int64(math.Ceil(timeout.Seconds())), 1)
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", lockName, seconds).Scan(&locked); err != nil {
+		discardConn(conn)
+		return nil, fmt.Errorf("failed to take lock: %w", err)
+	}
+	if !locked.Valid {
+		discardConn(conn)
+		return nil, errors.New("failed to take lock: GET_LOCK returned NULL")
+	}
+	if locked.Int64 != 1 {
+		discardConn(conn)
+		return nil, errLocked(timeout)
 	}
 
+	return func(ctx context.Context) error {
+		defer discardConn(conn)
+		_, err := conn.ExecContext(ctx, "SELECT RELEASE_LOCK(?)", lockName)
+		return err
+	}, nil
+}
+
+// Plan returns the changes needed to manage the databases and users based on the provided options
+// without running any of them.
+func (m *mysqlManager) Plan(databases []Database, users []User, options ...ManageOption) (*Plan, error) {
+	return m.PlanContext(context.Background(), databases, users, options...)
+}
+
+// PlanContext is like Plan but uses the provided context for all queries.
+func (m *mysqlManager) PlanContext(ctx context.Context, databases []Database, users []User, options ...ManageOption) (*Plan, error) {
+	if err := validate(m.engine, databases, users); err != nil {
+		return nil, err
+	}
+
+	plan := NewPlan(options...)
+	settings := NewManageSettings(options...)
+
+	// The catalog is shared by the databases and users planned in parallel
+	m.catalog(plan)
+
+	err := plan.parallel(resourceNames("database", databases, func(database Database) string { return database.Name }), func(plan *Plan, i int) error {
+		return m.planDatabase(ctx, plan, databases[i])
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Create users, then any roles that aren't users so every account exists before it's granted
+	accountNames := resourceNames("user", users, func(user User) string { return mysqlAccountName(user.Name, mysqlHost(user)) })
+	for i, user := range users {
+		err := plan.try(accountNames[i], func() error {
+			return m.planUser(ctx, plan, user)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
 	for _, user := range users {
-		if err := m.CreateUser(user); err != nil {
-			return err
+		for _, role := range user.Roles {
+			err := plan.try(resource("role", mysqlRoleName(role)), func() error {
+				return m.createRole(ctx, plan, mysqlRoleName(role))
+			})
+			if err != nil {
+				return nil, err
+			}
 		}
-		if err := m.GrantPermissions(user); err != nil {
-			return err
+	}
+
+	// Grant permissions and roles
+	err = plan.parallel(accountNames, func(plan *Plan, i int) error {
+		return m.planPermissions(ctx, plan, users[i])
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Remove anything that isn't in the config
+	if settings.Prune {
+		if err := m.planPrune(ctx, plan, databases, users, settings.PruneExclude); err != nil {
+			return nil, err
 		}
 	}
 
-	return nil
+	return plan, plan.err()
+}
+
+// Apply runs the changes in the plan in order and returns a report of what was done.
+func (m *mysqlManager) Apply(plan *Plan) (*Report, error) {
+	return m.ApplyContext(context.Background(), plan)
+}
+
+// ApplyContext is like Apply but uses the provided context for all queries. The server-wide lock taken by
+// Manage is held while the plan is applied.
+func (m *mysqlManager) ApplyContext(ctx context.Context, plan *Plan) (*Report, error) {
+	return applyLocked(ctx, m.lock, plan, func() (*Report, error) {
+		return m.apply(ctx, plan)
+	})
+}
+
+// apply runs the changes in the plan, the caller must hold the server-wide lock.
+func (m *mysqlManager) apply(ctx context.Context, plan *Plan) (*Report, error) {
+	return ApplyPlan(ctx, plan, func(ctx context.Context, change Change) error {
+		m.Logger().Info("Applying change", "action", change.Action, "resource", change.Resource, "sql", change.displaySQL())
+		_, err := m.db.ExecContext(ctx, change.SQL)
+		return err
+	})
 }
@@ -0,0 +1,421 @@
+package dbmanager
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// mysqlPrivilege is a privilege an account has on an object. The kind and name of the object are those
+// returned by mysqlGrantObject, column privileges also have the column they apply to.
+type mysqlPrivilege struct {
+	kind      string
+	database  string
+	name      string
+	column    string
+	privilege string
+	grantable bool
+}
+
+// grant returns the grant that grants the privilege.
+func (p mysqlPrivilege) grant() Grant {
+	grant := Grant{Database: p.database, Privileges: []string{p.privilege}}
+	switch p.kind {
+	case "table":
+		grant.Table = p.name
+	case "column":
+		grant.Table, grant.Columns = p.name, []string{p.column}
+	case "function":
+		grant.Function = p.name
+	case "procedure":
+		grant.Procedure = p.name
+	}
+	return grant
+}
+
+// mysqlCatalog is an in-memory view of the accounts, databases and privileges on the server, so
+// planning doesn't need a query for each check. It's loaded the first time it's needed and isn't updated
+// when changes are applied.
+type mysqlCatalog struct {
+	manager *mysqlManager
+
+	mu        sync.Mutex
+	loaded    bool
+	accounts  map[string][]string
+	databases map[string]bool
+
+	// plugins maps each grantee to the authentication plugin of its account.
+	plugins map[string]string
+
+	// privileges maps each grantee, in the 'name'@'host' form used by INFORMATION_SCHEMA, to its
+	// privileges sorted by database, object and privilege.
+	privileges map[string][]mysqlPrivilege
+
+	// memberships and defaults map each grantee to the roles granted to it and its default roles, named as
+	// they're named in User.Roles. grantedRoles records every account that is granted as a role.
+	memberships  map[string][]string
+	defaults     map[string][]string
+	grantedRoles map[string]bool
+
+	// options maps each grantee to the options of its account. They're loaded separately, the first time
+	// they're needed, so servers that don't have every column they're read from can still be managed.
+	optionsLoaded bool
+	options       map[string]UserOptions
+}
+
+// mysqlGrantee returns an account in the 'name'@'host' form used by INFORMATION_SCHEMA.
+func mysqlGrantee(name, host string) string {
+	return fmt.Sprintf("'%s'@'%s'", name, host)
+}
+
+// newMySQLCatalog returns an empty catalog that's loaded using the manager's connection.
+func newMySQLCatalog(m *mysqlManager) *mysqlCatalog {
+	return &mysqlCatalog{manager: m}
+}
+
+// catalog returns the catalog used while planning, each plan has its own.
+func (m *mysqlManager) catalog(plan *Plan) *mysqlCatalog {
+	if catalog, ok := plan.catalog.(*mysqlCatalog); ok {
+		return catalog
+	}
+
+	catalog := newMySQLCatalog(m)
+	plan.catalog = catalog
+	return catalog
+}
+
+// load loads the accounts, databases, privileges and roles if they haven't been already.
+func (c *mysqlCatalog) load(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.loaded {
+		return nil
+	}
+
+	db := c.manager.db
+	c.accounts = map[string][]string{}
+	c.plugins = map[string]string{}
+	c.databases = map[string]bool{}
+	c.privileges = map[string][]mysqlPrivilege{}
+
+	rows, err := db.QueryContext(ctx, "SELECT User, Host, plugin FROM mysql.user")
+	if err != nil {
+		return fmt.Errorf("failed to load users: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, host, plugin string
+		if err := rows.Scan(&name, &host, &plugin); err != nil {
+			return err
+		}
+		c.accounts[name] = append(c.accounts[name], host)
+		c.plugins[mysqlGrantee(name, host)] = plugin
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = db.QueryContext(ctx, "SELECT SCHEMA_NAME FROM INFORMATION_SCHEMA.SCHEMATA")
+	if err != nil {
+		return fmt.Errorf("failed to load databases: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		c.databases[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = db.QueryContext(ctx, `SELECT GRANTEE, 'database', TABLE_SCHEMA, '', '', PRIVILEGE_TYPE, IS_GRANTABLE = 'YES' FROM INFORMATION_SCHEMA.SCHEMA_PRIVILEGES
+		UNION ALL
+		SELECT GRANTEE, 'table', TABLE_SCHEMA, TABLE_NAME, '', PRIVILEGE_TYPE, IS_GRANTABLE = 'YES' FROM INFORMATION_SCHEMA.TABLE_PRIVILEGES
+		UNION ALL
+		SELECT GRANTEE, 'column', TABLE_SCHEMA, TABLE_NAME, COLUMN_NAME, PRIVILEGE_TYPE, IS_GRANTABLE = 'YES' FROM INFORMATION_SCHEMA.COLUMN_PRIVILEGES`)
+	if err != nil {
+		return fmt.Errorf("failed to load privileges: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var grantee string
+		var privilege mysqlPrivilege
+		if err := rows.Scan(&grantee, &privilege.kind, &privilege.database, &privilege.name, &privilege.column, &privilege.privilege, &privilege.grantable); err != nil {
+			return err
+		}
+		c.privileges[grantee] = append(c.privileges[grantee], privilege)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// Routine privileges aren't in INFORMATION_SCHEMA, the grant option is one of the privileges in the set
+	rows, err = db.QueryContext(ctx, "SELECT User, Host, Db, LOWER(Routine_type), Routine_name, Proc_priv FROM mysql.procs_priv")
+	if err != nil {
+		return fmt.Errorf("failed to load routine privileges: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, host, privileges string
+		var routine mysqlPrivilege
+		if err := rows.Scan(&name, &host, &routine.database, &routine.kind, &routine.name, &privileges); err != nil {
+			return err
+		}
+		grantee := mysqlGrantee(name, host)
+		set := strings.Split(strings.ToUpper(privileges), ",")
+		routine.grantable = slices.Contains(set, "GRANT")
+		for _, privilege := range set {
+			if privilege != "" && privilege != "GRANT" {
+				routine.privilege = privilege
+				c.privileges[grantee] = append(c.privileges[grantee], routine)
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if err := c.loadRoles(ctx); err != nil {
+		return err
+	}
+
+	for _, privileges := range c.privileges {
+		slices.SortFunc(privileges, func(a, b mysqlPrivilege) int {
+			return cmp.Or(
+				cmp.Compare(a.database, b.database),
+				cmp.Compare(a.kind, b.kind),
+				cmp.Compare(a.name, b.name),
+				cmp.Compare(a.column, b.column),
+				cmp.Compare(a.privilege, b.privilege),
+			)
+		})
+	}
+
+	c.loaded = true
+	return nil
+}
+
+// loadRoles loads the roles granted to each account and their default roles. Roles were added in MySQL 8.0,
+// earlier versions don't have the tables so have no roles. The caller must hold c.mu.
+func (c *mysqlCatalog) loadRoles(ctx context.Context) error {
+	c.memberships = map[string][]string{}
+	c.defaults = map[string][]string{}
+	c.grantedRoles = map[string]bool{}
+
+	queries := []struct {
+		query string
+		roles map[string][]string
+	}{
+		{"SELECT TO_USER, TO_HOST, FROM_USER, FROM_HOST FROM mysql.role_edges ORDER BY FROM_USER, FROM_HOST", c.memberships},
+		{"SELECT USER, HOST, DEFAULT_ROLE_USER, DEFAULT_ROLE_HOST FROM mysql.default_roles ORDER BY DEFAULT_ROLE_USER, DEFAULT_ROLE_HOST", c.defaults},
+	}
+	for _, q := range queries {
+		rows, err := c.manager.db.QueryContext(ctx, q.query)
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == 1146 {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to load roles: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var name, host, role, roleHost string
+			if err := rows.Scan(&name, &host, &role, &roleHost); err != nil {
+				return err
+			}
+			grantee := mysqlGrantee(name, host)
+			q.roles[grantee] = append(q.roles[grantee], mysqlAccountName(role, roleHost))
+			c.grantedRoles[mysqlGrantee(role, roleHost)] = true
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadOptions loads the options of every account if they haven't been already.
+func (c *mysqlCatalog) loadOptions(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.optionsLoaded {
+		return nil
+	}
+
+	c.options = map[string]UserOptions{}
+	db := c.manager.db
+
+	// Failed login tracking is stored in User_attributes, which was added in MySQL 8.0.19
+	var passwordLocking bool
+	err := db.QueryRowContext(ctx, `SELECT COUNT(*) > 0 FROM INFORMATION_SCHEMA.COLUMNS
+		WHERE TABLE_SCHEMA = 'mysql' AND TABLE_NAME = 'user' AND COLUMN_NAME = 'User_attributes'`).Scan(&passwordLocking)
+	if err != nil {
+		return fmt.Errorf("failed to load user options: %w", err)
+	}
+	locking := "NULL, NULL"
+	if passwordLocking {
+		locking = `CAST(COALESCE(JSON_EXTRACT(User_attributes, '$.Password_locking.failed_login_attempts'), 0) AS SIGNED),
+		CAST(COALESCE(JSON_EXTRACT(User_attributes, '$.Password_locking.password_lock_time_days'), 0) AS SIGNED)`
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT User, Host, max_questions, max_user_connections, account_locked = 'Y',
+		COALESCE(password_lifetime, -1), `+locking+`, ssl_type, CONVERT(x509_subject USING utf8mb4)
+		FROM mysql.user`)
+	if err != nil {
+		return fmt.Errorf("failed to load user options: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, host, sslType, subject string
+		var maxQueries, maxConnections, expireDays int
+		var failedLogins, lockTime sql.NullInt64
+		var locked bool
+		if err := rows.Scan(&name, &host, &maxQueries, &maxConnections, &locked, &expireDays, &failedLogins, &lockTime, &sslType, &subject); err != nil {
+			return err
+		}
+
+		// Every option the server supports is set as they're all read from the server
+		options := UserOptions{
+			MaxQueriesPerHour:  &maxQueries,
+			MaxUserConnections: &maxConnections,
+			AccountLocked:      &locked,
+			PasswordExpireDays: &expireDays,
+		}
+		if passwordLocking {
+			options.FailedLoginAttempts = Int(int(failedLogins.Int64))
+			options.PasswordLockTime = Int(int(lockTime.Int64))
+		}
+		switch sslType {
+		case "":
+			options.Require = String("NONE")
+		case "ANY":
+			options.Require = String("SSL")
+		case "SPECIFIED":
+			options.RequireSubject = &subject
+		default:
+			options.Require = &sslType
+		}
+		c.options[mysqlGrantee(name, host)] = options
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	c.optionsLoaded = true
+	return nil
+}
+
+// userExists checks if the specified account exists.
+func (c *mysqlCatalog) userExists(ctx context.Context, name, host string) (bool, error) {
+	if err := c.load(ctx); err != nil {
+		return false, err
+	}
+	return slices.Contains(c.accounts[name], host), nil
+}
+
+// authPlugin returns the authentication plugin of an account.
+func (c *mysqlCatalog) authPlugin(ctx context.Context, name, host string) (string, error) {
+	if err := c.load(ctx); err != nil {
+		return "", err
+	}
+	return c.plugins[mysqlGrantee(name, host)], nil
+}
+
+// databaseExists checks if the specified database exists.
+func (c *mysqlCatalog) databaseExists(ctx context.Context, name string) (bool, error) {
+	if err := c.load(ctx); err != nil {
+		return false, err
+	}
+	return c.databases[name], nil
+}
+
+// accountPrivileges returns the privileges of an account, sorted by database, object and privilege.
+func (c *mysqlCatalog) accountPrivileges(ctx context.Context, name, host string) ([]mysqlPrivilege, error) {
+	if err := c.load(ctx); err != nil {
+		return nil, err
+	}
+	return c.privileges[mysqlGrantee(name, host)], nil
+}
+
+// hasGrant checks if an account has all of the privileges in a grant, on each of its columns if it has any.
+// Privileges on a table also apply to each of its columns.
+func (c *mysqlCatalog) hasGrant(ctx context.Context, name, host string, grant Grant) (bool, error) {
+	granted, err := c.accountPrivileges(ctx, name, host)
+	if err != nil {
+		return false, err
+	}
+
+	kind, object := mysqlGrantObject(grant)
+	privileges := grant.Privileges
+	if len(privileges) == 1 && strings.ToUpper(privileges[0]) == "ALL" {
+		privileges = mysqlAllPrivileges[kind]
+	}
+	columns := grant.Columns
+	if len(columns) == 0 {
+		columns = []string{""}
+	}
+
+	for _, privilege := range privileges {
+		// The grant option isn't listed as a privilege, it's a flag on each of the others
+		privilege = strings.ToUpper(privilege)
+		for _, column := range columns {
+			hasPrivilege := slices.ContainsFunc(granted, func(p mysqlPrivilege) bool {
+				onObject := p.database == grant.Database && p.name == object && (p.kind == kind && p.column == column || kind == "column" && p.kind == "table")
+				if privilege == "GRANT OPTION" {
+					return onObject && p.grantable
+				}
+				return onObject && p.privilege == privilege && (p.grantable || !grant.WithGrant)
+			})
+			if !hasPrivilege {
+				return false, nil // If any privilege is not granted, return false
+			}
+		}
+	}
+
+	return true, nil // All privileges are granted
+}
+
+// roles returns the roles granted to an account.
+func (c *mysqlCatalog) roles(ctx context.Context, name, host string) ([]string, error) {
+	if err := c.load(ctx); err != nil {
+		return nil, err
+	}
+	return c.memberships[mysqlGrantee(name, host)], nil
+}
+
+// defaultRoles returns the roles that are active by default when an account logs in.
+func (c *mysqlCatalog) defaultRoles(ctx context.Context, name, host string) ([]string, error) {
+	if err := c.load(ctx); err != nil {
+		return nil, err
+	}
+	return c.defaults[mysqlGrantee(name, host)], nil
+}
+
+// isRole checks if an account is granted to any other account as a role.
+func (c *mysqlCatalog) isRole(ctx context.Context, name, host string) (bool, error) {
+	if err := c.load(ctx); err != nil {
+		return false, err
+	}
+	return c.grantedRoles[mysqlGrantee(name, host)], nil
+}
+
+// accountOptions returns the options of an account, every option the server supports is set.
+func (c *mysqlCatalog) accountOptions(ctx context.Context, name, host string) (UserOptions, error) {
+	if err := c.loadOptions(ctx); err != nil {
+		return UserOptions{}, err
+	}
+	return c.options[mysqlGrantee(name, host)], nil
+}
@@ -0,0 +1,80 @@
+package dbmanager
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMySQLCatalog_HasGrant(t *testing.T) {
+	catalog := &mysqlCatalog{
+		loaded: true,
+		privileges: map[string][]mysqlPrivilege{
+			"'myuser'@'%'": {
+				{kind: "database", database: "mydb", privilege: "INSERT", grantable: true},
+				{kind: "database", database: "mydb", privilege: "SELECT"},
+				{kind: "table", database: "mydb", name: "mytable", privilege: "SELECT"},
+				{kind: "column", database: "mydb", name: "othertable", column: "a", privilege: "UPDATE"},
+				{kind: "procedure", database: "mydb", name: "myproc", privilege: "EXECUTE", grantable: true},
+			},
+		},
+	}
+	ctx := context.Background()
+
+	has, err := catalog.hasGrant(ctx, "myuser", "%", Grant{Database: "mydb", Privileges: []string{"select", "INSERT"}})
+	assert.NoError(t, err)
+	assert.True(t, has)
+
+	has, err = catalog.hasGrant(ctx, "myuser", "%", Grant{Database: "mydb", Privileges: []string{"SELECT"}, WithGrant: true})
+	assert.NoError(t, err)
+	assert.False(t, has, "SELECT isn't grantable")
+
+	has, err = catalog.hasGrant(ctx, "myuser", "%", Grant{Database: "mydb", Privileges: []string{"INSERT", "GRANT OPTION"}})
+	assert.NoError(t, err)
+	assert.True(t, has)
+
+	has, err = catalog.hasGrant(ctx, "myuser", "%", Grant{Database: "otherdb", Privileges: []string{"SELECT"}})
+	assert.NoError(t, err)
+	assert.False(t, has)
+
+	has, err = catalog.hasGrant(ctx, "myuser", "localhost", Grant{Database: "mydb", Privileges: []string{"SELECT"}})
+	assert.NoError(t, err)
+	assert.False(t, has, "Privileges are per account")
+
+	// Table, column and routine privileges are only found on the object they were granted on
+	has, err = catalog.hasGrant(ctx, "myuser", "%", Grant{Database: "mydb", Table: "mytable", Privileges: []string{"SELECT"}})
+	assert.NoError(t, err)
+	assert.True(t, has)
+
+	has, err = catalog.hasGrant(ctx, "myuser", "%", Grant{Database: "mydb", Table: "othertable", Privileges: []string{"UPDATE"}})
+	assert.NoError(t, err)
+	assert.False(t, has, "A column privilege doesn't grant the privilege on the table")
+
+	has, err = catalog.hasGrant(ctx, "myuser", "%", Grant{Database: "mydb", Table: "mytable", Columns: []string{"a", "b"}, Privileges: []string{"SELECT"}})
+	assert.NoError(t, err)
+	assert.True(t, has, "A table privilege applies to every column")
+
+	has, err = catalog.hasGrant(ctx, "myuser", "%", Grant{Database: "mydb", Table: "othertable", Columns: []string{"a", "b"}, Privileges: []string{"UPDATE"}})
+	assert.NoError(t, err)
+	assert.False(t, has, "Every column needs the privilege")
+
+	has, err = catalog.hasGrant(ctx, "myuser", "%", Grant{Database: "mydb", Procedure: "myproc", Privileges: []string{"EXECUTE"}, WithGrant: true})
+	assert.NoError(t, err)
+	assert.True(t, has)
+
+	has, err = catalog.hasGrant(ctx, "myuser", "%", Grant{Database: "mydb", Function: "myproc", Privileges: []string{"EXECUTE"}})
+	assert.NoError(t, err)
+	assert.False(t, has, "Functions and procedures are different objects")
+}
+
+func TestMySQLGrantCovers(t *testing.T) {
+	privilege := mysqlPrivilege{kind: "column", database: "mydb", name: "mytable", column: "a", privilege: "SELECT"}
+	assert.True(t, mysqlGrantCovers(Grant{Database: "mydb", Table: "mytable", Columns: []string{"a", "b"}, Privileges: []string{"select"}}, privilege))
+	assert.True(t, mysqlGrantCovers(Grant{Database: "mydb", Table: "mytable", Columns: []string{"a"}, Privileges: []string{"ALL"}}, privilege))
+	assert.False(t, mysqlGrantCovers(Grant{Database: "mydb", Table: "mytable", Privileges: []string{"SELECT"}}, privilege))
+	assert.False(t, mysqlGrantCovers(Grant{Database: "mydb", Privileges: []string{"SELECT"}}, privilege))
+
+	// The grant revoking the privilege is the same as the grant that covers it
+	assert.True(t, mysqlGrantCovers(privilege.grant(), privilege))
+}
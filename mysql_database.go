@@ -1,42 +1,64 @@
 package dbmanager
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 )
 
 // CreateDatabase creates a database based on the provided Database options.
 func (m *mysqlManager) CreateDatabase(database Database) error {
+	return m.CreateDatabaseContext(context.Background(), database)
+}
+
+// CreateDatabaseContext is like CreateDatabase but uses the provided context for all queries.
+func (m *mysqlManager) CreateDatabaseContext(ctx context.Context, database Database) error {
+	if err := database.Validate(m.engine); err != nil {
+		return err
+	}
+
+	plan := NewPlan()
+	if err := m.planDatabase(ctx, plan, database); err != nil {
+		return err
+	}
+
+	_, err := m.ApplyContext(ctx, plan)
+	return err
+}
+
+// planDatabase adds the changes needed to create a database to the plan.
+func (m *mysqlManager) planDatabase(ctx context.Context, plan *Plan, database Database) error {
 	// Create the database if it doesn't exist
-	exists, err := m.databaseExists(database.Name)
+	exists, err := m.catalog(plan).databaseExists(ctx, database.Name)
 	if err != nil {
 		return err
 	}
 
 	if exists {
+		plan.unchanged(resource("database", database.Name), "database already exists")
 		return nil
 	}
 
-	if err := m.createDatabase(database); err != nil {
-		return err
-	}
+	plan.Add(m.createDatabase(database))
+	plan.databases[database.Name] = true
 
 	return nil
 }
 
-// createDatabase creates a new database.
-func (m *mysqlManager) createDatabase(database Database) error {
-	_, err := m.db.Exec(fmt.Sprintf("CREATE DATABASE %s", database.Name))
-	if err != nil {
-		return fmt.Errorf("failed to create database: %w", err)
+// createDatabase returns the change that creates a new database.
+func (m *mysqlManager) createDatabase(database Database) Change {
+	return Change{
+		Resource: resource("database", database.Name),
+		Action:   ActionCreate,
+		SQL:      mysqlCreateDatabase(database.Name),
+		Reason:   "database does not exist",
 	}
-	return nil
 }
 
 // databaseExists checks if a database exists.
-func (m *mysqlManager) databaseExists(name string) (bool, error) {
+func (m *mysqlManager) databaseExists(ctx context.Context, name string) (bool, error) {
 	var dbName string
-	err := m.db.QueryRow("SELECT SCHEMA_NAME FROM INFORMATION_SCHEMA.SCHEMATA WHERE SCHEMA_NAME = ?", name).Scan(&dbName)
+	err := m.db.QueryRowContext(ctx, "SELECT SCHEMA_NAME FROM INFORMATION_SCHEMA.SCHEMATA WHERE SCHEMA_NAME = ?", name).Scan(&dbName)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			// No database found, return false without error
@@ -49,3 +71,27 @@ func (m *mysqlManager) databaseExists(name string) (bool, error) {
 	// If we reach here, it means the user exists
 	return true, nil
 }
+
+// DropDatabase drops a database if it exists.
+func (m *mysqlManager) DropDatabase(name string, options ...DropOption) error {
+	return m.DropDatabaseContext(context.Background(), name, options...)
+}
+
+// DropDatabaseContext is like DropDatabase but uses the provided context for all queries.
+func (m *mysqlManager) DropDatabaseContext(ctx context.Context, name string, options ...DropOption) error {
+	plan := NewPlan()
+
+	if exists, err := m.databaseExists(ctx, name); err != nil {
+		return err
+	} else if exists {
+		plan.Add(Change{
+			Resource: resource("database", name),
+			Action:   ActionDrop,
+			SQL:      mysqlDropDatabase(name),
+			Reason:   "drop requested",
+		})
+	}
+
+	_, err := m.ApplyContext(ctx, plan)
+	return err
+}
@@ -0,0 +1,132 @@
+package dbmanager
+
+import (
+	"context"
+	"slices"
+)
+
+// mysqlDatabasePrivilegesAll lists the database level privileges granted by ALL.
+var mysqlDatabasePrivilegesAll = []string{
+	"ALTER", "ALTER ROUTINE", "CREATE", "CREATE ROUTINE", "CREATE TEMPORARY TABLES", "CREATE VIEW", "DELETE",
+	"DROP", "EVENT", "EXECUTE", "INDEX", "INSERT", "LOCK TABLES", "REFERENCES", "SELECT", "SHOW VIEW",
+	"TRIGGER", "UPDATE",
+}
+
+// mysqlAllPrivileges maps each kind of object to the privileges granted by ALL.
+var mysqlAllPrivileges = map[string][]string{
+	"database":  mysqlDatabasePrivilegesAll,
+	"table":     {"ALTER", "CREATE", "CREATE VIEW", "DELETE", "DROP", "INDEX", "INSERT", "REFERENCES", "SELECT", "SHOW VIEW", "TRIGGER", "UPDATE"},
+	"column":    {"INSERT", "REFERENCES", "SELECT", "UPDATE"},
+	"function":  {"ALTER ROUTINE", "EXECUTE"},
+	"procedure": {"ALTER ROUTINE", "EXECUTE"},
+}
+
+// Diff compares the databases and users with the server and returns a report of every difference found
+// without changing anything.
+func (m *mysqlManager) Diff(databases []Database, users []User) (*DriftReport, error) {
+	return m.DiffContext(context.Background(), databases, users)
+}
+
+// DiffContext is like Diff but uses the provided context for all queries.
+func (m *mysqlManager) DiffContext(ctx context.Context, databases []Database, users []User) (*DriftReport, error) {
+	if err := validate(m.engine, databases, users); err != nil {
+		return nil, err
+	}
+
+	report := newDriftReport()
+	catalog := newMySQLCatalog(m)
+
+	// Users that are on the server but not in the config
+	accounts, err := m.listAccounts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, account := range accounts {
+		name, host := account[0], account[1]
+		if !matchesAny(name, mysqlSystemUsers) && !slices.ContainsFunc(users, mysqlIsAccount(name, host)) && !slices.ContainsFunc(users, mysqlHasRole(name, host)) {
+			report.extra(resource("user", mysqlAccountName(name, host)))
+		}
+	}
+
+	for _, database := range databases {
+		if exists, err := catalog.databaseExists(ctx, database.Name); err != nil {
+			return nil, err
+		} else if !exists {
+			report.missing(resource("database", database.Name))
+		}
+	}
+
+	for _, user := range users {
+		host := mysqlHost(user)
+		account := mysqlAccountName(user.Name, host)
+		if exists, err := catalog.userExists(ctx, user.Name, host); err != nil {
+			return nil, err
+		} else if !exists {
+			report.missing(resource("user", account))
+			continue
+		}
+
+		if user.AuthPlugin != "" {
+			if plugin, err := catalog.authPlugin(ctx, user.Name, host); err != nil {
+				return nil, err
+			} else if plugin != user.AuthPlugin {
+				report.changed(resource("user", account), "auth_plugin", user.AuthPlugin, plugin)
+			}
+		}
+
+		// Account options, options that aren't set aren't managed so can't drift
+		attributes := mysqlAttributes(user.Options)
+		if mysqlAccountOptions(attributes) != "" {
+			options, err := catalog.accountOptions(ctx, user.Name, host)
+			if err != nil {
+				return nil, err
+			}
+			actual := mysqlAttributes(options)
+			for i, attribute := range attributes {
+				if attribute.clause != "" && attribute.clause != actual[i].clause {
+					report.changed(resource("user", account), attribute.name, attribute.clause, actual[i].clause)
+				}
+			}
+		}
+
+		for _, grant := range user.Grants {
+			if len(grant.Privileges) == 0 {
+				continue
+			}
+			if hasPermissions, err := catalog.hasGrant(ctx, user.Name, host, grant); err != nil {
+				return nil, err
+			} else if !hasPermissions {
+				report.missing(resource("grant", account, mysqlGrantTarget(grant)))
+			}
+		}
+
+		// Role memberships and default roles
+		var roles []string
+		for _, role := range user.Roles {
+			roles = append(roles, mysqlRoleName(role))
+		}
+		granted, err := catalog.roles(ctx, user.Name, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, role := range roles {
+			if !slices.Contains(granted, role) {
+				report.missing(resource("role", account, role))
+			}
+		}
+		for _, role := range granted {
+			if !slices.Contains(roles, role) {
+				report.extra(resource("role", account, role))
+			}
+		}
+		defaults, err := catalog.defaultRoles(ctx, user.Name, host)
+		if err != nil {
+			return nil, err
+		}
+		if !sameRoles(roles, defaults) {
+			report.changed(resource("user", account), "default_roles", roles, defaults)
+		}
+	}
+
+	return report, nil
+}
@@ -0,0 +1,210 @@
+package dbmanager
+
+import (
+	"context"
+	"regexp"
+	"strings"
+)
+
+// mysqlGrantPattern matches the privileges, routine type, object and grant option in a line returned by SHOW
+// GRANTS.
+var mysqlGrantPattern = regexp.MustCompile("^GRANT (.+) ON (?:(FUNCTION|PROCEDURE) )?(\\S+) TO .+?( WITH GRANT OPTION)?$")
+
+// Export reads the databases and users on the server and returns them as configuration that can be passed
+// to Manage. Passwords can't be read from the server so they are left empty, system users and databases
+// and the user we're connected as are not included.
+func (m *mysqlManager) Export() ([]Database, []User, error) {
+	return m.ExportContext(context.Background())
+}
+
+// ExportContext is like Export but uses the provided context for all queries.
+func (m *mysqlManager) ExportContext(ctx context.Context) ([]Database, []User, error) {
+	names, err := m.listDatabases(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var databases []Database
+	for _, name := range names {
+		if !matchesAny(name, mysqlSystemDatabases) {
+			databases = append(databases, Database{Name: name})
+		}
+	}
+
+	accounts, err := m.listAccounts(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	catalog := newMySQLCatalog(m)
+	var users []User
+	for _, account := range accounts {
+		name, host := account[0], account[1]
+		if matchesAny(name, mysqlSystemUsers) {
+			continue
+		}
+
+		// Roles are created when they're granted to a user so aren't users themselves
+		if isRole, err := catalog.isRole(ctx, name, host); err != nil {
+			return nil, nil, err
+		} else if isRole {
+			m.Logger().Debug("Account is a role, skipping", "user", name, "host", host)
+			continue
+		}
+
+		grants, err := m.showGrants(ctx, name, host)
+		if err != nil {
+			return nil, nil, err
+		}
+		roles, err := catalog.roles(ctx, name, host)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		user := User{Name: name, Grants: grants, Roles: roles}
+		if host != "%" {
+			user.Host = host
+		}
+		users = append(users, user)
+	}
+
+	return databases, users, nil
+}
+
+// showGrants returns the database, table, column and routine grants for an account using SHOW GRANTS.
+// Global grants can't be expressed as a Grant so are ignored.
+func (m *mysqlManager) showGrants(ctx context.Context, name, host string) ([]Grant, error) {
+	rows, err := m.db.QueryContext(ctx, mysqlShowGrants(name, host))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var grants []Grant
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return nil, err
+		}
+
+		// Role grants don't have an ON clause so won't match
+		match := mysqlGrantPattern.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+
+		database, object := splitMySQLObject(match[3])
+		if database == "*" {
+			m.Logger().Debug("Grant is a global grant, skipping", "user", name, "grant", line)
+			continue
+		}
+
+		grant := Grant{Database: database, WithGrant: match[4] != ""}
+		switch {
+		case match[2] == "FUNCTION":
+			grant.Function = object
+		case match[2] == "PROCEDURE":
+			grant.Procedure = object
+		case object != "*":
+			grant.Table = object
+		}
+
+		grants = append(grants, splitMySQLColumnGrants(grant, match[1])...)
+	}
+
+	return grants, rows.Err()
+}
+
+// splitMySQLColumnGrants adds the privileges returned by SHOW GRANTS, such as "SELECT, UPDATE (`a`, `b`)", to
+// grant. Privileges on columns are returned as separate grants, one for each set of columns.
+func splitMySQLColumnGrants(grant Grant, privileges string) []Grant {
+	grants := []Grant{grant}
+	index := map[string]int{"": 0}
+
+	for _, privilege := range splitMySQLList(privileges) {
+		var columns []string
+		if name, list, ok := strings.Cut(privilege, " ("); ok {
+			privilege = name
+			for _, column := range splitMySQLList(strings.TrimSuffix(list, ")")) {
+				columns = append(columns, unquoteMySQLIdentifier(column))
+			}
+		}
+
+		key := strings.Join(columns, ",")
+		i, ok := index[key]
+		if !ok {
+			column := grant
+			column.Columns = columns
+			i = len(grants)
+			index[key] = i
+			grants = append(grants, column)
+		}
+		grants[i].Privileges = append(grants[i].Privileges, privilege)
+	}
+
+	// Grants on the table itself are only kept if they have privileges
+	if len(grants[0].Privileges) == 0 {
+		grants = grants[1:]
+	}
+	return grants
+}
+
+// splitMySQLList splits a comma separated list returned by SHOW GRANTS, ignoring commas inside parentheses
+// or backticks.
+func splitMySQLList(list string) []string {
+	var items []string
+	depth, quoted, start := 0, false, 0
+
+	for i := 0; i < len(list); i++ {
+		switch c := list[i]; {
+		case c == '`':
+			quoted = !quoted
+		case quoted:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			items = append(items, strings.TrimSpace(list[start:i]))
+			start = i + 1
+		}
+	}
+	return append(items, strings.TrimSpace(list[start:]))
+}
+
+// splitMySQLObject splits an object returned by SHOW GRANTS, such as `db`.* or *.*, into its database and
+// table, removing any backticks.
+func splitMySQLObject(object string) (database, table string) {
+	var parts []string
+	var sb strings.Builder
+	quoted := false
+
+	for i := 0; i < len(object); i++ {
+		switch c := object[i]; {
+		case c == '`' && quoted && i+1 < len(object) && object[i+1] == '`':
+			sb.WriteByte('`')
+			i++
+		case c == '`':
+			quoted = !quoted
+		case c == '.' && !quoted:
+			parts = append(parts, sb.String())
+			sb.Reset()
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	parts = append(parts, sb.String())
+
+	if len(parts) != 2 {
+		return object, ""
+	}
+	return parts[0], parts[1]
+}
+
+// unquoteMySQLIdentifier removes the backticks around an identifier returned by SHOW GRANTS.
+func unquoteMySQLIdentifier(name string) string {
+	if len(name) >= 2 && name[0] == '`' && name[len(name)-1] == '`' {
+		return strings.ReplaceAll(name[1:len(name)-1], "``", "`")
+	}
+	return name
+}
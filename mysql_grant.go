@@ -1,49 +1,241 @@
 package dbmanager
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"slices"
 	"strings"
 )
 
 // GrantPermissions grants permissions to a MySQL user based on the provided Grant options.
 func (m *mysqlManager) GrantPermissions(user User) error {
-	log.Printf("Granting permissions to user: %s\n", user.Name)
+	return m.GrantPermissionsContext(context.Background(), user)
+}
 
-	// Check if the user exists
-	if exists, err := m.userExists(user.Name); err != nil {
+// GrantPermissionsContext is like GrantPermissions but uses the provided context for all queries.
+func (m *mysqlManager) GrantPermissionsContext(ctx context.Context, user User) error {
+	if err := user.Validate(m.engine); err != nil {
+		return err
+	}
+
+	plan := NewPlan()
+	if err := m.planPermissions(ctx, plan, user); err != nil {
 		return err
-	} else if !exists {
-		log.Printf("User %s does not exist, skipping\n", user.Name)
-		return nil
+	}
+
+	_, err := m.ApplyContext(ctx, plan)
+	return err
+}
+
+// planPermissions adds the changes needed to grant permissions and roles to a user to the plan.
+func (m *mysqlManager) planPermissions(ctx context.Context, plan *Plan, user User) error {
+	host := mysqlHost(user)
+	account := mysqlAccountName(user.Name, host)
+	m.Logger().Debug("Granting permissions", "user", account)
+
+	// Check if the user exists
+	if !plan.users[account] {
+		if exists, err := m.catalog(plan).userExists(ctx, user.Name, host); err != nil {
+			return err
+		} else if !exists {
+			m.Logger().Debug("User does not exist, skipping", "user", account)
+			plan.skipped(resource("user", account), "user does not exist")
+			return nil
+		}
 	}
 
 	// Grant permissions based on the grants specified for the user
 	for _, grant := range user.Grants {
-		log.Printf("Processing grant: %v", grant)
+		m.Logger().Debug("Processing grant", "user", account, "grant", grant)
+		target := mysqlGrantTarget(grant)
 
 		if len(grant.Privileges) == 0 {
-			log.Printf("Skipping grant with empty privileges for user: %s, database: %s\n", user.Name, grant.Database)
+			m.Logger().Debug("Grant has no privileges, skipping", "user", account, "target", target)
+			plan.skipped(resource("grant", account, target), "grant has no privileges")
 			continue
 		}
 
-		// Build the base GRANT query
-		grantQuery := fmt.Sprintf("GRANT %s ON %s.* TO '%s'@'%%'",
-			strings.Join(grant.Privileges, ", "), // Join privileges
-			grant.Database,                       // Grant specific database
-			user.Name)                            // User
-
-		// Add WITH GRANT OPTION if specified
-		if grant.WithGrant {
-			grantQuery += " WITH GRANT OPTION"
+		if grant.Database == "" {
+			return fmt.Errorf("%w: a database is required", ErrInvalidGrant)
 		}
 
-		// Execute the GRANT query
-		_, err := m.db.Exec(grantQuery)
+		grantQuery, err := mysqlGrant(grant, user.Name, host)
 		if err != nil {
-			return fmt.Errorf("error granting permissions: %w", err)
+			return err
 		}
+
+		// We can't check the privileges of users or databases that don't exist yet, so these are always granted
+		reason := "privileges are missing"
+		if plan.users[account] {
+			reason = "user will be created"
+		} else if plan.databases[grant.Database] {
+			reason = "database will be created"
+		} else if hasPermissions, err := m.catalog(plan).hasGrant(ctx, user.Name, host, grant); err != nil {
+			return err
+		} else if hasPermissions {
+			m.Logger().Debug("User already has permissions, skipping", "user", account, "target", target)
+			plan.unchanged(resource("grant", account, target), "user already has privileges")
+			continue
+		}
+
+		plan.Add(Change{
+			Resource: resource("grant", account, target),
+			Action:   ActionGrant,
+			Database: grant.Database,
+			SQL:      grantQuery,
+			Reason:   reason,
+		})
+	}
+
+	return m.planRoles(ctx, plan, user)
+}
+
+// planRoles adds the changes that grant the user's roles, revoke roles that aren't in the config and set the
+// user's default roles so every role is active when the user logs in. Roles that don't exist are created.
+func (m *mysqlManager) planRoles(ctx context.Context, plan *Plan, user User) error {
+	host := mysqlHost(user)
+	account := mysqlAccountName(user.Name, host)
+
+	var roles []string
+	for _, role := range user.Roles {
+		roles = append(roles, mysqlRoleName(role))
+	}
+
+	granted, err := m.catalog(plan).roles(ctx, user.Name, host)
+	if err != nil {
+		return err
+	}
+
+	// Add to roles
+	for _, role := range roles {
+		if err := m.createRole(ctx, plan, role); err != nil {
+			return err
+		}
+
+		if slices.Contains(granted, role) {
+			m.Logger().Debug("User already has role, skipping", "user", account, "role", role)
+			plan.unchanged(resource("role", account, role), "user already has role")
+			continue
+		}
+		plan.Add(Change{
+			Resource: resource("role", account, role),
+			Action:   ActionGrant,
+			SQL:      mysqlGrantRole(role, user.Name, host),
+			Reason:   "role is set in config",
+		})
+	}
+
+	// Remove user from roles not specified in the config
+	for _, role := range granted {
+		if !slices.Contains(roles, role) {
+			plan.Add(Change{
+				Resource: resource("role", account, role),
+				Action:   ActionRevoke,
+				SQL:      mysqlRevokeRole(role, user.Name, host),
+				Reason:   "role is not set in config",
+			})
+		}
+	}
+
+	// Roles aren't active when a user logs in unless they're default roles
+	defaults, err := m.catalog(plan).defaultRoles(ctx, user.Name, host)
+	if err != nil {
+		return err
+	}
+	if !sameRoles(roles, defaults) {
+		plan.Add(Change{
+			Resource: resource("user", account),
+			Action:   ActionUpdate,
+			SQL:      mysqlSetDefaultRoles(roles, user.Name, host),
+			Reason:   "default roles don't match config",
+		})
 	}
 
 	return nil
 }
+
+// createRole adds the change that creates a role to the plan if it doesn't exist and isn't already being
+// created as a role or user.
+func (m *mysqlManager) createRole(ctx context.Context, plan *Plan, role string) error {
+	if plan.users[role] {
+		return nil
+	}
+
+	name, host := mysqlRole(role)
+	if exists, err := m.catalog(plan).userExists(ctx, name, host); err != nil {
+		return err
+	} else if exists {
+		return nil
+	}
+
+	plan.Add(Change{
+		Resource: resource("role", role),
+		Action:   ActionCreate,
+		SQL:      mysqlCreateRole(role),
+		Reason:   "role does not exist",
+	})
+	plan.users[role] = true
+
+	return nil
+}
+
+// sameRoles returns true if both lists contain the same roles in any order.
+func sameRoles(a, b []string) bool {
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(slices.Compact(a), slices.Compact(b))
+}
+
+// RevokePermissions revokes the provided grants from a MySQL user.
+func (m *mysqlManager) RevokePermissions(user User, grants []Grant) error {
+	return m.RevokePermissionsContext(context.Background(), user, grants)
+}
+
+// RevokePermissionsContext is like RevokePermissions but uses the provided context for all queries.
+func (m *mysqlManager) RevokePermissionsContext(ctx context.Context, user User, grants []Grant) error {
+	plan := NewPlan()
+	host := mysqlHost(user)
+	account := mysqlAccountName(user.Name, host)
+
+	// Check if the user exists
+	if exists, err := m.userExists(ctx, user.Name, host); err != nil {
+		return err
+	} else if !exists {
+		m.Logger().Debug("User does not exist, skipping", "user", account)
+		return nil
+	}
+
+	for _, grant := range grants {
+		if len(grant.Privileges) == 0 {
+			continue
+		}
+		query, err := mysqlRevoke(grant, user.Name, host)
+		if err != nil {
+			return err
+		}
+		plan.Add(Change{
+			Resource: resource("grant", account, mysqlGrantTarget(grant)),
+			Action:   ActionRevoke,
+			Database: grant.Database,
+			SQL:      query,
+			Reason:   "revoke requested",
+		})
+	}
+
+	_, err := m.ApplyContext(ctx, plan)
+	return err
+}
+
+// mysqlGrantTarget returns the target of a grant used to identify it in resources, e.g. "database:mydb",
+// "table:mydb.mytable(col1,col2)" or "procedure:mydb.myproc".
+func mysqlGrantTarget(grant Grant) string {
+	kind, name := mysqlGrantObject(grant)
+	switch kind {
+	case "database":
+		return "database:" + grant.Database
+	case "column":
+		return fmt.Sprintf("table:%s.%s(%s)", grant.Database, name, strings.Join(grant.Columns, ","))
+	}
+	return fmt.Sprintf("%s:%s.%s", kind, grant.Database, name)
+}
@@ -3,11 +3,13 @@
 package dbmanager
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -27,7 +29,7 @@ var (
 
 func testMySQLQuery(username, password, database, query string) (sql.Result, error) {
 	m := &mysqlManager{
-		databaseManager: databaseManager{
+		BaseManager: BaseManager{
 			connection: Connection{
 				Host:     "localhost",
 				Database: database,
@@ -47,7 +49,7 @@ func testMySQLQuery(username, password, database, query string) (sql.Result, err
 
 func testMySQLQueryForPermissions(username, database string) ([]string, error) {
 	m := &mysqlManager{
-		databaseManager: databaseManager{
+		BaseManager: BaseManager{
 			connection: Connection{
 				Host:     "localhost",
 				Database: database,
@@ -248,3 +250,508 @@ func TestMySQLManager_GrantPermissionsIntegration_All(t *testing.T) {
 		assert.Contains(t, permissions, expected)
 	}
 }
+
+func TestMySQLManager_PlanIntegration(t *testing.T) {
+	plannedUser := "planneduser"
+	plannedDatabase := "planneddb"
+
+	databases := []Database{{Name: plannedDatabase}}
+	users := []User{{Name: plannedUser, Password: mysqlPassword, Grants: []Grant{{Database: plannedDatabase, Privileges: []string{"SELECT"}}}}}
+
+	// Planning should not change anything on the server
+	plan, err := mysqlTestManager.Plan(databases, users)
+	assert.NoError(t, err, "Error planning databases and users")
+	assert.False(t, plan.Empty(), "Plan should contain changes")
+	assert.NotContains(t, plan.String(), mysqlPassword, "Plan should not contain passwords")
+
+	_, err = testMySQLQuery(plannedUser, mysqlPassword, "", "SELECT 1")
+	assert.Error(t, err, "User should not exist after Plan operation")
+
+	// Applying the plan should create the user, database and grants
+	report, err := mysqlTestManager.Apply(plan)
+	assert.NoError(t, err, "Error applying plan")
+	assert.True(t, report.Changed(), "Report should show changes")
+	assert.Contains(t, report.Resources, ResourceReport{
+		Resource:   "database/" + plannedDatabase,
+		Status:     StatusCreated,
+		Statements: []string{"CREATE DATABASE `" + plannedDatabase + "`"},
+	})
+
+	permissions, err := testMySQLQueryForPermissions(plannedUser, plannedDatabase)
+	assert.NoError(t, err)
+	assert.Contains(t, permissions, "SELECT")
+}
+
+func TestMySQLManager_DiffIntegration(t *testing.T) {
+	// The user and grants created earlier should have no drift
+	report, err := mysqlTestManager.Diff(
+		[]Database{{Name: mysqlDatabase}},
+		[]User{{Name: mysqlUsername, Grants: []Grant{{Database: mysqlDatabase, Privileges: []string{"ALL"}}}}},
+	)
+	assert.NoError(t, err)
+	assert.NotContains(t, report.Drifts, Drift{Resource: "user/" + mysqlUsername, Type: DriftMissing})
+	assert.NotContains(t, report.Drifts, Drift{Resource: "grant/" + mysqlUsername + "/database:" + mysqlDatabase, Type: DriftMissing})
+
+	// A user and database that don't exist should be reported
+	report, err = mysqlTestManager.Diff([]Database{{Name: "missingdb"}}, []User{{Name: "missinguser"}})
+	assert.NoError(t, err)
+	assert.True(t, report.HasDrift())
+	assert.Contains(t, report.Drifts, Drift{Resource: "database/missingdb", Type: DriftMissing})
+	assert.Contains(t, report.Drifts, Drift{Resource: "user/missinguser", Type: DriftMissing})
+}
+
+func TestMySQLManager_DropIntegration(t *testing.T) {
+	droppedUser := "droppeduser"
+	droppedDatabase := "droppeddb"
+
+	assert.NoError(t, mysqlTestManager.Manage(
+		[]Database{{Name: droppedDatabase}},
+		[]User{{Name: droppedUser, Password: mysqlPassword, Grants: []Grant{{Database: droppedDatabase, Privileges: []string{"SELECT", "INSERT"}}}}},
+	))
+
+	// Revoking the grant should remove the privileges
+	assert.NoError(t, mysqlTestManager.RevokePermissions(User{Name: droppedUser}, []Grant{{Database: droppedDatabase, Privileges: []string{"INSERT"}}}))
+	permissions, err := testMySQLQueryForPermissions(droppedUser, droppedDatabase)
+	assert.NoError(t, err)
+	assert.Contains(t, permissions, "SELECT")
+	assert.NotContains(t, permissions, "INSERT")
+
+	// Dropping the user and database should remove them
+	assert.NoError(t, mysqlTestManager.DropUser(droppedUser+"@%"))
+	_, err = testMySQLQuery(droppedUser, mysqlPassword, "", "SELECT 1")
+	assert.Error(t, err, "User should not exist after DropUser operation")
+
+	assert.NoError(t, mysqlTestManager.DropDatabase(droppedDatabase))
+	_, err = testMySQLQuery(mysqlAdminUser, mysqlAdminPassword, "", fmt.Sprintf("USE %s", droppedDatabase))
+	assert.Error(t, err, "Database should not exist after DropDatabase operation")
+}
+
+func TestMySQLManager_PlanIntegration_Prune(t *testing.T) {
+	prunedUser := "pruneduser"
+	prunedDatabase := "pruneddb"
+
+	assert.NoError(t, mysqlTestManager.CreateUser(User{Name: prunedUser, Password: mysqlPassword}))
+	assert.NoError(t, mysqlTestManager.CreateDatabase(Database{Name: prunedDatabase}))
+
+	// With pruning the user and database should be dropped, but not the excluded user or system users
+	plan, err := mysqlTestManager.Plan(nil, nil, WithPrune(mysqlUsername))
+	assert.NoError(t, err)
+	assert.Contains(t, plan.String(), fmt.Sprintf("DROP USER '%s'@'%%'", prunedUser))
+	assert.Contains(t, plan.String(), fmt.Sprintf("DROP DATABASE `%s`", prunedDatabase))
+	assert.NotContains(t, plan.String(), fmt.Sprintf("DROP USER '%s'", mysqlUsername))
+	assert.NotContains(t, plan.String(), "DROP USER 'root'")
+
+	// Applying the plan should remove the user
+	_, err = mysqlTestManager.Apply(plan)
+	assert.NoError(t, err)
+	_, err = testMySQLQuery(prunedUser, mysqlPassword, "", "SELECT 1")
+	assert.Error(t, err, "User should not exist after pruning")
+}
+
+func TestMySQLManager_ManagerIntegration_ContinueOnError(t *testing.T) {
+	longUser := "continueuser1" + strings.Repeat("x", 32)
+	users := []User{
+		{Name: longUser, Password: mysqlPassword},
+		{Name: "continueuser2", Password: mysqlPassword},
+	}
+	var report Report
+
+	// Every failure should be returned and the remaining users should still be created
+	err := mysqlTestManager.Manage(nil, users, WithContinueOnError(), WithReport(&report))
+	assert.Error(t, err)
+	assert.True(t, report.Failed())
+
+	var resourceErr *ResourceError
+	assert.ErrorAs(t, err, &resourceErr)
+	assert.Equal(t, "user/"+longUser, resourceErr.Resource)
+	assert.NotEmpty(t, resourceErr.SQLState)
+
+	_, err = testMySQLQuery("continueuser2", mysqlPassword, "", "SELECT 1")
+	assert.NoError(t, err, "User should exist after Manage operation")
+}
+
+func TestMySQLManager_ManagerIntegration_Validate(t *testing.T) {
+	users := []User{
+		{Name: "invaliduser1", Grants: []Grant{{Privileges: []string{"SELECT"}}}},
+		{Name: "invaliduser2", Grants: []Grant{{Database: mysqlDatabase, Privileges: []string{"NOT A PRIVILEGE"}}}},
+	}
+
+	// Invalid grants should be rejected before anything is run
+	err := mysqlTestManager.Manage(nil, users)
+	assert.ErrorIs(t, err, ErrInvalidGrant)
+	assert.ErrorContains(t, err, "user invaliduser1: grants[0]: invalid grant: a database is required")
+	assert.ErrorContains(t, err, `user invaliduser2: grants[0]: invalid grant: privilege "NOT A PRIVILEGE" can't be granted`)
+
+	_, err = testMySQLQuery("invaliduser1", mysqlPassword, "", "SELECT 1")
+	assert.Error(t, err, "User should not exist after failed validation")
+}
+
+func TestMySQLManager_ExportIntegration(t *testing.T) {
+	exportedUser := "exporteduser"
+	exportedDatabase := "exporteddb"
+
+	assert.NoError(t, mysqlTestManager.Manage(
+		[]Database{{Name: exportedDatabase}},
+		[]User{{Name: exportedUser, Password: mysqlPassword, Grants: []Grant{{Database: exportedDatabase, Privileges: []string{"SELECT", "INSERT"}, WithGrant: true}}}},
+	))
+
+	databases, users, err := mysqlTestManager.Export()
+	assert.NoError(t, err)
+	assert.Contains(t, databases, Database{Name: exportedDatabase})
+	assert.Contains(t, users, User{Name: exportedUser, Grants: []Grant{{Database: exportedDatabase, Privileges: []string{"SELECT", "INSERT"}, WithGrant: true}}})
+
+	// The exported configuration should re-apply cleanly
+	assert.NoError(t, mysqlTestManager.Manage(databases, users))
+}
+
+func TestMySQLManager_ManagerIntegration_Quoting(t *testing.T) {
+	quotedUser := "quoted'user"
+	quotedPassword := `pass'word\"`
+	quotedDatabase := "quoted-db`name"
+
+	// Names and passwords with quotes and backslashes should be escaped, not break the statements
+	assert.NoError(t, mysqlTestManager.Manage(
+		[]Database{{Name: quotedDatabase}},
+		[]User{{Name: quotedUser, Password: quotedPassword, Grants: []Grant{{Database: quotedDatabase, Privileges: []string{"select"}}}}},
+	))
+
+	_, err := testMySQLQuery(quotedUser, quotedPassword, "", "SELECT 1")
+	assert.NoError(t, err, "User should be able to log in with the password")
+
+	var granted string
+	err = mysqlTestManager.(*mysqlManager).db.QueryRow("SELECT PRIVILEGE_TYPE FROM INFORMATION_SCHEMA.SCHEMA_PRIVILEGES WHERE TABLE_SCHEMA = ?", quotedDatabase).Scan(&granted)
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT", granted)
+
+	assert.NoError(t, mysqlTestManager.DropUser(quotedUser))
+	assert.NoError(t, mysqlTestManager.DropDatabase(quotedDatabase))
+}
+
+func TestMySQLManager_PlanIntegration_Catalog(t *testing.T) {
+	catalogUser := "cataloguser"
+	catalogDatabase := "catalogdb"
+
+	databases := []Database{{Name: catalogDatabase}}
+	users := []User{{Name: catalogUser, Grants: []Grant{{Database: catalogDatabase, Privileges: []string{"SELECT", "INSERT"}, WithGrant: true}}}}
+	assert.NoError(t, mysqlTestManager.Manage(databases, users))
+
+	// Planning again should find everything in the snapshot and have nothing left to do
+	plan, err := mysqlTestManager.Plan(databases, users)
+	assert.NoError(t, err)
+	assert.True(t, plan.Empty(), "Plan should not contain changes: %s", plan)
+
+	// Privileges the user doesn't have yet should still be planned
+	users[0].Grants[0].Privileges = append(users[0].Grants[0].Privileges, "UPDATE")
+	plan, err = mysqlTestManager.Plan(databases, users)
+	assert.NoError(t, err)
+	assert.Contains(t, plan.String(), "grant/"+catalogUser+"/database:"+catalogDatabase)
+
+	assert.NoError(t, mysqlTestManager.DropUser(catalogUser))
+	assert.NoError(t, mysqlTestManager.DropDatabase(catalogDatabase))
+}
+
+func TestMySQLManager_ManagerIntegration_Concurrency(t *testing.T) {
+	var databases []Database
+	var grants []Grant
+	for i := range 4 {
+		name := fmt.Sprintf("concurrentdb%d", i)
+		databases = append(databases, Database{Name: name})
+		grants = append(grants, Grant{Database: name, Privileges: []string{"SELECT"}})
+	}
+	users := []User{{Name: "concurrentuser", Grants: grants}}
+
+	// The plan should be the same whether the databases are planned one at a time or in parallel
+	sequential, err := mysqlTestManager.Plan(databases, users)
+	assert.NoError(t, err)
+	parallel, err := mysqlTestManager.Plan(databases, users, WithConcurrency(4))
+	assert.NoError(t, err)
+	assert.Equal(t, sequential.String(), parallel.String())
+
+	assert.NoError(t, mysqlTestManager.Manage(databases, users, WithConcurrency(4)))
+	for _, grant := range grants {
+		permissions, err := testMySQLQueryForPermissions("concurrentuser", grant.Database)
+		assert.NoError(t, err)
+		assert.Contains(t, permissions, "SELECT")
+	}
+
+	assert.NoError(t, mysqlTestManager.DropUser("concurrentuser"))
+	for _, database := range databases {
+		assert.NoError(t, mysqlTestManager.DropDatabase(database.Name))
+	}
+}
+
+func TestMySQLManager_ManagerIntegration_Lock(t *testing.T) {
+	// Hold the lock as if another run was in progress
+	unlock, err := mysqlTestManager.(*mysqlManager).lock(context.Background(), time.Second)
+	assert.NoError(t, err)
+
+	err = mysqlTestManager.Manage(nil, []User{{Name: "lockeduser"}}, WithLockTimeout(time.Second))
+	assert.ErrorIs(t, err, ErrLocked)
+
+	// Applying a plan takes the same lock
+	plan, err := mysqlTestManager.Plan(nil, []User{{Name: "lockeduser"}}, WithLockTimeout(time.Second))
+	assert.NoError(t, err)
+	_, err = mysqlTestManager.Apply(plan)
+	assert.ErrorIs(t, err, ErrLocked)
+
+	// Once released the run can go ahead and releases the lock again when it's done
+	assert.NoError(t, unlock(context.Background()))
+	assert.NoError(t, mysqlTestManager.Manage(nil, []User{{Name: "lockeduser"}}, WithLockTimeout(time.Second)))
+	assert.NoError(t, mysqlTestManager.Manage(nil, []User{{Name: "lockeduser"}}, WithLockTimeout(time.Second)))
+	assert.NoError(t, mysqlTestManager.DropUser("lockeduser"))
+}
+
+func TestMySQLManager_ManagerIntegration_Host(t *testing.T) {
+	hostUser := "hostuser"
+	hostDatabase := "hostdb"
+
+	// The same user on two hosts is two accounts, each with its own grants
+	databases := []Database{{Name: hostDatabase}}
+	users := []User{
+		{Name: hostUser, Password: "password", Grants: []Grant{{Database: hostDatabase, Privileges: []string{"SELECT"}}}},
+		{Name: hostUser, Host: "localhost", Password: "password", Grants: []Grant{{Database: hostDatabase, Privileges: []string{"SELECT", "INSERT"}}}},
+	}
+	assert.NoError(t, mysqlTestManager.Manage(databases, users))
+
+	catalog := newMySQLCatalog(mysqlTestManager.(*mysqlManager))
+	for _, host := range []string{"%", "localhost"} {
+		exists, err := catalog.userExists(context.Background(), hostUser, host)
+		assert.NoError(t, err)
+		assert.True(t, exists, "Account should exist on host %s", host)
+	}
+	hasInsert, err := catalog.hasGrant(context.Background(), hostUser, "localhost", Grant{Database: hostDatabase, Privileges: []string{"INSERT"}})
+	assert.NoError(t, err)
+	assert.True(t, hasInsert)
+	hasInsert, err = catalog.hasGrant(context.Background(), hostUser, "%", Grant{Database: hostDatabase, Privileges: []string{"INSERT"}})
+	assert.NoError(t, err)
+	assert.False(t, hasInsert, "Grants on one host should not be applied to the other")
+
+	// Both accounts are in the config so nothing is left to do, even when pruning
+	plan, err := mysqlTestManager.Plan(databases, users, WithPrune())
+	assert.NoError(t, err)
+	assert.NotContains(t, plan.String(), "user/"+hostUser)
+
+	// Removing one of the hosts from the config prunes just that account
+	plan, err = mysqlTestManager.Plan(databases, users[:1], WithPrune())
+	assert.NoError(t, err)
+	assert.Contains(t, plan.String(), "user/"+hostUser+"@localhost")
+	assert.NotContains(t, plan.String(), "user/"+hostUser+":")
+
+	// Both hosts are exported
+	_, exported, err := mysqlTestManager.Export()
+	assert.NoError(t, err)
+	assert.Contains(t, exported, User{Name: hostUser, Host: "localhost", Grants: []Grant{{Database: hostDatabase, Privileges: []string{"SELECT", "INSERT"}}}})
+
+	assert.NoError(t, mysqlTestManager.DropUser(hostUser))
+	assert.NoError(t, mysqlTestManager.DropDatabase(hostDatabase))
+}
+
+func TestMySQLManager_ManagerIntegration_TableAndRoutineGrants(t *testing.T) {
+	objectUser := "objectuser"
+	objectDatabase := "objectdb"
+	databases := []Database{{Name: objectDatabase}}
+	assert.NoError(t, mysqlTestManager.Manage(databases, nil))
+
+	db := mysqlTestManager.(*mysqlManager).db
+	_, err := db.Exec("CREATE TABLE `objectdb`.`mytable` (a INT, b INT, c INT)")
+	assert.NoError(t, err)
+	_, err = db.Exec("CREATE PROCEDURE `objectdb`.`myproc`() SELECT 1")
+	assert.NoError(t, err)
+
+	users := []User{{Name: objectUser, Grants: []Grant{
+		{Database: objectDatabase, Table: "mytable", Privileges: []string{"SELECT"}},
+		{Database: objectDatabase, Table: "mytable", Columns: []string{"a", "b"}, Privileges: []string{"UPDATE"}},
+		{Database: objectDatabase, Procedure: "myproc", Privileges: []string{"EXECUTE"}, WithGrant: true},
+	}}}
+	assert.NoError(t, mysqlTestManager.Manage(databases, users))
+
+	var grants []string
+	rows, err := db.Query(mysqlShowGrants(objectUser, "%"))
+	assert.NoError(t, err)
+	for rows.Next() {
+		var grant string
+		assert.NoError(t, rows.Scan(&grant))
+		grants = append(grants, grant)
+	}
+	assert.NoError(t, rows.Close())
+	assert.Contains(t, grants, "GRANT SELECT, UPDATE (`a`, `b`) ON `objectdb`.`mytable` TO `objectuser`@`%`")
+	assert.Contains(t, grants, "GRANT EXECUTE ON PROCEDURE `objectdb`.`myproc` TO `objectuser`@`%` WITH GRANT OPTION")
+
+	// Planning again should find every privilege and have nothing left to do
+	plan, err := mysqlTestManager.Plan(databases, users)
+	assert.NoError(t, err)
+	assert.True(t, plan.Empty(), "Plan should not contain changes: %s", plan)
+
+	// The grants are exported as they're configured
+	_, exported, err := mysqlTestManager.Export()
+	assert.NoError(t, err)
+	assert.Contains(t, exported, users[0])
+
+	// Removing a column from the config prunes just that column
+	users[0].Grants[1].Columns = []string{"a"}
+	plan, err = mysqlTestManager.Plan(databases, users, WithPrune())
+	assert.NoError(t, err)
+	assert.Contains(t, plan.String(), "REVOKE UPDATE (`b`) ON TABLE `objectdb`.`mytable` FROM 'objectuser'@'%'")
+	assert.NotContains(t, plan.String(), "REVOKE SELECT")
+	assert.NotContains(t, plan.String(), "REVOKE EXECUTE")
+
+	assert.NoError(t, mysqlTestManager.DropUser(objectUser))
+	assert.NoError(t, mysqlTestManager.DropDatabase(objectDatabase))
+}
+
+func TestMySQLManager_ManagerIntegration_Roles(t *testing.T) {
+	roleUser := "roleuser"
+	users := []User{{Name: roleUser, Password: "password", Roles: []string{"readrole", "writerole"}}}
+
+	// Roles that don't exist are created, granted and made default roles
+	plan, err := mysqlTestManager.Plan(nil, users)
+	assert.NoError(t, err)
+	assert.Contains(t, plan.String(), "CREATE ROLE 'readrole'@'%'")
+	_, err = mysqlTestManager.Apply(plan)
+	assert.NoError(t, err)
+
+	catalog := newMySQLCatalog(mysqlTestManager.(*mysqlManager))
+	roles, err := catalog.roles(context.Background(), roleUser, "%")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"readrole", "writerole"}, roles)
+	defaults, err := catalog.defaultRoles(context.Background(), roleUser, "%")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"readrole", "writerole"}, defaults)
+
+	// The roles aren't users so aren't pruned or exported
+	plan, err = mysqlTestManager.Plan(nil, users, WithPrune())
+	assert.NoError(t, err)
+	assert.NotContains(t, plan.String(), "role/")
+	assert.NotContains(t, plan.String(), "user/readrole")
+	_, exported, err := mysqlTestManager.Export()
+	assert.NoError(t, err)
+	assert.Contains(t, exported, User{Name: roleUser, Roles: []string{"readrole", "writerole"}})
+	assert.NotContains(t, exported, User{Name: "readrole"})
+
+	// Roles that are removed from the config are revoked and no longer default roles
+	users[0].Roles = []string{"readrole"}
+	assert.NoError(t, mysqlTestManager.Manage(nil, users))
+	catalog = newMySQLCatalog(mysqlTestManager.(*mysqlManager))
+	roles, err = catalog.roles(context.Background(), roleUser, "%")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"readrole"}, roles)
+	defaults, err = catalog.defaultRoles(context.Background(), roleUser, "%")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"readrole"}, defaults)
+
+	drift, err := mysqlTestManager.Diff(nil, users)
+	assert.NoError(t, err)
+	assert.NotContains(t, drift.String(), roleUser)
+
+	assert.NoError(t, mysqlTestManager.DropUser(roleUser))
+	assert.NoError(t, mysqlTestManager.DropUser("readrole"))
+	assert.NoError(t, mysqlTestManager.DropUser("writerole"))
+}
+
+func TestMySQLManager_ManagerIntegration_AccountOptions(t *testing.T) {
+	optionsUser := "optionsuser"
+	users := []User{{Name: optionsUser, Password: "password", Options: UserOptions{
+		MaxQueriesPerHour:   Int(100),
+		MaxUserConnections:  Int(5),
+		PasswordExpireDays:  Int(90),
+		FailedLoginAttempts: Int(3),
+		PasswordLockTime:    Int(-1),
+		Require:             String("SSL"),
+	}}}
+
+	// Options are applied when the user is created
+	assert.NoError(t, mysqlTestManager.Manage(nil, users))
+	catalog := newMySQLCatalog(mysqlTestManager.(*mysqlManager))
+	options, err := catalog.accountOptions(context.Background(), optionsUser, "%")
+	assert.NoError(t, err)
+	assert.Equal(t, UserOptions{
+		MaxQueriesPerHour:   Int(100),
+		MaxUserConnections:  Int(5),
+		AccountLocked:       Bool(false),
+		PasswordExpireDays:  Int(90),
+		FailedLoginAttempts: Int(3),
+		PasswordLockTime:    Int(-1),
+		Require:             String("SSL"),
+	}, options)
+
+	// Options that match aren't changed
+	users[0].Password = ""
+	plan, err := mysqlTestManager.Plan(nil, users)
+	assert.NoError(t, err)
+	assert.NotContains(t, plan.String(), "ALTER USER")
+
+	// Options that differ are reported and changed in a single statement
+	users[0].Options.MaxUserConnections = Int(10)
+	users[0].Options.AccountLocked = Bool(true)
+	users[0].Options.Require = nil
+	users[0].Options.RequireSubject = String("/CN=optionsuser")
+	drift, err := mysqlTestManager.Diff(nil, users)
+	assert.NoError(t, err)
+	assert.Contains(t, drift.String(), "max_user_connections")
+	plan, err = mysqlTestManager.Plan(nil, users)
+	assert.NoError(t, err)
+	assert.Contains(t, plan.String(), "ALTER USER 'optionsuser'@'%' REQUIRE SUBJECT '/CN=optionsuser' WITH MAX_USER_CONNECTIONS 10 ACCOUNT LOCK")
+	_, err = mysqlTestManager.Apply(plan)
+	assert.NoError(t, err)
+
+	drift, err = mysqlTestManager.Diff(nil, users)
+	assert.NoError(t, err)
+	assert.NotContains(t, drift.String(), optionsUser)
+
+	assert.NoError(t, mysqlTestManager.DropUser(optionsUser))
+}
+
+func TestMySQLManager_ManagerIntegration_AuthPlugin(t *testing.T) {
+	pluginUser := "pluginuser"
+	users := []User{{Name: pluginUser, Password: "password", AuthPlugin: "caching_sha2_password"}}
+
+	// The plugin is used when the user is created
+	assert.NoError(t, mysqlTestManager.Manage(nil, users))
+	catalog := newMySQLCatalog(mysqlTestManager.(*mysqlManager))
+	plugin, err := catalog.authPlugin(context.Background(), pluginUser, "%")
+	assert.NoError(t, err)
+	assert.Equal(t, "caching_sha2_password", plugin)
+
+	// A plugin that matches isn't changed
+	users[0].Password = ""
+	plan, err := mysqlTestManager.Plan(nil, users)
+	assert.NoError(t, err)
+	assert.NotContains(t, plan.String(), "ALTER USER")
+
+	// A plugin that differs is reported and switched along with the password
+	users[0].Password = "password"
+	// mysql_native_password was removed in MySQL 9.0 so sha256_password is used instead
+	users[0].AuthPlugin = "sha256_password"
+	drift, err := mysqlTestManager.Diff(nil, users)
+	assert.NoError(t, err)
+	assert.Contains(t, drift.String(), "auth_plugin")
+	plan, err = mysqlTestManager.Plan(nil, users)
+	assert.NoError(t, err)
+	assert.Contains(t, plan.String(), "ALTER USER 'pluginuser'@'%' IDENTIFIED WITH sha256_password BY")
+	_, err = mysqlTestManager.Apply(plan)
+	assert.NoError(t, err)
+
+	catalog = newMySQLCatalog(mysqlTestManager.(*mysqlManager))
+	plugin, err = catalog.authPlugin(context.Background(), pluginUser, "%")
+	assert.NoError(t, err)
+	assert.Equal(t, "sha256_password", plugin)
+
+	assert.NoError(t, mysqlTestManager.DropUser(pluginUser))
+}
+
+func TestMySQLManager_ManagerIntegration_Report(t *testing.T) {
+	reportUser := "reportuser"
+	users := []User{{Name: reportUser, Password: mysqlPassword}}
+	var report Report
+
+	// The first run should create the user
+	assert.NoError(t, mysqlTestManager.Manage(nil, users, WithReport(&report)))
+	assert.True(t, report.Changed(), "Report should show changes")
+
+	// Setting the password again on the second run isn't reported as a change
+	assert.NoError(t, mysqlTestManager.Manage(nil, users, WithReport(&report)))
+	assert.False(t, report.Changed(), "Report should show no changes")
+	assert.Contains(t, report.String(), "unchanged user/"+reportUser)
+
+	assert.NoError(t, mysqlTestManager.DropUser(reportUser))
+}
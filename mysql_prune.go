@@ -0,0 +1,156 @@
+package dbmanager
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+var (
+	// mysqlSystemUsers are users that are never pruned.
+	mysqlSystemUsers = []string{"root", "mysql.*", "rdsadmin", "debian-sys-maint"}
+
+	// mysqlSystemDatabases are databases that are never pruned.
+	mysqlSystemDatabases = []string{"mysql", "information_schema", "performance_schema", "sys"}
+)
+
+// planPrune adds the changes that remove grants, databases and users that aren't in the config to the plan.
+// Extra grants are revoked first, then extra databases are dropped and finally extra users are dropped.
+func (m *mysqlManager) planPrune(ctx context.Context, plan *Plan, databases []Database, users []User, exclude []string) error {
+	excludeUsers := append(slices.Clone(mysqlSystemUsers), exclude...)
+	excludeDatabases := append(slices.Clone(mysqlSystemDatabases), exclude...)
+
+	// Revoke grants that aren't in the config
+	for _, user := range users {
+		if plan.users[mysqlAccountName(user.Name, mysqlHost(user))] || matchesAny(user.Name, excludeUsers) {
+			continue
+		}
+		if err := m.pruneGrants(ctx, plan, user, excludeDatabases); err != nil {
+			return err
+		}
+	}
+
+	// Drop databases that aren't in the config
+	existing, err := m.listDatabases(ctx)
+	if err != nil {
+		return err
+	}
+	for _, name := range existing {
+		if matchesAny(name, excludeDatabases) || slices.ContainsFunc(databases, func(database Database) bool { return database.Name == name }) {
+			continue
+		}
+		plan.Add(Change{
+			Resource: resource("database", name),
+			Action:   ActionDrop,
+			SQL:      mysqlDropDatabase(name),
+			Reason:   "database is not in config",
+		})
+	}
+
+	// Drop users that aren't in the config
+	accounts, err := m.listAccounts(ctx)
+	if err != nil {
+		return err
+	}
+	for _, account := range accounts {
+		name, host := account[0], account[1]
+		if matchesAny(name, excludeUsers) || slices.ContainsFunc(users, mysqlIsAccount(name, host)) || slices.ContainsFunc(users, mysqlHasRole(name, host)) {
+			continue
+		}
+		m.dropAccount(plan, name, host, "user is not in config")
+	}
+
+	return nil
+}
+
+// pruneGrants adds the changes that revoke any privileges a user has that aren't in the user's grants to the
+// plan. Privileges on excluded databases are left alone.
+func (m *mysqlManager) pruneGrants(ctx context.Context, plan *Plan, user User, exclude []string) error {
+	host := mysqlHost(user)
+	privileges, err := m.catalog(plan).accountPrivileges(ctx, user.Name, host)
+	if err != nil {
+		return err
+	}
+
+	for _, privilege := range privileges {
+		if matchesAny(privilege.database, exclude) {
+			continue
+		}
+		if slices.ContainsFunc(user.Grants, func(grant Grant) bool { return mysqlGrantCovers(grant, privilege) }) {
+			continue
+		}
+
+		grant := privilege.grant()
+		query, err := mysqlRevoke(grant, user.Name, host)
+		if err != nil {
+			return err
+		}
+		plan.Add(Change{
+			Resource: resource("grant", mysqlAccountName(user.Name, host), mysqlGrantTarget(grant)),
+			Action:   ActionRevoke,
+			Database: privilege.database,
+			SQL:      query,
+			Reason:   fmt.Sprintf("%s privilege is not in config", privilege.privilege),
+		})
+	}
+
+	return nil
+}
+
+// mysqlGrantCovers returns true if the grant includes the privilege.
+func mysqlGrantCovers(grant Grant, privilege mysqlPrivilege) bool {
+	kind, name := mysqlGrantObject(grant)
+	if grant.Database != privilege.database || kind != privilege.kind || name != privilege.name {
+		return false
+	}
+	if kind == "column" && !slices.Contains(grant.Columns, privilege.column) {
+		return false
+	}
+
+	return slices.ContainsFunc(grant.Privileges, func(p string) bool {
+		p = strings.ToUpper(p)
+		return p == privilege.privilege || ((p == "ALL" || p == "ALL PRIVILEGES") && slices.Contains(mysqlAllPrivileges[kind], privilege.privilege))
+	})
+}
+
+// listDatabases returns the names of all databases on the server.
+func (m *mysqlManager) listDatabases(ctx context.Context) ([]string, error) {
+	rows, err := m.db.QueryContext(ctx, "SELECT SCHEMA_NAME FROM INFORMATION_SCHEMA.SCHEMATA ORDER BY SCHEMA_NAME")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var databases []string
+	for rows.Next() {
+		var database string
+		if err := rows.Scan(&database); err != nil {
+			return nil, err
+		}
+		databases = append(databases, database)
+	}
+
+	return databases, rows.Err()
+}
+
+// listAccounts returns the user and host of every account on the server, excluding the user we're
+// connected as.
+func (m *mysqlManager) listAccounts(ctx context.Context) ([][2]string, error) {
+	rows, err := m.db.QueryContext(ctx, "SELECT User, Host FROM mysql.user WHERE User <> '' AND User <> ? ORDER BY User, Host", m.connection.Username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var accounts [][2]string
+	for rows.Next() {
+		var account [2]string
+		if err := rows.Scan(&account[0], &account[1]); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+
+	return accounts, rows.Err()
+}
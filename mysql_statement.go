@@ -0,0 +1,260 @@
+package dbmanager
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// mysqlPluginPattern matches the names of authentication plugins, which are used in statements unquoted.
+var mysqlPluginPattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// mysqlPrivileges lists the privileges that can be granted on each kind of object in MySQL.
+var mysqlPrivileges = map[string][]string{
+	"database":  append(slices.Clone(mysqlAllPrivileges["database"]), "GRANT OPTION"),
+	"table":     append(slices.Clone(mysqlAllPrivileges["table"]), "GRANT OPTION"),
+	"column":    mysqlAllPrivileges["column"],
+	"function":  append(slices.Clone(mysqlAllPrivileges["function"]), "GRANT OPTION"),
+	"procedure": append(slices.Clone(mysqlAllPrivileges["procedure"]), "GRANT OPTION"),
+}
+
+// mysqlLiteralReplacer escapes the characters that are special in a MySQL string literal. Backslashes are
+// escaped as well as quotes because they are escape characters unless NO_BACKSLASH_ESCAPES is set.
+var mysqlLiteralReplacer = strings.NewReplacer(
+	`\`, `\\`,
+	`'`, `''`,
+	"\x00", `\0`,
+	"\n", `\n`,
+	"\r", `\r`,
+	"\x1a", `\Z`,
+)
+
+// quoteMySQLIdentifier quotes an identifier, such as a database name, with backticks to be used as part of
+// an SQL statement. Any backticks in name will be escaped. If name contains a zero byte, the result will be
+// truncated immediately before it.
+func quoteMySQLIdentifier(name string) string {
+	if end := strings.IndexByte(name, 0); end > -1 {
+		name = name[:end]
+	}
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// quoteMySQLLiteral quotes a string literal to be used as part of an SQL statement.
+func quoteMySQLLiteral(value string) string {
+	return "'" + mysqlLiteralReplacer.Replace(value) + "'"
+}
+
+// mysqlHost returns the host of a user's account, % if it isn't set.
+func mysqlHost(user User) string {
+	if user.Host == "" {
+		return "%"
+	}
+	return user.Host
+}
+
+// mysqlAccountName returns the name of an account used to identify it in resources and logs. Accounts that
+// can connect from any host are identified by the user name, others by name@host.
+func mysqlAccountName(name, host string) string {
+	if host == "%" {
+		return name
+	}
+	return name + "@" + host
+}
+
+// mysqlIsAccount returns a function that reports whether a user is the name@host account, for use with
+// slices.ContainsFunc.
+func mysqlIsAccount(name, host string) func(User) bool {
+	return func(user User) bool {
+		return user.Name == name && mysqlHost(user) == host
+	}
+}
+
+// mysqlHasRole returns a function that reports whether a user has the name@host account as a role, for use
+// with slices.ContainsFunc.
+func mysqlHasRole(name, host string) func(User) bool {
+	return func(user User) bool {
+		return slices.ContainsFunc(user.Roles, func(role string) bool { return mysqlRoleName(role) == mysqlAccountName(name, host) })
+	}
+}
+
+// mysqlAccount returns the quoted 'name'@'host' form of an account.
+func mysqlAccount(name, host string) string {
+	return quoteMySQLLiteral(name) + "@" + quoteMySQLLiteral(host)
+}
+
+// mysqlCreateDatabase returns the statement that creates a database.
+func mysqlCreateDatabase(name string) string {
+	return fmt.Sprintf("CREATE DATABASE %s", quoteMySQLIdentifier(name))
+}
+
+// mysqlDropDatabase returns the statement that drops a database.
+func mysqlDropDatabase(name string) string {
+	return fmt.Sprintf("DROP DATABASE %s", quoteMySQLIdentifier(name))
+}
+
+// mysqlIdentified returns the clause that sets how an account authenticates. The server's default plugin is
+// used if plugin is empty, and no password is set if a plugin is given without one.
+func mysqlIdentified(plugin, password string) string {
+	if plugin == "" {
+		return "IDENTIFIED BY " + quoteMySQLLiteral(password)
+	}
+	if password == "" {
+		return "IDENTIFIED WITH " + plugin
+	}
+	return fmt.Sprintf("IDENTIFIED WITH %s BY %s", plugin, quoteMySQLLiteral(password))
+}
+
+// mysqlCreateUser returns the statement that creates an account with an authentication plugin and password.
+func mysqlCreateUser(name, host, plugin, password string) string {
+	return fmt.Sprintf("CREATE USER %s %s", mysqlAccount(name, host), mysqlIdentified(plugin, password))
+}
+
+// mysqlAccountOptions returns the clauses that set the attributes with a value, ready to be appended to a
+// CREATE USER or ALTER USER statement. It's empty if none of them have a value.
+func mysqlAccountOptions(attributes []mysqlAttribute) string {
+	var options string
+	with := false
+	for _, attribute := range attributes {
+		if attribute.clause == "" {
+			continue
+		}
+		if attribute.with && !with {
+			options += " WITH"
+			with = true
+		}
+		options += " " + attribute.clause
+	}
+	return options
+}
+
+// mysqlAlterUser returns the statement that sets the attributes of an account.
+func mysqlAlterUser(name, host string, attributes []mysqlAttribute) string {
+	return "ALTER USER " + mysqlAccount(name, host) + mysqlAccountOptions(attributes)
+}
+
+// mysqlSetPassword returns the statement that sets the authentication plugin and password of an account.
+// The account keeps its plugin if plugin is empty.
+func mysqlSetPassword(name, host, plugin, password string) string {
+	return fmt.Sprintf("ALTER USER %s %s", mysqlAccount(name, host), mysqlIdentified(plugin, password))
+}
+
+// mysqlDropUser returns the statement that drops an account.
+func mysqlDropUser(name, host string) string {
+	return fmt.Sprintf("DROP USER %s", mysqlAccount(name, host))
+}
+
+// mysqlRevokeAll returns the statement that revokes every privilege from an account.
+func mysqlRevokeAll(name, host string) string {
+	return fmt.Sprintf("REVOKE ALL PRIVILEGES, GRANT OPTION FROM %s", mysqlAccount(name, host))
+}
+
+// mysqlRole returns the name and host of a role given as "name" or "name@host". Roles without a host are
+// the 'name'@'%' account.
+func mysqlRole(role string) (name, host string) {
+	name, host, ok := strings.Cut(role, "@")
+	if !ok || host == "" {
+		host = "%"
+	}
+	return name, host
+}
+
+// mysqlRoleName returns a role in the form it's named in resources and returned by the catalog.
+func mysqlRoleName(role string) string {
+	return mysqlAccountName(mysqlRole(role))
+}
+
+// mysqlRoleAccount returns the quoted 'name'@'host' form of a role.
+func mysqlRoleAccount(role string) string {
+	return mysqlAccount(mysqlRole(role))
+}
+
+// mysqlCreateRole returns the statement that creates a role.
+func mysqlCreateRole(role string) string {
+	return fmt.Sprintf("CREATE ROLE %s", mysqlRoleAccount(role))
+}
+
+// mysqlGrantRole returns the statement that grants a role to an account.
+func mysqlGrantRole(role, name, host string) string {
+	return fmt.Sprintf("GRANT %s TO %s", mysqlRoleAccount(role), mysqlAccount(name, host))
+}
+
+// mysqlRevokeRole returns the statement that revokes a role from an account.
+func mysqlRevokeRole(role, name, host string) string {
+	return fmt.Sprintf("REVOKE %s FROM %s", mysqlRoleAccount(role), mysqlAccount(name, host))
+}
+
+// mysqlSetDefaultRoles returns the statement that sets the roles that are active when an account logs in.
+func mysqlSetDefaultRoles(roles []string, name, host string) string {
+	list := "NONE"
+	if len(roles) > 0 {
+		accounts := make([]string, len(roles))
+		for i, role := range roles {
+			accounts[i] = mysqlRoleAccount(role)
+		}
+		list = strings.Join(accounts, ", ")
+	}
+	return fmt.Sprintf("SET DEFAULT ROLE %s TO %s", list, mysqlAccount(name, host))
+}
+
+// mysqlGrantObject returns the kind of object a grant applies to, "database", "table", "column", "function"
+// or "procedure", and its name. Database grants have no name and column grants are named by their table.
+func mysqlGrantObject(grant Grant) (kind, name string) {
+	switch {
+	case grant.Function != "":
+		return "function", grant.Function
+	case grant.Procedure != "":
+		return "procedure", grant.Procedure
+	case len(grant.Columns) > 0:
+		return "column", grant.Table
+	case grant.Table != "":
+		return "table", grant.Table
+	}
+	return "database", ""
+}
+
+// mysqlObject returns the object a grant applies to ready to be used in a GRANT or REVOKE statement, and the
+// privileges that can be granted on it.
+func mysqlObject(grant Grant) (string, []string) {
+	kind, name := mysqlGrantObject(grant)
+	database := quoteMySQLIdentifier(grant.Database)
+
+	switch kind {
+	case "function", "procedure":
+		return fmt.Sprintf("%s %s.%s", strings.ToUpper(kind), database, quoteMySQLIdentifier(name)), mysqlPrivileges[kind]
+	case "column", "table":
+		return fmt.Sprintf("TABLE %s.%s", database, quoteMySQLIdentifier(name)), mysqlPrivileges[kind]
+	}
+	return database + ".*", mysqlPrivileges[kind]
+}
+
+// mysqlGrant returns the statement that grants the privileges in a grant to an account.
+func mysqlGrant(grant Grant, name, host string) (string, error) {
+	object, allowed := mysqlObject(grant)
+	list, err := privilegeList(grant.Privileges, allowed)
+	if err != nil {
+		return "", err
+	}
+
+	query := fmt.Sprintf("GRANT %s ON %s TO %s", withColumns(list, grant.Columns, quoteMySQLIdentifier), object, mysqlAccount(name, host))
+	if grant.WithGrant {
+		query += " WITH GRANT OPTION"
+	}
+	return query, nil
+}
+
+// mysqlRevoke returns the statement that revokes the privileges in a grant from an account.
+func mysqlRevoke(grant Grant, name, host string) (string, error) {
+	object, allowed := mysqlObject(grant)
+	list, err := privilegeList(grant.Privileges, allowed)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("REVOKE %s ON %s FROM %s", withColumns(list, grant.Columns, quoteMySQLIdentifier), object, mysqlAccount(name, host)), nil
+}
+
+// mysqlShowGrants returns the statement that lists the grants of an account.
+func mysqlShowGrants(name, host string) string {
+	return fmt.Sprintf("SHOW GRANTS FOR %s", mysqlAccount(name, host))
+}
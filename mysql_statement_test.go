@@ -0,0 +1,136 @@
+package dbmanager
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuoteMySQL(t *testing.T) {
+	assert.Equal(t, "`my``db`", quoteMySQLIdentifier("my`db"))
+	assert.Equal(t, "`mydb`", quoteMySQLIdentifier("mydb\x00; DROP DATABASE mysql"))
+	assert.Equal(t, `'it''s \\ \n'`, quoteMySQLLiteral("it's \\ \n"))
+	assert.Equal(t, `'my''user'@'%'`, mysqlAccount("my'user", "%"))
+}
+
+func TestMySQLAccountName(t *testing.T) {
+	assert.Equal(t, "%", mysqlHost(User{Name: "myuser"}))
+	assert.Equal(t, "localhost", mysqlHost(User{Name: "myuser", Host: "localhost"}))
+
+	// Accounts on any host are identified by the user name alone
+	assert.Equal(t, "myuser", mysqlAccountName("myuser", "%"))
+	assert.Equal(t, "myuser@10.0.%", mysqlAccountName("myuser", "10.0.%"))
+
+	users := []User{{Name: "myuser"}, {Name: "myuser", Host: "localhost"}}
+	assert.True(t, slices.ContainsFunc(users, mysqlIsAccount("myuser", "localhost")))
+	assert.False(t, slices.ContainsFunc(users, mysqlIsAccount("myuser", "10.0.%")))
+}
+
+func TestMySQLStatements(t *testing.T) {
+	assert.Equal(t, "CREATE DATABASE `my``db`", mysqlCreateDatabase("my`db"))
+	assert.Equal(t, `CREATE USER 'myuser'@'%' IDENTIFIED BY 'pa''ss\\'`, mysqlCreateUser("myuser", "%", "", `pa'ss\`))
+	assert.Equal(t, `CREATE USER 'myuser'@'%' IDENTIFIED WITH mysql_native_password BY 'pass'`, mysqlCreateUser("myuser", "%", "mysql_native_password", "pass"))
+	assert.Equal(t, `CREATE USER 'myuser'@'localhost' IDENTIFIED WITH auth_socket`, mysqlCreateUser("myuser", "localhost", "auth_socket", ""))
+	assert.Equal(t, `ALTER USER 'myuser'@'%' IDENTIFIED BY 'pass'`, mysqlSetPassword("myuser", "%", "", "pass"))
+	assert.Equal(t, `ALTER USER 'myuser'@'%' IDENTIFIED WITH caching_sha2_password BY 'pass'`, mysqlSetPassword("myuser", "%", "caching_sha2_password", "pass"))
+
+	query, err := mysqlGrant(Grant{Database: "mydb", Privileges: []string{"select", " Insert "}, WithGrant: true}, "myuser", "%")
+	assert.NoError(t, err)
+	assert.Equal(t, "GRANT SELECT, INSERT ON `mydb`.* TO 'myuser'@'%' WITH GRANT OPTION", query)
+
+	query, err = mysqlRevoke(Grant{Database: "mydb", Privileges: []string{"ALL"}}, "myuser", "%")
+	assert.NoError(t, err)
+	assert.Equal(t, "REVOKE ALL ON `mydb`.* FROM 'myuser'@'%'", query)
+
+	// Table, column and routine grants
+	query, err = mysqlGrant(Grant{Database: "mydb", Table: "my`table", Privileges: []string{"SELECT", "SHOW VIEW"}}, "myuser", "%")
+	assert.NoError(t, err)
+	assert.Equal(t, "GRANT SELECT, SHOW VIEW ON TABLE `mydb`.`my``table` TO 'myuser'@'%'", query)
+
+	query, err = mysqlGrant(Grant{Database: "mydb", Table: "mytable", Columns: []string{"a", "b"}, Privileges: []string{"SELECT", "UPDATE"}}, "myuser", "%")
+	assert.NoError(t, err)
+	assert.Equal(t, "GRANT SELECT (`a`, `b`), UPDATE (`a`, `b`) ON TABLE `mydb`.`mytable` TO 'myuser'@'%'", query)
+
+	query, err = mysqlRevoke(Grant{Database: "mydb", Procedure: "myproc", Privileges: []string{"EXECUTE"}}, "myuser", "%")
+	assert.NoError(t, err)
+	assert.Equal(t, "REVOKE EXECUTE ON PROCEDURE `mydb`.`myproc` FROM 'myuser'@'%'", query)
+
+	query, err = mysqlGrant(Grant{Database: "mydb", Function: "myfunc", Privileges: []string{"execute"}}, "myuser", "%")
+	assert.NoError(t, err)
+	assert.Equal(t, "GRANT EXECUTE ON FUNCTION `mydb`.`myfunc` TO 'myuser'@'%'", query)
+
+	// Privileges that can't be granted on the object are rejected
+	_, err = mysqlGrant(Grant{Database: "mydb", Table: "mytable", Columns: []string{"a"}, Privileges: []string{"DELETE"}}, "myuser", "%")
+	assert.ErrorIs(t, err, ErrInvalidGrant)
+
+	// Privileges that aren't in the allow-list should be rejected rather than added to the statement
+	_, err = mysqlGrant(Grant{Database: "mydb", Privileges: []string{"SELECT ON *.* TO 'attacker'@'%'; --"}}, "myuser", "%")
+	assert.ErrorIs(t, err, ErrInvalidGrant)
+
+	_, err = mysqlRevoke(Grant{Database: "mydb"}, "myuser", "%")
+	assert.ErrorIs(t, err, ErrInvalidGrant)
+}
+
+func TestMySQLGrantTarget(t *testing.T) {
+	assert.Equal(t, "database:mydb", mysqlGrantTarget(Grant{Database: "mydb"}))
+	assert.Equal(t, "table:mydb.mytable", mysqlGrantTarget(Grant{Database: "mydb", Table: "mytable"}))
+	assert.Equal(t, "table:mydb.mytable(a,b)", mysqlGrantTarget(Grant{Database: "mydb", Table: "mytable", Columns: []string{"a", "b"}}))
+	assert.Equal(t, "function:mydb.myfunc", mysqlGrantTarget(Grant{Database: "mydb", Function: "myfunc"}))
+	assert.Equal(t, "procedure:mydb.myproc", mysqlGrantTarget(Grant{Database: "mydb", Procedure: "myproc"}))
+}
+
+func TestSplitMySQLColumnGrants(t *testing.T) {
+	grant := Grant{Database: "mydb", Table: "mytable"}
+
+	assert.Equal(t, []Grant{
+		{Database: "mydb", Table: "mytable", Privileges: []string{"INSERT", "DELETE"}},
+		{Database: "mydb", Table: "mytable", Columns: []string{"a", "b,c"}, Privileges: []string{"SELECT", "UPDATE"}},
+		{Database: "mydb", Table: "mytable", Columns: []string{"a"}, Privileges: []string{"REFERENCES"}},
+	}, splitMySQLColumnGrants(grant, "SELECT (`a`, `b,c`), INSERT, UPDATE (`a`, `b,c`), DELETE, REFERENCES (`a`)"))
+
+	// Only column privileges don't leave an empty grant on the table
+	assert.Equal(t, []Grant{
+		{Database: "mydb", Table: "mytable", Columns: []string{"my`col"}, Privileges: []string{"SELECT"}},
+	}, splitMySQLColumnGrants(grant, "SELECT (`my``col`)"))
+}
+
+func TestMySQLRoleStatements(t *testing.T) {
+	assert.Equal(t, "myrole", mysqlRoleName("myrole@%"))
+	assert.Equal(t, "myrole@localhost", mysqlRoleName("myrole@localhost"))
+
+	assert.Equal(t, "CREATE ROLE 'myrole'@'%'", mysqlCreateRole("myrole"))
+	assert.Equal(t, "GRANT 'myrole'@'localhost' TO 'myuser'@'%'", mysqlGrantRole("myrole@localhost", "myuser", "%"))
+	assert.Equal(t, "REVOKE 'myrole'@'%' FROM 'myuser'@'localhost'", mysqlRevokeRole("myrole", "myuser", "localhost"))
+	assert.Equal(t, "SET DEFAULT ROLE 'reader'@'%', 'writer'@'%' TO 'myuser'@'%'", mysqlSetDefaultRoles([]string{"reader", "writer"}, "myuser", "%"))
+	assert.Equal(t, "SET DEFAULT ROLE NONE TO 'myuser'@'%'", mysqlSetDefaultRoles(nil, "myuser", "%"))
+
+	users := []User{{Name: "myuser", Roles: []string{"reader", "writer@localhost"}}}
+	assert.True(t, slices.ContainsFunc(users, mysqlHasRole("writer", "localhost")))
+	assert.False(t, slices.ContainsFunc(users, mysqlHasRole("writer", "%")))
+
+	assert.True(t, sameRoles([]string{"reader", "writer"}, []string{"writer", "reader"}))
+	assert.False(t, sameRoles([]string{"reader"}, nil))
+}
+
+func TestMySQLAccountOptions(t *testing.T) {
+	assert.Empty(t, mysqlAccountOptions(mysqlAttributes(UserOptions{})))
+
+	// Clauses are given in the order CREATE USER expects them, with resource limits after WITH
+	options := UserOptions{
+		AccountLocked:       Bool(true),
+		PasswordLockTime:    Int(-1),
+		FailedLoginAttempts: Int(3),
+		PasswordExpireDays:  Int(0),
+		MaxUserConnections:  Int(5),
+		MaxQueriesPerHour:   Int(100),
+		Require:             String(" ssl"),
+	}
+	assert.Equal(t, " REQUIRE SSL WITH MAX_QUERIES_PER_HOUR 100 MAX_USER_CONNECTIONS 5 PASSWORD EXPIRE NEVER FAILED_LOGIN_ATTEMPTS 3 PASSWORD_LOCK_TIME UNBOUNDED ACCOUNT LOCK",
+		mysqlAccountOptions(mysqlAttributes(options)))
+
+	options = UserOptions{PasswordExpireDays: Int(90), PasswordLockTime: Int(2), AccountLocked: Bool(false), RequireSubject: String("/CN=it's me")}
+	assert.Equal(t, `ALTER USER 'myuser'@'%' REQUIRE SUBJECT '/CN=it''s me' PASSWORD EXPIRE INTERVAL 90 DAY PASSWORD_LOCK_TIME 2 ACCOUNT UNLOCK`,
+		mysqlAlterUser("myuser", "%", mysqlAttributes(options)))
+	assert.Equal(t, " PASSWORD EXPIRE DEFAULT", mysqlAccountOptions(mysqlAttributes(UserOptions{PasswordExpireDays: Int(-1)})))
+}
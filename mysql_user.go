@@ -1,62 +1,211 @@
 package dbmanager
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
+	"strings"
 )
 
 // CreateUser creates a user based on the provided User options.
 func (m *mysqlManager) CreateUser(user User) error {
+	return m.CreateUserContext(context.Background(), user)
+}
+
+// CreateUserContext is like CreateUser but uses the provided context for all queries.
+func (m *mysqlManager) CreateUserContext(ctx context.Context, user User) error {
+	if err := user.Validate(m.engine); err != nil {
+		return err
+	}
+
+	plan := NewPlan()
+	if err := m.planUser(ctx, plan, user); err != nil {
+		return err
+	}
+
+	_, err := m.ApplyContext(ctx, plan)
+	return err
+}
+
+// planUser adds the changes needed to create or update a user to the plan.
+func (m *mysqlManager) planUser(ctx context.Context, plan *Plan, user User) error {
+	host := mysqlHost(user)
+	account := mysqlAccountName(user.Name, host)
+
 	// If the user already exists, we'll update it, otherwise we'll create it
-	exists, err := m.userExists(user.Name)
+	exists, err := m.catalog(plan).userExists(ctx, user.Name, host)
 	if err != nil {
 		return err
 	}
 
 	if !exists {
-		if err := m.createUser(user); err != nil {
-			return err
-		}
+		plan.Add(m.createUser(user))
+		plan.users[account] = true
+		return nil
 	}
 
-	// We can't read back the user's password, so if one is set, we'll just set it again
-	if user.Password != "" {
-		if err := m.setPassword(user.Name, user.Password); err != nil {
-			return err
-		}
+	change, err := m.updateUser(ctx, plan, user)
+	if err != nil {
+		return err
+	}
+	if change != nil {
+		plan.Add(*change)
+	}
+
+	plugin, err := m.catalog(plan).authPlugin(ctx, user.Name, host)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case user.AuthPlugin != "" && user.AuthPlugin != plugin:
+		// Changing the plugin replaces the password, so the password is set in the same statement
+		plan.Add(m.setAuthPlugin(user))
+	case user.Password != "":
+		// We can't read back the user's password, so if one is set, we'll just set it again
+		plan.Add(m.setPassword(user.Name, host, user.Password))
+	case mysqlAccountOptions(mysqlAttributes(user.Options)) == "":
+		plan.unchanged(resource("user", account), "user already exists")
 	}
 
 	return nil
 }
 
-// createUser creates a new user.
-func (m *mysqlManager) createUser(user User) error {
-	log.Printf("Creating user: %s\n", user.Name)
+// mysqlAttribute is an account attribute managed by one of the user's options.
+type mysqlAttribute struct {
+	// name is the option's name in the configuration.
+	name string
 
-	_, err := m.db.Exec(fmt.Sprintf("CREATE USER '%s'@'%%' IDENTIFIED BY '%s'", user.Name, user.Password))
-	if err != nil {
-		return fmt.Errorf("failed to create user: %w", err)
+	// with is set for resource limits, which follow WITH in CREATE USER and ALTER USER.
+	with bool
+
+	// clause sets the attribute to the value in the configuration, it's empty if the option isn't managed.
+	clause string
+}
+
+// mysqlAttributes returns the account attributes for a user's options, in the order their clauses are
+// given in CREATE USER and ALTER USER.
+func mysqlAttributes(options UserOptions) []mysqlAttribute {
+	intClause := func(value *int, format string, special map[int]string) string {
+		switch {
+		case value == nil:
+			return ""
+		case special[*value] != "":
+			return special[*value]
+		}
+		return fmt.Sprintf(format, *value)
 	}
 
-	return nil
+	var require, locked string
+	if options.RequireSubject != nil {
+		require = "REQUIRE SUBJECT " + quoteMySQLLiteral(*options.RequireSubject)
+	} else if options.Require != nil {
+		require = "REQUIRE " + strings.ToUpper(strings.TrimSpace(*options.Require))
+	}
+	if options.AccountLocked != nil {
+		locked = "ACCOUNT UNLOCK"
+		if *options.AccountLocked {
+			locked = "ACCOUNT LOCK"
+		}
+	}
+
+	return []mysqlAttribute{
+		{"require", false, require},
+		{"max_queries_per_hour", true, intClause(options.MaxQueriesPerHour, "MAX_QUERIES_PER_HOUR %d", nil)},
+		{"max_user_connections", true, intClause(options.MaxUserConnections, "MAX_USER_CONNECTIONS %d", nil)},
+		{"password_expire_days", false, intClause(options.PasswordExpireDays, "PASSWORD EXPIRE INTERVAL %d DAY", map[int]string{0: "PASSWORD EXPIRE NEVER", -1: "PASSWORD EXPIRE DEFAULT"})},
+		{"failed_login_attempts", false, intClause(options.FailedLoginAttempts, "FAILED_LOGIN_ATTEMPTS %d", nil)},
+		{"password_lock_time", false, intClause(options.PasswordLockTime, "PASSWORD_LOCK_TIME %d", map[int]string{-1: "PASSWORD_LOCK_TIME UNBOUNDED"})},
+		{"account_locked", false, locked},
+	}
+}
+
+// createUser returns the change that creates a new user. Options that aren't set are left to the server's
+// defaults.
+func (m *mysqlManager) createUser(user User) Change {
+	host := mysqlHost(user)
+	change := Change{
+		Resource: resource("user", mysqlAccountName(user.Name, host)),
+		Action:   ActionCreate,
+		SQL:      mysqlCreateUser(user.Name, host, user.AuthPlugin, user.Password) + mysqlAccountOptions(mysqlAttributes(user.Options)),
+		Reason:   "user does not exist",
+	}
+	if user.Password != "" {
+		change = change.withSecret(quoteMySQLLiteral(user.Password))
+	}
+	return change
+}
+
+// setPassword returns the change that sets the password for the specified account.
+func (m *mysqlManager) setPassword(name, host, password string) Change {
+	return Change{
+		Resource: resource("user", mysqlAccountName(name, host)),
+		Action:   ActionUpdate,
+		SQL:      mysqlSetPassword(name, host, "", password),
+		Reason:   "password is set in config and can't be compared",
+		Reset:    true,
+	}.withSecret(quoteMySQLLiteral(password))
+}
+
+// setAuthPlugin returns the change that switches a user's account to the user's authentication plugin,
+// setting its password too if it has one.
+func (m *mysqlManager) setAuthPlugin(user User) Change {
+	host := mysqlHost(user)
+	change := Change{
+		Resource: resource("user", mysqlAccountName(user.Name, host)),
+		Action:   ActionUpdate,
+		SQL:      mysqlSetPassword(user.Name, host, user.AuthPlugin, user.Password),
+		Reason:   "authentication plugin differs from config",
+	}
+	if user.Password != "" {
+		change = change.withSecret(quoteMySQLLiteral(user.Password))
+	}
+	return change
 }
 
-func (m *mysqlManager) setPassword(name, password string) error {
-	log.Printf("Setting password for user: %s\n", name)
+// updateUser returns the change that updates the options of the user's account, or nil if they already
+// match. Options that aren't set are left alone, and nothing is planned if none are set.
+func (m *mysqlManager) updateUser(ctx context.Context, plan *Plan, user User) (*Change, error) {
+	host := mysqlHost(user)
+	account := mysqlAccountName(user.Name, host)
+
+	attributes := mysqlAttributes(user.Options)
+	if mysqlAccountOptions(attributes) == "" {
+		return nil, nil
+	}
 
-	query := fmt.Sprintf("ALTER USER '%s'@'%%' IDENTIFIED BY '%s'", name, password)
-	_, err := m.db.Exec(query)
+	// Compare with the real account
+	options, err := m.catalog(plan).accountOptions(ctx, user.Name, host)
 	if err != nil {
-		return fmt.Errorf("failed to set password: %w", err)
+		return nil, err
 	}
 
-	return nil
+	var changed []mysqlAttribute
+	actual := mysqlAttributes(options)
+	for i, attribute := range attributes {
+		if attribute.clause != "" && attribute.clause != actual[i].clause {
+			changed = append(changed, attribute)
+		}
+	}
+
+	if len(changed) == 0 {
+		m.Logger().Debug("User is up to date, skipping", "user", account)
+		plan.unchanged(resource("user", account), "user options match config")
+		return nil, nil
+	}
+
+	return &Change{
+		Resource: resource("user", account),
+		Action:   ActionUpdate,
+		SQL:      mysqlAlterUser(user.Name, host, changed),
+		Reason:   "user options differ from config",
+	}, nil
 }
 
-func (m *mysqlManager) userExists(name string) (bool, error) {
+// userExists checks if the specified account exists.
+func (m *mysqlManager) userExists(ctx context.Context, name, host string) (bool, error) {
 	var user string
-	err := m.db.QueryRow("SELECT User FROM mysql.user WHERE User = ?", name).Scan(&user)
+	err := m.db.QueryRowContext(ctx, "SELECT User FROM mysql.user WHERE User = ? AND Host = ?", name, host).Scan(&user)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			// No user found, return false without error
@@ -69,3 +218,62 @@ func (m *mysqlManager) userExists(name string) (bool, error) {
 	// If we reach here, it means the user exists
 	return true, nil
 }
+
+// DropUser drops a user if it exists. The name can be given as "user@host" to drop a single account,
+// otherwise the user's accounts on every host are dropped.
+func (m *mysqlManager) DropUser(name string, options ...DropOption) error {
+	return m.DropUserContext(context.Background(), name, options...)
+}
+
+// DropUserContext is like DropUser but uses the provided context for all queries.
+func (m *mysqlManager) DropUserContext(ctx context.Context, name string, options ...DropOption) error {
+	plan := NewPlan()
+	if err := m.planDropUser(ctx, plan, name); err != nil {
+		return err
+	}
+
+	_, err := m.ApplyContext(ctx, plan)
+	return err
+}
+
+// planDropUser adds the changes that drop a user's accounts to the plan.
+func (m *mysqlManager) planDropUser(ctx context.Context, plan *Plan, name string) error {
+	user, host, hasHost := strings.Cut(name, "@")
+
+	accounts, err := m.listAccounts(ctx)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for _, account := range accounts {
+		if account[0] != user || (hasHost && account[1] != host) {
+			continue
+		}
+		m.dropAccount(plan, account[0], account[1], "drop requested")
+		found = true
+	}
+
+	if !found {
+		m.Logger().Debug("User does not exist, skipping", "user", name)
+	}
+
+	return nil
+}
+
+// dropAccount adds the changes that drop a single user@host account to the plan. MySQL doesn't allow users
+// to own objects so revoking all privileges is the only cleanup needed.
+func (m *mysqlManager) dropAccount(plan *Plan, name, host, reason string) {
+	plan.Add(Change{
+		Resource: resource("user", mysqlAccountName(name, host)),
+		Action:   ActionRevoke,
+		SQL:      mysqlRevokeAll(name, host),
+		Reason:   "revoke privileges before dropping user",
+	})
+	plan.Add(Change{
+		Resource: resource("user", mysqlAccountName(name, host)),
+		Action:   ActionDrop,
+		SQL:      mysqlDropUser(name, host),
+		Reason:   reason,
+	})
+}
@@ -0,0 +1,301 @@
+package dbmanager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Action describes what a planned change will do to a resource.
+type Action string
+
+const (
+	ActionCreate Action = "create"
+	ActionUpdate Action = "update"
+	ActionGrant  Action = "grant"
+	ActionRevoke Action = "revoke"
+	ActionDrop   Action = "drop"
+)
+
+// Change represents a single statement that will be run when a plan is applied.
+type Change struct {
+	// Resource identifies the object being changed, e.g. "user/myuser" or "database/mydb".
+	Resource string `json:"resource"`
+
+	// Action is the kind of change being made to the resource.
+	Action Action `json:"action"`
+
+	// Database is the database the statement must be run in, or applies to for MySQL where statements
+	// name the database. If empty, the statement is run using the manager's own connection.
+	Database string `json:"database,omitempty"`
+
+	// SQL is the statement that will be run.
+	SQL string `json:"sql"`
+
+	// Reason explains why the change is needed.
+	Reason string `json:"reason"`
+
+	// Cleanup marks changes that undo a temporary change made earlier in the plan, these are
+	// still run when an earlier change fails.
+	Cleanup bool `json:"cleanup,omitempty"`
+
+	// Reset marks changes that set a value again because it can't be read back and compared, such as a
+	// password. They're run every time so don't count as a change to the resource in the report.
+	Reset bool `json:"reset,omitempty"`
+
+	// redacted is a copy of SQL with any secrets masked, used when the change is displayed.
+	redacted string
+
+	// masked is set on changes decoded from JSON that had secrets masked when they were encoded, their SQL
+	// can't be run.
+	masked bool
+}
+
+// String returns a human readable version of the change with any secrets masked.
+func (c Change) String() string {
+	return fmt.Sprintf("%s %s: %s (%s)", c.Action, c.Resource, c.displaySQL(), c.Reason)
+}
+
+// encodedChange is a change as it's encoded as JSON, Redacted records that secrets in the SQL were masked.
+type encodedChange struct {
+	change
+	Redacted bool `json:"redacted,omitempty"`
+}
+
+// change has the fields of Change without its methods, so it can be encoded and decoded as the default.
+type change Change
+
+// MarshalJSON encodes the change with any secrets masked.
+func (c Change) MarshalJSON() ([]byte, error) {
+	out := encodedChange{change: change(c), Redacted: c.redacted != "" || c.masked}
+	out.SQL = c.displaySQL()
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON decodes a change, a change that had secrets masked is marked so it isn't applied.
+func (c *Change) UnmarshalJSON(data []byte) error {
+	var in encodedChange
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	*c = Change(in.change)
+	c.masked = in.Redacted
+	return nil
+}
+
+// withSecret returns a copy of the change with a secret masked when the change is displayed. literal is the
+// secret quoted as it appears in the SQL, so secrets that needed escaping are masked too.
+func (c Change) withSecret(literal string) Change {
+	if literal != "" {
+		c.redacted = strings.ReplaceAll(c.SQL, literal, "'********'")
+	}
+	return c
+}
+
+// displaySQL returns the SQL statement with any secrets masked.
+func (c Change) displaySQL() string {
+	if c.redacted != "" {
+		return c.redacted
+	}
+	return c.SQL
+}
+
+// Plan is an ordered list of changes needed to bring a server in line with the provided configuration.
+//
+// A plan is created by Manager.Plan and nothing is run until it is passed to Manager.Apply. Secrets,
+// such as passwords, are masked when a plan is printed or encoded as JSON, so Apply returns
+// ErrRedactedPlan for a plan decoded from JSON that had secrets masked.
+type Plan struct {
+	Changes []Change `json:"changes"`
+
+	// users and databases that will be created when the plan is applied, these are used when planning
+	// dependent changes as the server can't be queried for resources that don't exist yet.
+	users     map[string]bool
+	databases map[string]bool
+
+	// notes records the resources that were checked but need no changes, these are added to the report
+	// when the plan is applied.
+	notes []ResourceReport
+
+	// continueOnError is set by WithContinueOnError, when set resources that fail are recorded in errs and
+	// planning and applying carries on with the next resource.
+	continueOnError bool
+	errs            []error
+
+	// concurrency is set by WithConcurrency, it's the number of databases that are planned or applied at
+	// the same time.
+	concurrency int
+
+	// lockTimeout is set by WithLockTimeout, it's how long Apply waits for the server-wide lock.
+	lockTimeout time.Duration
+
+	// catalog is the engine's snapshot of the server that planning checks against, it's loaded the first
+	// time it's needed.
+	catalog any
+}
+
+// NewPlan returns an empty plan for a run with the provided options.
+func NewPlan(options ...ManageOption) *Plan {
+	settings := NewManageSettings(options...)
+	return &Plan{
+		Changes:         []Change{},
+		users:           map[string]bool{},
+		databases:       map[string]bool{},
+		continueOnError: settings.ContinueOnError,
+		concurrency:     settings.Concurrency,
+		lockTimeout:     lockTimeout(settings),
+	}
+}
+
+// Empty returns true if the plan contains no changes.
+func (p *Plan) Empty() bool {
+	return len(p.Changes) == 0
+}
+
+// String returns a human readable version of the plan, one change per line.
+func (p *Plan) String() string {
+	if p.Empty() {
+		return "No changes"
+	}
+
+	var sb strings.Builder
+	for _, change := range p.Changes {
+		sb.WriteString(change.String())
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// Add appends a change to the plan.
+func (p *Plan) Add(change Change) {
+	p.Changes = append(p.Changes, change)
+}
+
+// unchanged records a resource that was checked and already matches the config.
+func (p *Plan) unchanged(resource, reason string) {
+	p.notes = append(p.notes, ResourceReport{Resource: resource, Status: StatusUnchanged, Reason: reason})
+}
+
+// skipped records a resource that couldn't be managed.
+func (p *Plan) skipped(resource, reason string) {
+	p.notes = append(p.notes, ResourceReport{Resource: resource, Status: StatusSkipped, Reason: reason})
+}
+
+// try runs fn, which adds the changes needed for a resource to the plan, and wraps any error it returns in
+// a ResourceError. When the plan continues on error the changes fn added are removed, the failure is
+// recorded and nil is returned so the next resource can be planned.
+func (p *Plan) try(resource string, fn func() error) error {
+	changes, notes := len(p.Changes), len(p.notes)
+
+	err := fn()
+	if err == nil {
+		return nil
+	}
+
+	resourceErr := newResourceError(resource, "", err)
+	if !p.continueOnError || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return resourceErr
+	}
+
+	p.Changes, p.notes = p.Changes[:changes], p.notes[:notes]
+	p.notes = append(p.notes, ResourceReport{Resource: resource, Status: StatusFailed, Error: err.Error()})
+	p.errs = append(p.errs, resourceErr)
+
+	return nil
+}
+
+// parallel runs fn for each resource, up to the plan's concurrency at a time. Each call adds its changes to
+// its own plan and these are merged into p in the order of resources, so the plan is the same as when fn is
+// run for one resource at a time. Errors are handled as by try and the first, in order, is returned.
+func (p *Plan) parallel(resources []string, fn func(plan *Plan, i int) error) error {
+	plans := make([]*Plan, len(resources))
+	errs := make([]error, len(resources))
+
+	forEach(p.concurrency, len(resources), func(i int) {
+		plan := p.child()
+		errs[i] = plan.try(resources[i], func() error { return fn(plan, i) })
+		plans[i] = plan
+	})
+
+	for i, plan := range plans {
+		if errs[i] != nil {
+			return errs[i]
+		}
+		p.merge(plan)
+	}
+	return nil
+}
+
+// child returns an empty plan with the same settings, catalog and planned users and databases as p.
+func (p *Plan) child() *Plan {
+	return &Plan{
+		Changes:         []Change{},
+		users:           maps.Clone(p.users),
+		databases:       maps.Clone(p.databases),
+		continueOnError: p.continueOnError,
+		concurrency:     p.concurrency,
+		catalog:         p.catalog,
+	}
+}
+
+// merge appends the changes, notes and errors of a child plan to p.
+func (p *Plan) merge(child *Plan) {
+	p.Changes = append(p.Changes, child.Changes...)
+	p.notes = append(p.notes, child.notes...)
+	p.errs = append(p.errs, child.errs...)
+	maps.Copy(p.users, child.users)
+	maps.Copy(p.databases, child.databases)
+}
+
+// forEach calls fn with every index from 0 to n, running up to concurrency calls at the same time, and
+// returns once they've all returned.
+func forEach(concurrency, n int, fn func(i int)) {
+	if concurrency <= 1 {
+		for i := 0; i < n; i++ {
+			fn(i)
+		}
+		return
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(concurrency, n); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
+
+// err returns every error recorded while planning joined together, or nil if there were none.
+func (p *Plan) err() error {
+	return errors.Join(p.errs...)
+}
+
+// resourceNames returns the identifier of each item, which are resources of the specified type.
+func resourceNames[T any](kind string, items []T, name func(T) string) []string {
+	names := make([]string, len(items))
+	for i, item := range items {
+		names[i] = resource(kind, name(item))
+	}
+	return names
+}
+
+// resource returns the identifier for a resource of the specified type.
+func resource(kind string, names ...string) string {
+	return kind + "/" + strings.Join(names, "/")
+}
@@ -0,0 +1,197 @@
+package dbmanager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testChanges returns a plan that creates a user, grants it privileges in several databases and then
+// drops it, so only the grants can be run in parallel.
+func testChanges(options ...ManageOption) *Plan {
+	plan := NewPlan(options...)
+	plan.Add(Change{Resource: "user/myuser", Action: ActionCreate, SQL: "create"})
+	for _, database := range []string{"db1", "db2", "db3"} {
+		for _, schema := range []string{"s1", "s2"} {
+			plan.Add(Change{Resource: resource("grant", "myuser", "schema:"+database+"."+schema), Action: ActionGrant, Database: database, SQL: database + "." + schema})
+		}
+	}
+	plan.Add(Change{Resource: "user/myuser", Action: ActionDrop, SQL: "drop"})
+	return plan
+}
+
+func TestApplyPlan_Concurrency(t *testing.T) {
+	for _, concurrency := range []int{0, 1, 2, 8} {
+		t.Run(fmt.Sprint(concurrency), func(t *testing.T) {
+			var mu sync.Mutex
+			var ran []string
+			plan := testChanges(WithConcurrency(concurrency))
+
+			report, err := ApplyPlan(context.Background(), plan, func(ctx context.Context, change Change) error {
+				mu.Lock()
+				defer mu.Unlock()
+				ran = append(ran, change.SQL)
+				return nil
+			})
+			assert.NoError(t, err)
+
+			// The user is created before and dropped after every grant, and each database's grants are in order
+			assert.Len(t, ran, 8)
+			assert.Equal(t, "create", ran[0])
+			assert.Equal(t, "drop", ran[7])
+			for _, database := range []string{"db1", "db2", "db3"} {
+				assert.Less(t, slices.Index(ran, database+".s1"), slices.Index(ran, database+".s2"))
+			}
+
+			// The report is in the order of the plan whichever change finished first
+			var resources []string
+			for _, resource := range report.Resources {
+				resources = append(resources, resource.Resource)
+			}
+			assert.Equal(t, []string{
+				"user/myuser",
+				"grant/myuser/schema:db1.s1", "grant/myuser/schema:db1.s2",
+				"grant/myuser/schema:db2.s1", "grant/myuser/schema:db2.s2",
+				"grant/myuser/schema:db3.s1", "grant/myuser/schema:db3.s2",
+			}, resources)
+		})
+	}
+}
+
+func TestApplyPlan_ConcurrencyErrors(t *testing.T) {
+	exec := func(ctx context.Context, change Change) error {
+		if change.Database == "db1" || change.SQL == "db3.s2" {
+			return errors.New("failed")
+		}
+		return nil
+	}
+
+	// A failure skips the rest of its database and everything after the grants, other databases finish
+	report, err := ApplyPlan(context.Background(), testChanges(WithConcurrency(4)), exec)
+	assert.Error(t, err)
+	var resourceErr *ResourceError
+	assert.ErrorAs(t, err, &resourceErr)
+	assert.Equal(t, "grant/myuser/schema:db1.s1", resourceErr.Resource, "The first error should be the first in the plan")
+	assert.Len(t, err.(interface{ Unwrap() []error }).Unwrap(), 2)
+
+	statuses := map[string]ResourceStatus{}
+	for _, resource := range report.Resources {
+		statuses[resource.Resource] = resource.Status
+	}
+	assert.Equal(t, map[string]ResourceStatus{
+		"user/myuser":                StatusCreated,
+		"grant/myuser/schema:db1.s1": StatusFailed,
+		"grant/myuser/schema:db1.s2": StatusSkipped,
+		"grant/myuser/schema:db2.s1": StatusUpdated,
+		"grant/myuser/schema:db2.s2": StatusUpdated,
+		"grant/myuser/schema:db3.s1": StatusUpdated,
+		"grant/myuser/schema:db3.s2": StatusFailed,
+	}, statuses)
+
+	// Continuing on error runs everything and returns every failure
+	_, err = ApplyPlan(context.Background(), testChanges(WithConcurrency(4), WithContinueOnError()), exec)
+	assert.Len(t, err.(interface{ Unwrap() []error }).Unwrap(), 3)
+}
+
+func TestPlan_Parallel(t *testing.T) {
+	resources := []string{"database/db1", "database/db2", "database/db3"}
+	plan := NewPlan(WithConcurrency(3), WithContinueOnError())
+
+	err := plan.parallel(resources, func(plan *Plan, i int) error {
+		if i == 1 {
+			plan.Add(Change{Resource: resources[i], SQL: "removed"})
+			return errors.New("failed")
+		}
+		plan.Add(Change{Resource: resources[i], SQL: fmt.Sprint(i)})
+		plan.databases[resources[i]] = true
+		return nil
+	})
+	assert.NoError(t, err)
+
+	// Changes are merged in order and the failed resource's changes are removed
+	assert.Equal(t, []Change{{Resource: "database/db1", SQL: "0"}, {Resource: "database/db3", SQL: "2"}}, plan.Changes)
+	assert.Equal(t, map[string]bool{"database/db1": true, "database/db3": true}, plan.databases)
+	assert.Error(t, plan.err())
+}
+
+func TestApplyPlan_Redacted(t *testing.T) {
+	plan := NewPlan()
+	plan.Add(Change{Resource: "user/myuser", Action: ActionCreate, SQL: "CREATE USER myuser"})
+	plan.Add(Change{Resource: "user/myuser", Action: ActionUpdate, SQL: "ALTER USER myuser WITH PASSWORD 'secret'"}.withSecret("'secret'"))
+
+	data, err := json.Marshal(plan)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "secret")
+
+	// A plan with masked secrets would set the masked value so isn't applied at all
+	var decoded Plan
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	applied := 0
+	_, err = ApplyPlan(context.Background(), &decoded, func(context.Context, Change) error {
+		applied++
+		return nil
+	})
+	assert.ErrorIs(t, err, ErrRedactedPlan)
+	assert.Zero(t, applied)
+
+	// Encoding a decoded plan again keeps the changes marked
+	data, err = json.Marshal(&decoded)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"redacted":true`)
+
+	// Plans without secrets can be decoded and applied
+	data, err = json.Marshal(&Plan{Changes: plan.Changes[:1]})
+	assert.NoError(t, err)
+	decoded = Plan{}
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	_, err = ApplyPlan(context.Background(), &decoded, func(context.Context, Change) error {
+		applied++
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, applied)
+}
+
+func TestApplyPlan_CleanupErrors(t *testing.T) {
+	plan := NewPlan()
+	plan.Add(Change{Resource: "grant/myuser/role:myrole", Action: ActionGrant, SQL: "add membership"})
+	plan.Add(Change{Resource: "default_privilege/mydb/public/myuser", Action: ActionGrant, SQL: "alter default privileges"})
+	plan.Add(Change{Resource: "grant/myuser/role:myrole", Action: ActionRevoke, SQL: "remove membership", Cleanup: true})
+
+	_, err := ApplyPlan(context.Background(), plan, func(_ context.Context, change Change) error {
+		if change.SQL == "add membership" {
+			return nil
+		}
+		return errors.New(change.SQL + " failed")
+	})
+
+	// A failed cleanup leaves something behind so it's returned with the error that caused it to run
+	assert.ErrorContains(t, err, "alter default privileges failed")
+	assert.ErrorContains(t, err, "remove membership failed")
+}
+
+func TestApplyPlan_Reset(t *testing.T) {
+	plan := NewPlan()
+	plan.Add(Change{Resource: "user/myuser", Action: ActionUpdate, SQL: "set password", Reason: "password can't be compared", Reset: true})
+	plan.Add(Change{Resource: "user/other", Action: ActionUpdate, SQL: "set password", Reset: true})
+	plan.Add(Change{Resource: "user/other", Action: ActionUpdate, SQL: "set options"})
+
+	report, err := ApplyPlan(context.Background(), plan, func(context.Context, Change) error { return nil })
+	assert.NoError(t, err)
+
+	// Setting a password again isn't a change, unless something else about the resource changed
+	assert.Equal(t, StatusUnchanged, report.Resources[0].Status)
+	assert.Equal(t, "password can't be compared", report.Resources[0].Reason)
+	assert.Equal(t, StatusUpdated, report.Resources[1].Status)
+
+	plan.Changes = plan.Changes[:1]
+	report, err = ApplyPlan(context.Background(), plan, func(context.Context, Change) error { return nil })
+	assert.NoError(t, err)
+	assert.False(t, report.Changed())
+}
@@ -1,48 +1,62 @@
 package dbmanager
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"log"
+	"strings"
+	"sync"
+	"time"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 )
 
+func init() {
+	Register("postgres", newPostgresManager)
+}
+
 type postgresManager struct {
-	databaseManager
+	BaseManager
+
+	// databases caches the connections to databases other than the one the manager is connected to, so
+	// they're shared by every grant and default privilege. They're closed by Disconnect.
+	databasesMu sync.Mutex
+	databases   map[string]*postgresManager
 }
 
 // newPostgresManager creates a new PostgreSQL manager.
 func newPostgresManager(options ...func(*Connection)) Manager {
-	manager := &postgresManager{
-		databaseManager: databaseManager{
-			connection: Connection{
-				Database: "postgres",
-				Port:     "5432",
-				SSLMode:  "disable",
-			},
-		},
+	return &postgresManager{
+		BaseManager: NewBaseManager("postgres", Connection{
+			Database: "postgres",
+			Port:     "5432",
+			SSLMode:  "disable",
+		}, options...),
 	}
-	manager.initialize(options...)
-	return manager
 }
 
 // Connect connects to the PostgreSQL server.
 func (m *postgresManager) Connect() error {
-	log.Printf("Connecting to %s:%s as %s\n", m.connection.Host, m.connection.Port, m.connection.Username)
+	return m.ConnectContext(context.Background())
+}
+
+// ConnectContext connects to the PostgreSQL server using the provided context.
+func (m *postgresManager) ConnectContext(ctx context.Context) error {
+	m.Logger().Info("Connecting", "port", m.connection.Port, "database", m.connection.Database, "user", m.connection.Username)
 
 	db, err := sql.Open("pgx", m.connectionString(m.connection))
 	if err != nil {
 		return fmt.Errorf("error connecting to PostgreSQL database: %w", err)
 	}
 
-	m.db = db
-
-	if err := m.db.Ping(); err != nil {
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
 		return fmt.Errorf("error pinging PostgreSQL database: %w", err)
 	}
 
-	log.Printf("Connected to PostgreSQL database %s on %s", m.connection.Database, m.connection.Host)
+	m.db = db
+	m.Logger().Info("Connected", "database", m.connection.Database)
 
 	return nil
 }
@@ -57,39 +71,259 @@ func (m *postgresManager) connectionString(connection Connection) string {
 	return connectionString
 }
 
-// Disconnect disconnects from the PostgreSQL server.
+// Disconnect disconnects from the PostgreSQL server, closing the connections to any other databases too.
 func (m *postgresManager) Disconnect() error {
-	log.Println("Disconnecting...")
+	m.Logger().Info("Disconnecting", "database", m.connection.Database)
+
+	m.databasesMu.Lock()
+	databases := m.databases
+	m.databases = nil
+	m.databasesMu.Unlock()
 
-	if err := m.db.Close(); err != nil {
-		return fmt.Errorf("error closing connection to PostgreSQL database: %w", err)
+	var errs []error
+	for _, db := range databases {
+		errs = append(errs, db.Disconnect())
 	}
 
-	return nil
+	if m.db != nil {
+		if err := m.db.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("error closing connection to PostgreSQL database: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// database returns a manager connected to the specified database. Connections are cached until Disconnect
+// is called, the manager itself is returned for the database it is connected to.
+func (m *postgresManager) database(ctx context.Context, name string) (*postgresManager, error) {
+	if name == m.connection.Database {
+		return m, nil
+	}
+
+	m.databasesMu.Lock()
+	db, ok := m.databases[name]
+	m.databasesMu.Unlock()
+	if ok {
+		return db, nil
+	}
+
+	// Connecting is done without holding the lock so databases planned in parallel connect at the same time
+	db = m.forDatabase(name)
+	if err := db.ConnectContext(ctx); err != nil {
+		return nil, err
+	}
+
+	m.databasesMu.Lock()
+	defer m.databasesMu.Unlock()
+
+	// Another change may have connected to the database first, its connection is kept
+	if cached, ok := m.databases[name]; ok {
+		db.db.Close()
+		return cached, nil
+	}
+
+	if m.databases == nil {
+		m.databases = map[string]*postgresManager{}
+	}
+	m.databases[name] = db
+
+	return db, nil
+}
+
+// closeDatabase closes the cached connection to the specified database, if there is one.
+func (m *postgresManager) closeDatabase(name string) error {
+	m.databasesMu.Lock()
+	db, ok := m.databases[name]
+	delete(m.databases, name)
+	m.databasesMu.Unlock()
+
+	if !ok {
+		return nil
+	}
+	return db.Disconnect()
+}
+
+// forDatabase returns a new, unconnected, manager using the same server and credentials as this manager
+// but connecting to the specified database.
+func (m *postgresManager) forDatabase(database string) *postgresManager {
+	return &postgresManager{
+		BaseManager: BaseManager{
+			connection: Connection{
+				Host:     m.connection.Host,
+				Database: database,
+				Port:     m.connection.Port,
+				Username: m.connection.Username,
+				Password: m.connection.Password,
+				SSLMode:  m.connection.SSLMode,
+				logger:   m.connection.logger,
+			},
+			engine: m.engine,
+		},
+	}
 }
 
 // Manage manages the databases and users based on the provided options.
-func (m *postgresManager) Manage(databases []Database, users []User) error {
+func (m *postgresManager) Manage(databases []Database, users []User, options ...ManageOption) error {
+	return m.ManageContext(context.Background(), databases, users, options...)
+}
+
+// ManageContext is like Manage but uses the provided context for all queries.
+func (m *postgresManager) ManageContext(ctx context.Context, databases []Database, users []User, options ...ManageOption) error {
+	settings := NewManageSettings(options...)
+	return withLock(ctx, m.lock, lockTimeout(settings), func() error {
+		plan, planErr := m.PlanContext(ctx, databases, users, options...)
+		if plan == nil {
+			return planErr
+		}
+
+		report, err := m.apply(ctx, plan)
+		if settings.Report != nil {
+			*settings.Report = *report
+		}
+
+		return errors.Join(planErr, err)
+	})
+}
+
+// postgresLockKey is the advisory lock key held by Manage, "dbmanagr" in ASCII.
+const postgresLockKey int64 = 0x64626d616e616772
+
+// postgresLockDatabase is the database the advisory lock is taken in. Advisory locks only conflict with
+// locks taken in the same database, so runs connected to any database take it in this one.
+const postgresLockDatabase = "postgres"
+
+// lock takes the advisory lock that stops two runs managing the server at the same time, waiting up to
+// timeout for another run to release it. The lock is held by its own connection to postgresLockDatabase
+// until the returned function is called.
+func (m *postgresManager) lock(ctx context.Context, timeout time.Duration) (func(ctx context.Context) error, error) {
+	db, err := m.database(ctx, postgresLockDatabase)
+	if err != nil {
+		return nil, fmt.Errorf("error taking lock: %w", err)
+	}
+	conn, err := db.db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error taking lock: %w", err)
+	}
+
+	// lock_timeout only applies to this connection, which is discarded rather than returned to the pool.
+	// A lock_timeout of 0 waits forever so it's at least a millisecond.
+	m.Logger().Debug("Waiting for lock", "lock", lockName, "timeout", timeout)
+	_, err = conn.ExecContext(ctx, "SELECT set_config('lock_timeout', $1, false)", fmt.Sprintf("%dms", max(timeout.Milliseconds(), 1)))
+	if err == nil {
+		_, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", postgresLockKey)
+	}
+	if err != nil {
+		discardConn(conn)
+		if sqlState(err) == "55P03" {
+			return nil, errLocked(timeout)
+		}
+		return nil, fmt.Errorf("error taking lock: %w", err)
+	}
+
+	return func(ctx context.Context) error {
+		defer discardConn(conn)
+		_, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", postgresLockKey)
+		return err
+	}, nil
+}
+
+// Plan returns the changes needed to manage the databases and users based on the provided options
+// without running any of them.
+func (m *postgresManager) Plan(databases []Database, users []User, options ...ManageOption) (*Plan, error) {
+	return m.PlanContext(context.Background(), databases, users, options...)
+}
+
+// PlanContext is like Plan but uses the provided context for all queries.
+func (m *postgresManager) PlanContext(ctx context.Context, databases []Database, users []User, options ...ManageOption) (*Plan, error) {
+	if err := validate(m.engine, databases, users); err != nil {
+		return nil, err
+	}
+
+	plan := NewPlan(options...)
+	settings := NewManageSettings(options...)
+
+	// The catalog is shared by the databases and users planned in parallel
+	m.catalog(plan)
+
 	// Create users
 	for _, user := range users {
-		if err := m.CreateUser(user); err != nil {
-			return err
+		err := plan.try(resource("user", user.Name), func() error {
+			return m.planUser(ctx, plan, user)
+		})
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	// Create databases
-	for _, database := range databases {
-		if err := m.CreateDatabase(database); err != nil {
-			return err
-		}
+	// Create databases, each database is planned using its own connection
+	err := plan.parallel(resourceNames("database", databases, func(database Database) string { return database.Name }), func(plan *Plan, i int) error {
+		return m.planDatabase(ctx, plan, databases[i])
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	// Grant permissions
-	for _, user := range users {
-		if err := m.GrantPermissions(user); err != nil {
-			return err
+	err = plan.parallel(resourceNames("user", users, func(user User) string { return user.Name }), func(plan *Plan, i int) error {
+		return m.planPermissions(ctx, plan, users[i])
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Remove anything that isn't in the config
+	if settings.Prune {
+		if err := m.planPrune(ctx, plan, databases, users, settings.PruneExclude); err != nil {
+			return nil, err
 		}
 	}
 
-	return nil
+	return plan, plan.err()
+}
+
+// Apply runs the changes in the plan in order and returns a report of what was done. If a change fails the
+// remaining cleanup changes are still run so temporary role memberships aren't left behind.
+func (m *postgresManager) Apply(plan *Plan) (*Report, error) {
+	return m.ApplyContext(context.Background(), plan)
+}
+
+// ApplyContext is like Apply but uses the provided context for all queries. The server-wide lock taken by
+// Manage is held while the plan is applied.
+func (m *postgresManager) ApplyContext(ctx context.Context, plan *Plan) (*Report, error) {
+	return applyLocked(ctx, m.lock, plan, func() (*Report, error) {
+		return m.apply(ctx, plan)
+	})
+}
+
+// apply runs the changes in the plan, the caller must hold the server-wide lock.
+func (m *postgresManager) apply(ctx context.Context, plan *Plan) (*Report, error) {
+	return ApplyPlan(ctx, plan, func(ctx context.Context, change Change) error {
+		m.Logger().Info("Applying change", "action", change.Action, "resource", change.Resource, "database", change.Database, "sql", change.displaySQL())
+		return m.applyChange(ctx, change)
+	})
+}
+
+// applyChange runs a single change, using a connection to the change's database if one is set.
+func (m *postgresManager) applyChange(ctx context.Context, change Change) error {
+	if change.Database == "" {
+		// A cached connection to a database would stop it being dropped, or be broken by terminating the
+		// connections to it, so it's closed first
+		if name, ok := strings.CutPrefix(change.Resource, "database/"); ok {
+			if err := m.closeDatabase(name); err != nil {
+				return err
+			}
+		}
+
+		_, err := m.db.ExecContext(ctx, change.SQL)
+		return err
+	}
+
+	db, err := m.database(ctx, change.Database)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.db.ExecContext(ctx, change.SQL)
+	return err
 }
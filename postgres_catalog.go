@@ -0,0 +1,493 @@
+package dbmanager
+
+import (
+	"context"
+	"database/sql"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// postgresAllPrivileges maps each type of object to the privileges granted by ALL.
+var postgresAllPrivileges = map[string][]string{
+	"parameter": {"SET", "ALTER SYSTEM"},
+	"database":  {"CREATE", "CONNECT", "TEMPORARY"},
+	"schema":    {"CREATE", "USAGE"},
+	"table":     {"SELECT", "INSERT", "UPDATE", "DELETE", "TRUNCATE", "REFERENCES", "TRIGGER", "MAINTAIN"},
+	"sequence":  {"USAGE", "SELECT", "UPDATE"},
+	"column":    {"SELECT", "INSERT", "UPDATE", "REFERENCES"},
+	"function":  {"EXECUTE"},
+	"procedure": {"EXECUTE"},
+}
+
+// postgresObject identifies an object privileges can be granted on. Default privileges use the kind
+// "default:" followed by the object type stored in pg_default_acl, with the role they apply to as the name.
+// Columns are named by their table.
+type postgresObject struct {
+	kind     string
+	database string
+	schema   string
+	name     string
+	column   string
+}
+
+// postgresACLItem is a single entry in an access control list, PUBLIC has an empty grantee.
+type postgresACLItem struct {
+	grantee   string
+	privilege string
+	grantable bool
+}
+
+// postgresMembership is a role a user or role is a member of.
+type postgresMembership struct {
+	role    string
+	inherit bool
+}
+
+// postgresCatalog is an in-memory view of the roles, role memberships and access control lists on the
+// server, so planning doesn't need a query for each check. Roles, databases and their ACLs are loaded the
+// first time they're needed, parameter ACLs and the ACLs of the objects in a database are loaded
+// separately the first time they're needed. The view isn't updated when changes are applied.
+type postgresCatalog struct {
+	manager *postgresManager
+
+	mu         sync.Mutex
+	loaded     bool
+	version    int
+	users      map[string]User
+	members    map[string][]postgresMembership
+	owners     map[string]string
+	privileges map[postgresObject][]postgresACLItem
+
+	// parameters is set once the parameter ACLs are loaded and loadedDatabases records the databases whose
+	// object ACLs are loaded. Databases are loaded without holding mu, so they can be loaded in parallel,
+	// databaseLocks stops the same database being loaded twice.
+	parameters      bool
+	loadedDatabases map[string]bool
+	databaseLocks   map[string]*sync.Mutex
+}
+
+// newPostgresCatalog returns an empty catalog that's loaded using the manager's connections.
+func newPostgresCatalog(m *postgresManager) *postgresCatalog {
+	return &postgresCatalog{
+		manager:         m,
+		privileges:      map[postgresObject][]postgresACLItem{},
+		loadedDatabases: map[string]bool{},
+		databaseLocks:   map[string]*sync.Mutex{},
+	}
+}
+
+// catalog returns the catalog used while planning, each plan has its own.
+func (m *postgresManager) catalog(plan *Plan) *postgresCatalog {
+	if catalog, ok := plan.catalog.(*postgresCatalog); ok {
+		return catalog
+	}
+
+	catalog := newPostgresCatalog(m)
+	plan.catalog = catalog
+	return catalog
+}
+
+// load loads the roles, role memberships, databases and database ACLs if they haven't been already. The
+// caller must hold c.mu.
+func (c *postgresCatalog) load(ctx context.Context) error {
+	if c.loaded {
+		return nil
+	}
+
+	db := c.manager.db
+	c.users = map[string]User{}
+	c.members = map[string][]postgresMembership{}
+	c.owners = map[string]string{}
+
+	if err := db.QueryRowContext(ctx, "SELECT current_setting('server_version_num')::int").Scan(&c.version); err != nil {
+		return err
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT "+postgresUserColumns+" FROM pg_roles")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return err
+		}
+		c.users[user.Name] = user
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// Memberships are only inherited if the member has INHERIT, from PostgreSQL 16 this is set on each
+	// membership instead so it's read from the row as JSON to support both
+	rows, err = db.QueryContext(ctx, `SELECT u.rolname, r.rolname, COALESCE((to_jsonb(m) ->> 'inherit_option')::boolean, u.rolinherit)
+		FROM pg_auth_members m JOIN pg_roles r ON r.oid = m.roleid JOIN pg_roles u ON u.oid = m.member`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var member string
+		var membership postgresMembership
+		if err := rows.Scan(&member, &membership.role, &membership.inherit); err != nil {
+			return err
+		}
+		c.members[member] = append(c.members[member], membership)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = db.QueryContext(ctx, "SELECT datname, pg_catalog.pg_get_userbyid(datdba) FROM pg_catalog.pg_database")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, owner string
+		if err := rows.Scan(&name, &owner); err != nil {
+			return err
+		}
+		c.owners[name] = owner
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// ACLs that have never been changed are NULL, acldefault returns the privileges they imply
+	acl, err := loadACL(ctx, db, `SELECT 'database', '', d.datname, '', COALESCE(r.rolname, ''), a.privilege_type, a.is_grantable
+		FROM pg_catalog.pg_database d
+		CROSS JOIN LATERAL aclexplode(COALESCE(d.datacl, acldefault('d', d.datdba))) a
+		LEFT JOIN pg_catalog.pg_roles r ON r.oid = a.grantee`, "")
+	if err != nil {
+		return err
+	}
+	maps.Copy(c.privileges, acl)
+
+	c.loaded = true
+	return nil
+}
+
+// loadACL returns the ACL items returned by query for each object. The query returns the kind, schema, name
+// and column of each object followed by the grantee, privilege and whether it can be granted.
+func loadACL(ctx context.Context, db *sql.DB, query, database string) (map[postgresObject][]postgresACLItem, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	acl := map[postgresObject][]postgresACLItem{}
+	for rows.Next() {
+		object := postgresObject{database: database}
+		var item postgresACLItem
+		if err := rows.Scan(&object.kind, &object.schema, &object.name, &object.column, &item.grantee, &item.privilege, &item.grantable); err != nil {
+			return nil, err
+		}
+		acl[object] = append(acl[object], item)
+	}
+
+	return acl, rows.Err()
+}
+
+// loadParameters loads the parameter ACLs if they haven't been already. The caller must hold c.mu.
+func (c *postgresCatalog) loadParameters(ctx context.Context) error {
+	if c.parameters {
+		return nil
+	}
+
+	// Parameter names are stored in lower case
+	acl, err := loadACL(ctx, c.manager.db, `SELECT 'parameter', '', p.parname, '', COALESCE(r.rolname, ''), a.privilege_type, a.is_grantable
+		FROM pg_catalog.pg_parameter_acl p
+		CROSS JOIN LATERAL aclexplode(p.paracl) a
+		LEFT JOIN pg_catalog.pg_roles r ON r.oid = a.grantee`, "")
+	if err != nil {
+		return err
+	}
+	maps.Copy(c.privileges, acl)
+
+	c.parameters = true
+	return nil
+}
+
+// loadDatabase loads the ACLs of the schemas, tables, columns, sequences, routines and default privileges
+// in a database if they haven't been already. The caller must not hold c.mu.
+func (c *postgresCatalog) loadDatabase(ctx context.Context, database string) error {
+	c.mu.Lock()
+	lock, ok := c.databaseLocks[database]
+	if !ok {
+		lock = &sync.Mutex{}
+		c.databaseLocks[database] = lock
+	}
+	c.mu.Unlock()
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	c.mu.Lock()
+	loaded := c.loadedDatabases[database]
+	c.mu.Unlock()
+	if loaded {
+		return nil
+	}
+
+	db, err := c.manager.database(ctx, database)
+	if err != nil {
+		return err
+	}
+
+	acl, err := loadACL(ctx, db.db, `SELECT 'schema', '', n.nspname, '', COALESCE(r.rolname, ''), a.privilege_type, a.is_grantable
+		FROM pg_catalog.pg_namespace n
+		CROSS JOIN LATERAL aclexplode(COALESCE(n.nspacl, acldefault('n', n.nspowner))) a
+		LEFT JOIN pg_catalog.pg_roles r ON r.oid = a.grantee
+		WHERE n.nspname NOT LIKE 'pg\_%' AND n.nspname <> 'information_schema'
+		UNION ALL
+		SELECT CASE c.relkind WHEN 'S' THEN 'sequence' ELSE 'table' END, n.nspname, c.relname, '', COALESCE(r.rolname, ''), a.privilege_type, a.is_grantable
+		FROM pg_catalog.pg_class c
+		JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+		CROSS JOIN LATERAL aclexplode(COALESCE(c.relacl, acldefault((CASE c.relkind WHEN 'S' THEN 's' ELSE 'r' END)::"char", c.relowner))) a
+		LEFT JOIN pg_catalog.pg_roles r ON r.oid = a.grantee
+		WHERE c.relkind IN ('r', 'v', 'm', 'p', 'f', 'S') AND n.nspname NOT LIKE 'pg\_%' AND n.nspname <> 'information_schema'
+		UNION ALL
+		SELECT 'column', n.nspname, c.relname, a.attname, COALESCE(r.rolname, ''), x.privilege_type, x.is_grantable
+		FROM pg_catalog.pg_attribute a
+		JOIN pg_catalog.pg_class c ON c.oid = a.attrelid
+		JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+		CROSS JOIN LATERAL aclexplode(a.attacl) x
+		LEFT JOIN pg_catalog.pg_roles r ON r.oid = x.grantee
+		WHERE a.attnum > 0 AND NOT a.attisdropped AND n.nspname NOT LIKE 'pg\_%' AND n.nspname <> 'information_schema'
+		UNION ALL
+		SELECT CASE p.prokind WHEN 'p' THEN 'procedure' ELSE 'function' END, n.nspname, p.proname, '', COALESCE(r.rolname, ''), a.privilege_type, a.is_grantable
+		FROM pg_catalog.pg_proc p
+		JOIN pg_catalog.pg_namespace n ON n.oid = p.pronamespace
+		CROSS JOIN LATERAL aclexplode(COALESCE(p.proacl, acldefault('f', p.proowner))) a
+		LEFT JOIN pg_catalog.pg_roles r ON r.oid = a.grantee
+		WHERE n.nspname NOT LIKE 'pg\_%' AND n.nspname <> 'information_schema'
+		UNION ALL
+		SELECT 'default:' || d.defaclobjtype, n.nspname, pg_catalog.pg_get_userbyid(d.defaclrole), '', COALESCE(r.rolname, ''), a.privilege_type, a.is_grantable
+		FROM pg_catalog.pg_default_acl d
+		JOIN pg_catalog.pg_namespace n ON n.oid = d.defaclnamespace
+		CROSS JOIN LATERAL aclexplode(d.defaclacl) a
+		LEFT JOIN pg_catalog.pg_roles r ON r.oid = a.grantee`, database)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	maps.Copy(c.privileges, acl)
+	c.loadedDatabases[database] = true
+	return nil
+}
+
+// userExists checks if the specified user or role exists.
+func (c *postgresCatalog) userExists(ctx context.Context, name string) (bool, error) {
+	_, ok, err := c.user(ctx, name)
+	return ok, err
+}
+
+// user returns the specified user with its options, and false if it doesn't exist.
+func (c *postgresCatalog) user(ctx context.Context, name string) (User, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.load(ctx); err != nil {
+		return User{}, false, err
+	}
+	user, ok := c.users[name]
+	return user, ok, nil
+}
+
+// roles returns the roles the specified user is a member of.
+func (c *postgresCatalog) roles(ctx context.Context, username string) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.load(ctx); err != nil {
+		return nil, err
+	}
+
+	var roles []string
+	for _, membership := range c.members[username] {
+		// From PostgreSQL 16 a role can be granted more than once by different grantors
+		if !slices.Contains(roles, membership.role) {
+			roles = append(roles, membership.role)
+		}
+	}
+	return roles, nil
+}
+
+// hasRole checks if the specified user is a member of the specified role.
+func (c *postgresCatalog) hasRole(ctx context.Context, username, role string) (bool, error) {
+	if username == role {
+		return true, nil
+	}
+
+	roles, err := c.roles(ctx, username)
+	if err != nil {
+		return false, err
+	}
+	return slices.Contains(roles, role), nil
+}
+
+// databaseOwner returns the owner of the specified database, and false if it doesn't exist.
+func (c *postgresCatalog) databaseOwner(ctx context.Context, name string) (string, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.load(ctx); err != nil {
+		return "", false, err
+	}
+	owner, ok := c.owners[name]
+	return owner, ok, nil
+}
+
+// databaseExists checks if the specified database exists.
+func (c *postgresCatalog) databaseExists(ctx context.Context, name string) (bool, error) {
+	_, ok, err := c.databaseOwner(ctx, name)
+	return ok, err
+}
+
+// hasGrant checks if a resolved grant has already been granted, on each of its columns if it has any.
+func (c *postgresCatalog) hasGrant(ctx context.Context, grant postgresGrant) (bool, error) {
+	if len(grant.columns) == 0 {
+		return c.hasPrivileges(ctx, grant.username, grant.object, grant.privileges, grant.withGrant)
+	}
+
+	for _, column := range grant.columns {
+		object := grant.object
+		object.kind, object.column = "column", column
+		if has, err := c.hasPrivileges(ctx, grant.username, object, grant.privileges, grant.withGrant); err != nil || !has {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// hasPrivileges checks if the specified user has all of the privileges on an object, either directly,
+// through the roles it inherits from or through PUBLIC, and with the grant option if withGrant is set.
+// Superusers have every privilege.
+func (c *postgresCatalog) hasPrivileges(ctx context.Context, username string, object postgresObject, privileges []string, withGrant bool) (bool, error) {
+	switch object.kind {
+	case "schema", "table", "column", "sequence", "function", "procedure":
+		if err := c.loadDatabase(ctx, object.database); err != nil {
+			return false, err
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.load(ctx); err != nil {
+		return false, err
+	}
+	if object.kind == "parameter" {
+		if err := c.loadParameters(ctx); err != nil {
+			return false, err
+		}
+		object.name = strings.ToLower(object.name)
+	}
+
+	if user := c.users[username]; user.Options.Superuser != nil && *user.Options.Superuser {
+		return true, nil
+	}
+
+	if len(privileges) == 1 && strings.ToUpper(privileges[0]) == "ALL" {
+		privileges = c.allPrivileges(postgresAllPrivileges[object.kind])
+	}
+
+	// Database owners are implicitly members of pg_database_owner in their database
+	grantees := c.inheritedRoles(username)
+	if owner, ok := c.owners[object.database]; ok && slices.Contains(grantees, owner) {
+		grantees = append(grantees, "pg_database_owner")
+	}
+
+	// Privileges on a table apply to every column
+	items := c.privileges[object]
+	if object.kind == "column" {
+		items = slices.Concat(items, c.privileges[postgresObject{kind: "table", database: object.database, schema: object.schema, name: object.name}])
+	}
+
+	for _, privilege := range privileges {
+		privilege = strings.ToUpper(privilege)
+		if privilege == "TEMP" {
+			privilege = "TEMPORARY"
+		}
+
+		granted := slices.ContainsFunc(items, func(item postgresACLItem) bool {
+			return item.privilege == privilege && (item.grantee == "" || slices.Contains(grantees, item.grantee)) && (item.grantable || !withGrant)
+		})
+		if !granted {
+			return false, nil // If any privilege is not granted, return false
+		}
+	}
+
+	return true, nil // All privileges are granted
+}
+
+// allPrivileges returns the privileges granted by ALL on the server, MAINTAIN was added to the privileges
+// on tables in PostgreSQL 17. The caller must hold c.mu.
+func (c *postgresCatalog) allPrivileges(privileges []string) []string {
+	if c.version >= 170000 {
+		return privileges
+	}
+	return slices.DeleteFunc(slices.Clone(privileges), func(privilege string) bool { return privilege == "MAINTAIN" })
+}
+
+// inheritedRoles returns the user and every role whose privileges it inherits. The caller must hold c.mu.
+func (c *postgresCatalog) inheritedRoles(username string) []string {
+	roles := []string{username}
+	for i := 0; i < len(roles); i++ {
+		for _, membership := range c.members[roles[i]] {
+			if membership.inherit && !slices.Contains(roles, membership.role) {
+				roles = append(roles, membership.role)
+			}
+		}
+	}
+	return roles
+}
+
+// hasDefaultPrivilege checks if the specified default privileges are set in a database. Default privileges
+// have to be granted directly to the role they're for.
+func (c *postgresCatalog) hasDefaultPrivilege(ctx context.Context, database string, privilege DefaultPrivilege) (bool, error) {
+	on, _, err := defaultPrivilegeObject(privilege.On)
+	if err != nil {
+		return false, err
+	}
+
+	if err := c.loadDatabase(ctx, database); err != nil {
+		return false, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.load(ctx); err != nil {
+		return false, err
+	}
+
+	role := privilege.Role
+	if role == "" {
+		role = c.manager.connection.Username
+	}
+
+	privileges := privilege.Grant
+	if len(privileges) == 1 && strings.ToUpper(privileges[0]) == "ALL" {
+		privileges = c.allPrivileges(defaultPrivilegeAll[on])
+	}
+
+	items := c.privileges[postgresObject{kind: "default:" + defaultPrivilegeObjectTypes[on], database: database, schema: privilege.Schema, name: role}]
+	for _, p := range privileges {
+		granted := slices.ContainsFunc(items, func(item postgresACLItem) bool {
+			return item.grantee == privilege.To && item.privilege == strings.ToUpper(p) && (item.grantable || !privilege.WithGrant)
+		})
+		if !granted {
+			return false, nil // If any privilege is not granted, return false
+		}
+	}
+
+	return true, nil // All privileges are granted
+}
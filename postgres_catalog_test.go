@@ -0,0 +1,119 @@
+package dbmanager
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPostgresCatalog_HasPrivileges(t *testing.T) {
+	catalog := &postgresCatalog{
+		loaded: true,
+		users: map[string]User{
+			"admin":  {Name: "admin", Options: UserOptions{Superuser: Bool(true)}},
+			"myuser": {Name: "myuser", Options: UserOptions{Superuser: Bool(false)}},
+		},
+		members: map[string][]postgresMembership{
+			"myuser":   {{role: "readers", inherit: true}, {role: "writers", inherit: false}},
+			"readers":  {{role: "everyone", inherit: true}},
+			"everyone": {{role: "readers", inherit: true}},
+		},
+		owners: map[string]string{"mydb": "owner"},
+		privileges: map[postgresObject][]postgresACLItem{
+			{kind: "database", name: "mydb"}: {
+				{grantee: "", privilege: "CONNECT"},
+				{grantee: "everyone", privilege: "TEMPORARY"},
+				{grantee: "writers", privilege: "CREATE"},
+			},
+		},
+	}
+	object := postgresObject{kind: "database", name: "mydb"}
+	ctx := context.Background()
+
+	tests := []struct {
+		name       string
+		username   string
+		privileges []string
+		expected   bool
+	}{
+		{"granted to PUBLIC", "myuser", []string{"connect"}, true},
+		{"inherited through nested roles", "myuser", []string{"CONNECT", "TEMP"}, true},
+		{"role without inherit", "myuser", []string{"CREATE"}, false},
+		{"ALL needs every privilege", "myuser", []string{"ALL"}, false},
+		{"superuser", "admin", []string{"ALL"}, true},
+		{"unknown user", "nobody", []string{"CONNECT"}, true},
+		{"unknown user without PUBLIC", "nobody", []string{"TEMPORARY"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			has, err := catalog.hasPrivileges(ctx, tt.username, object, tt.privileges, false)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, has)
+		})
+	}
+
+	roles, err := catalog.roles(ctx, "myuser")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"readers", "writers"}, roles)
+
+	// The grant option is only counted when it was granted
+	catalog.privileges[object] = append(catalog.privileges[object], postgresACLItem{grantee: "readers", privilege: "CONNECT", grantable: true})
+	has, err := catalog.hasPrivileges(ctx, "myuser", object, []string{"TEMPORARY"}, true)
+	assert.NoError(t, err)
+	assert.False(t, has, "TEMPORARY was granted without the grant option")
+	has, err = catalog.hasPrivileges(ctx, "myuser", object, []string{"CONNECT"}, true)
+	assert.NoError(t, err)
+	assert.True(t, has, "CONNECT was granted with the grant option through a role")
+
+	// ALL on a table includes MAINTAIN from PostgreSQL 17
+	table := postgresObject{kind: "table", database: "mydb", schema: "public", name: "mytable"}
+	catalog.loadedDatabases = map[string]bool{"mydb": true}
+	catalog.databaseLocks = map[string]*sync.Mutex{}
+	for _, privilege := range []string{"SELECT", "INSERT", "UPDATE", "DELETE", "TRUNCATE", "REFERENCES", "TRIGGER"} {
+		catalog.privileges[table] = append(catalog.privileges[table], postgresACLItem{grantee: "myuser", privilege: privilege})
+	}
+	has, err = catalog.hasPrivileges(ctx, "myuser", table, []string{"ALL"}, false)
+	assert.NoError(t, err)
+	assert.True(t, has, "ALL shouldn't include MAINTAIN before PostgreSQL 17")
+
+	catalog.version = 170000
+	has, err = catalog.hasPrivileges(ctx, "myuser", table, []string{"ALL"}, false)
+	assert.NoError(t, err)
+	assert.False(t, has, "ALL should include MAINTAIN from PostgreSQL 17")
+}
+
+func TestPostgresCatalog_HasGrantColumns(t *testing.T) {
+	table := postgresObject{kind: "table", database: "mydb", schema: "public", name: "mytable"}
+	column := table
+	column.kind, column.column = "column", "a"
+	catalog := &postgresCatalog{
+		loaded:          true,
+		users:           map[string]User{"myuser": {Name: "myuser"}},
+		loadedDatabases: map[string]bool{"mydb": true},
+		databaseLocks:   map[string]*sync.Mutex{},
+		privileges: map[postgresObject][]postgresACLItem{
+			table:  {{grantee: "myuser", privilege: "SELECT"}},
+			column: {{grantee: "myuser", privilege: "UPDATE"}},
+		},
+	}
+	ctx := context.Background()
+	grant := func(privileges ...string) postgresGrant {
+		return postgresGrant{username: "myuser", object: table, columns: []string{"a", "b"}, privileges: privileges}
+	}
+
+	// Privileges on the table apply to every column, column privileges only to their own column
+	has, err := catalog.hasGrant(ctx, grant("SELECT"))
+	assert.NoError(t, err)
+	assert.True(t, has)
+
+	has, err = catalog.hasGrant(ctx, grant("UPDATE"))
+	assert.NoError(t, err)
+	assert.False(t, has)
+
+	has, err = catalog.hasGrant(ctx, postgresGrant{username: "myuser", object: table, columns: []string{"a"}, privileges: []string{"UPDATE"}})
+	assert.NoError(t, err)
+	assert.True(t, has)
+}
@@ -1,210 +1,301 @@
 package dbmanager
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
-	"strings"
 )
 
 // CreateDatabase creates and updates a database. It will create the database if it doesn't already exist
 // and apply the default privileges if provided.
 func (m *postgresManager) CreateDatabase(database Database) error {
-	// Create the database if it doesn't already exist
-	if err := m.createDatabase(database); err != nil {
+	return m.CreateDatabaseContext(context.Background(), database)
+}
+
+// CreateDatabaseContext is like CreateDatabase but uses the provided context for all queries.
+func (m *postgresManager) CreateDatabaseContext(ctx context.Context, database Database) error {
+	if err := database.Validate(m.engine); err != nil {
 		return err
 	}
 
-	// Update the database
-	if err := m.updateDatabase(database); err != nil {
+	plan := NewPlan()
+	if err := m.planDatabase(ctx, plan, database); err != nil {
 		return err
 	}
 
+	_, err := m.ApplyContext(ctx, plan)
+	return err
+}
+
+// planDatabase adds the changes needed to create or update a database to the plan.
+func (m *postgresManager) planDatabase(ctx context.Context, plan *Plan, database Database) error {
+	// Create the database if it doesn't already exist, otherwise update it
+	exists, err := m.catalog(plan).databaseExists(ctx, database.Name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if err := m.createDatabase(ctx, plan, database); err != nil {
+			return err
+		}
+	} else {
+		m.Logger().Debug("Database already exists, skipping", "database", database.Name)
+		plan.unchanged(resource("database", database.Name), "database already exists")
+		if err := m.updateDatabase(ctx, plan, database); err != nil {
+			return err
+		}
+	}
+
 	// Apply default privileges
-	if err := m.alterDefaultPrivileges(database.Name, database.DefaultPrivileges); err != nil {
+	if err := m.alterDefaultPrivileges(ctx, plan, database.Name, database.DefaultPrivileges); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-// createDatabase creates a new database.
-func (m *postgresManager) createDatabase(database Database) error {
-	if exists, err := m.databaseExists(database.Name); err != nil {
-		return err
-	} else if exists {
-		log.Printf("Database %s already exists, skipping\n", database.Name)
-		return nil
+// createDatabase adds the changes that create a new database to the plan.
+func (m *postgresManager) createDatabase(ctx context.Context, plan *Plan, database Database) error {
+	query := fmt.Sprintf("CREATE DATABASE %s", QuoteIdentifier(database.Name))
+	change := Change{
+		Resource: resource("database", database.Name),
+		Action:   ActionCreate,
+		Reason:   "database does not exist",
 	}
 
-	query := fmt.Sprintf("CREATE DATABASE %s", database.Name)
-
 	// Add owner if provided, if the owner is not provided then the current user will be the owner. If an
 	// owner if provided we need to validate the user exists before creating the database.
-	if database.Owner != "" {
-		if exists, err := m.userExists(database.Owner); err != nil {
-			return err
-		} else if !exists {
-			return fmt.Errorf("owner %s does not exist", database.Owner)
-		}
+	if database.Owner == "" {
+		change.SQL = query
+		plan.Add(change)
+		plan.databases[database.Name] = true
+		return nil
+	}
 
-		// RDS wants the user creating the database to be a member of the owner role, so we need to add the
-		// our current user to the owner role before creating the database and then remove it after.
-		if err := m.addRole(m.connection.Username, database.Owner); err != nil {
+	if !plan.users[database.Owner] {
+		if exists, err := m.catalog(plan).userExists(ctx, database.Owner); err != nil {
 			return err
+		} else if !exists {
+			return fmt.Errorf("%w: %s", ErrOwnerNotFound, database.Owner)
 		}
-		defer func() {
-			if err := m.removeRole(m.connection.Username, database.Owner); err != nil {
-				log.Printf("Error removing user %s from role %s: %v\n", m.connection.Username, database.Owner, err)
-			}
-		}()
-
-		query += fmt.Sprintf(" OWNER %s", QuoteIdentifier(database.Owner))
 	}
 
-	if _, err := m.db.Exec(query); err != nil {
+	change.SQL = query + fmt.Sprintf(" OWNER %s", QuoteIdentifier(database.Owner))
+
+	// RDS wants the user creating the database to be a member of the owner role, so we need to add the
+	// our current user to the owner role before creating the database and then remove it after.
+	if err := m.asMemberOf(ctx, plan, database.Owner, func() { plan.Add(change) }); err != nil {
 		return err
 	}
-
-	log.Printf("Created database: %s\n", database.Name)
+	plan.databases[database.Name] = true
 
 	return nil
 }
 
 // databaseExists checks if the specified database exists.
-func (m *postgresManager) databaseExists(name string) (bool, error) {
+func (m *postgresManager) databaseExists(ctx context.Context, name string) (bool, error) {
 	var exists bool
 	query := "SELECT 1 FROM pg_database WHERE datname = $1"
-	err := m.db.QueryRow(query, name).Scan(&exists)
+	err := m.db.QueryRowContext(ctx, query, name).Scan(&exists)
 	if err != nil && err != sql.ErrNoRows {
 		return false, err
 	}
 	return exists, nil
 }
 
-// updateDatabase updates a database.
-func (m *postgresManager) updateDatabase(database Database) error {
+// updateDatabase adds the changes needed to update an existing database to the plan.
+func (m *postgresManager) updateDatabase(ctx context.Context, plan *Plan, database Database) error {
 	// Update owner if provided
-	if err := m.updateDatabaseOwner(database); err != nil {
-		return err
-	}
-
-	// Update default privileges if provided
-	if err := m.alterDefaultPrivileges(database.Name, database.DefaultPrivileges); err != nil {
+	if err := m.updateDatabaseOwner(ctx, plan, database); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-// updateDatabaseOwner updates the owner of a database.
-func (m *postgresManager) updateDatabaseOwner(database Database) error {
+// updateDatabaseOwner adds the change that updates the owner of a database to the plan.
+func (m *postgresManager) updateDatabaseOwner(ctx context.Context, plan *Plan, database Database) error {
 	// If an owner isn't set we won't try to update it
 	if database.Owner == "" {
 		return nil
 	}
 
-	currentOwner, err := m.getDatabaseOwner(database.Name)
+	currentOwner, _, err := m.catalog(plan).databaseOwner(ctx, database.Name)
 	if err != nil {
 		return err
 	}
 
-	var removeRoleErr error
+	if currentOwner == database.Owner {
+		return nil
+	}
 
-	if currentOwner != database.Owner {
-		// RDS wants the user creating the database to be a member of the owner role, so we need to add the
-		// our current user to the owner role before creating the database and then remove it after.
-		if err := m.addRole(m.connection.Username, database.Owner); err != nil {
+	if !plan.users[database.Owner] {
+		if exists, err := m.catalog(plan).userExists(ctx, database.Owner); err != nil {
 			return err
+		} else if !exists {
+			return fmt.Errorf("%w: %s", ErrOwnerNotFound, database.Owner)
 		}
-		defer func() {
-			if removeRoleErr = m.removeRole(m.connection.Username, database.Owner); err != nil {
-				log.Printf("Error removing user %s from role %s: %v\n", m.connection.Username, database.Owner, err)
-			}
-		}()
+	}
 
-		query := fmt.Sprintf("ALTER DATABASE %s OWNER TO %s", database.Name, QuoteIdentifier(database.Owner))
-		if _, err := m.db.Exec(query); err != nil {
-			return err
-		}
-		log.Printf("Updated owner of database %s to %s\n", database.Name, database.Owner)
+	change := Change{
+		Resource: resource("database", database.Name),
+		Action:   ActionUpdate,
+		SQL:      fmt.Sprintf("ALTER DATABASE %s OWNER TO %s", QuoteIdentifier(database.Name), QuoteIdentifier(database.Owner)),
+		Reason:   fmt.Sprintf("owner is %s, not %s", currentOwner, database.Owner),
 	}
 
-	return removeRoleErr
+	// RDS wants the user creating the database to be a member of the owner role, so we need to add the
+	// our current user to the owner role before creating the database and then remove it after.
+	return m.asMemberOf(ctx, plan, database.Owner, func() { plan.Add(change) })
 }
 
 // databaseOwner returns the owner of a database.
-func (m *postgresManager) getDatabaseOwner(database string) (string, error) {
+func (m *postgresManager) getDatabaseOwner(ctx context.Context, database string) (string, error) {
 	var owner string
-	query := fmt.Sprintf("SELECT pg_catalog.pg_get_userbyid(d.datdba) FROM pg_catalog.pg_database d WHERE d.datname = '%s'", database)
-	if err := m.db.QueryRow(query).Scan(&owner); err != nil {
+	query := "SELECT pg_catalog.pg_get_userbyid(d.datdba) FROM pg_catalog.pg_database d WHERE d.datname = $1"
+	if err := m.db.QueryRowContext(ctx, query, database).Scan(&owner); err != nil {
 		return "", err
 	}
 	return owner, nil
 }
 
-// alterDefaultPrivileges alters the default privileges in a database for a user or role.
+// alterDefaultPrivileges adds the changes that alter the default privileges in a database for a user or
+// role to the plan.
 //
-// This needs to be done in a separate connection to the database where the permissions are being granted
-// and after the users or roles mentioned in the "To" field have been created or it will return an error.
-func (m *postgresManager) alterDefaultPrivileges(database string, privileges []DefaultPrivilege) error {
-	// Create new client using the database where permissions are being granted
-	db := &postgresManager{
-		databaseManager: databaseManager{
-			connection: Connection{
-				Host:     m.connection.Host,
-				Database: database,
-				Port:     m.connection.Port,
-				Username: m.connection.Username,
-				Password: m.connection.Password,
-				SSLMode:  m.connection.SSLMode,
-			},
-		},
-	}
-
-	// Connect to the database
-	if err := db.Connect(); err != nil {
-		return err
-	}
-	defer db.Disconnect()
+// These need to be run in a separate connection to the database where the permissions are being granted
+// and after the users or roles mentioned in the "To" field have been created or they will return an error.
+func (m *postgresManager) alterDefaultPrivileges(ctx context.Context, plan *Plan, database string, privileges []DefaultPrivilege) error {
+	for _, privilege := range privileges {
+		for _, role := range []string{privilege.Role, privilege.To} {
+			if err := m.requireUser(ctx, plan, role); err != nil {
+				return err
+			}
+		}
 
-	var removeRoleErr error
+		query, err := m.alterDefaultPrivilegeQuery(privilege)
+		if err != nil {
+			return err
+		}
 
-	for _, privilege := range privileges {
-		// RDS wants the user setting the default privilege to be a member of the role, so we need to add the
-		// our current user to the role before settings the default privilege the database and removing it after.
-		if privilege.Role != "" || privilege.Role != m.connection.Username {
-			if err := m.addRole(m.connection.Username, privilege.Role); err != nil {
-				log.Printf("Error adding user %s to role %s: %v\n", m.connection.Username, privilege.Role, err)
+		// Databases and roles created by the plan can't have the default privileges yet
+		target := resource("default_privilege", database, privilege.Schema, privilege.To)
+		if !plan.databases[database] && !plan.users[privilege.To] && !plan.users[privilege.Role] {
+			if exists, err := m.catalog(plan).hasDefaultPrivilege(ctx, database, privilege); err != nil {
+				return err
+			} else if exists {
+				m.Logger().Debug("Default privileges already set, skipping", "database", database, "schema", privilege.Schema, "to", privilege.To)
+				plan.unchanged(target, "default privileges already set")
+				continue
 			}
-			defer func() {
-				if removeRoleErr = m.removeRole(m.connection.Username, privilege.Role); removeRoleErr != nil {
-					log.Printf("Error removing user %s from role %s: %v\n", m.connection.Username, privilege.Role, removeRoleErr)
-				}
-			}()
 		}
 
-		query := m.alterDefaultPrivilegeQuery(database, privilege)
-		log.Printf("Altering default permissions in database %s: %s", database, query)
-		if _, err := db.db.Exec(query); err != nil {
-			return fmt.Errorf("error altering default privilege: %w", err)
+		change := Change{
+			Resource: target,
+			Action:   ActionGrant,
+			Database: database,
+			SQL:      query,
+			Reason:   "default privileges are not set",
 		}
-	}
 
-	log.Printf("Applied default privileges for database %s\n", database)
+		// RDS wants the user setting the default privilege to be a member of the role, so we need to add the
+		// our current user to the role before settings the default privilege the database and removing it after.
+		if privilege.Role == "" {
+			plan.Add(change)
+			continue
+		}
+		if err := m.asMemberOf(ctx, plan, privilege.Role, func() { plan.Add(change) }); err != nil {
+			return err
+		}
+	}
 
-	return removeRoleErr
+	return nil
 }
 
-// alterDefaultPrivilege alters the default privileges in a database for a user or role.
-func (m *postgresManager) alterDefaultPrivilegeQuery(database string, privilege DefaultPrivilege) string {
+// alterDefaultPrivilegeQuery returns the query that alters the default privileges in a database for a user
+// or role. The object type and privileges are checked against the allow-list as they can't be quoted.
+func (m *postgresManager) alterDefaultPrivilegeQuery(privilege DefaultPrivilege) (string, error) {
+	on, allowed, err := defaultPrivilegeObject(privilege.On)
+	if err != nil {
+		return "", err
+	}
+	privileges, err := privilegeList(privilege.Grant, allowed)
+	if err != nil {
+		return "", err
+	}
+
 	query := "ALTER DEFAULT PRIVILEGES"
 	if privilege.Role != "" {
 		query += fmt.Sprintf(" FOR ROLE %s", QuoteIdentifier(privilege.Role))
 	}
-	query += fmt.Sprintf(" IN SCHEMA %s GRANT %s ON %s TO %s", QuoteIdentifier(privilege.Schema), strings.Join(privilege.Grant, ", "), privilege.On, QuoteIdentifier(privilege.To))
+	query += fmt.Sprintf(" IN SCHEMA %s GRANT %s ON %s TO %s", QuoteIdentifier(privilege.Schema), privileges, on, QuoteIdentifier(privilege.To))
 	if privilege.WithGrant {
 		query += " WITH GRANT OPTION"
 	}
-	return query
+	return query, nil
+}
+
+// defaultPrivilegeObjectTypes maps the object types used in DefaultPrivilege.On to the object types
+// stored in pg_default_acl.
+var defaultPrivilegeObjectTypes = map[string]string{
+	"TABLES":    "r",
+	"SEQUENCES": "S",
+	"FUNCTIONS": "f",
+	"ROUTINES":  "f",
+	"TYPES":     "T",
+	"SCHEMAS":   "n",
+}
+
+// defaultPrivilegeAll maps the object types used in DefaultPrivilege.On to the privileges granted by ALL.
+var defaultPrivilegeAll = map[string][]string{
+	"TABLES":    {"SELECT", "INSERT", "UPDATE", "DELETE", "TRUNCATE", "REFERENCES", "TRIGGER", "MAINTAIN"},
+	"SEQUENCES": {"SELECT", "UPDATE", "USAGE"},
+	"FUNCTIONS": {"EXECUTE"},
+	"ROUTINES":  {"EXECUTE"},
+	"TYPES":     {"USAGE"},
+	"SCHEMAS":   {"USAGE", "CREATE"},
+}
+
+// DropDatabase drops a database if it exists.
+func (m *postgresManager) DropDatabase(name string, options ...DropOption) error {
+	return m.DropDatabaseContext(context.Background(), name, options...)
+}
+
+// DropDatabaseContext is like DropDatabase but uses the provided context for all queries.
+func (m *postgresManager) DropDatabaseContext(ctx context.Context, name string, options ...DropOption) error {
+	plan := NewPlan()
+	if err := m.planDropDatabase(ctx, plan, name, NewDropSettings(options...)); err != nil {
+		return err
+	}
+
+	_, err := m.ApplyContext(ctx, plan)
+	return err
+}
+
+// planDropDatabase adds the changes that drop a database to the plan.
+func (m *postgresManager) planDropDatabase(ctx context.Context, plan *Plan, name string, settings DropSettings) error {
+	if exists, err := m.databaseExists(ctx, name); err != nil {
+		return err
+	} else if !exists {
+		m.Logger().Debug("Database does not exist, skipping", "database", name)
+		return nil
+	}
+
+	// A database can't be dropped while there are connections to it
+	if settings.TerminateConnections {
+		plan.Add(Change{
+			Resource: resource("database", name),
+			Action:   ActionUpdate,
+			SQL:      fmt.Sprintf("SELECT pg_terminate_backend(pid) FROM pg_catalog.pg_stat_activity WHERE datname = %s AND pid <> pg_backend_pid()", QuoteLiteral(name)),
+			Reason:   "terminate connections before dropping database",
+		})
+	}
+
+	plan.Add(Change{
+		Resource: resource("database", name),
+		Action:   ActionDrop,
+		SQL:      fmt.Sprintf("DROP DATABASE %s", QuoteIdentifier(name)),
+		Reason:   "drop requested",
+	})
+
+	return nil
 }
@@ -0,0 +1,152 @@
+package dbmanager
+
+import (
+	"context"
+	"slices"
+)
+
+// Diff compares the databases and users with the server and returns a report of every difference found
+// without changing anything.
+func (m *postgresManager) Diff(databases []Database, users []User) (*DriftReport, error) {
+	return m.DiffContext(context.Background(), databases, users)
+}
+
+// DiffContext is like Diff but uses the provided context for all queries.
+func (m *postgresManager) DiffContext(ctx context.Context, databases []Database, users []User) (*DriftReport, error) {
+	if err := validate(m.engine, databases, users); err != nil {
+		return nil, err
+	}
+
+	report := newDriftReport()
+	catalog := newPostgresCatalog(m)
+
+	// Users that are on the server but not in the config, roles the config refers to aren't extra
+	existing, err := m.listUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range prunedUsers(existing, databases, users, postgresSystemUsers) {
+		report.extra(resource("user", name))
+	}
+
+	for _, user := range users {
+		if err := m.diffUser(ctx, catalog, report, user); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, database := range databases {
+		if err := m.diffDatabase(ctx, catalog, report, database); err != nil {
+			return nil, err
+		}
+	}
+
+	return report, nil
+}
+
+// diffUser adds any differences between a user and the server to the report.
+func (m *postgresManager) diffUser(ctx context.Context, catalog *postgresCatalog, report *DriftReport, user User) error {
+	realUser, exists, err := catalog.user(ctx, user.Name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		report.missing(resource("user", user.Name))
+		return nil
+	}
+
+	// Role attributes, options that aren't set aren't managed so can't drift
+	actual := postgresAttributes(realUser)
+	for i, attribute := range postgresAttributes(user) {
+		if attribute.value != nil && *attribute.value != *actual[i].value {
+			report.changed(resource("user", user.Name), attribute.name, *attribute.value, *actual[i].value)
+		}
+	}
+
+	// Role memberships
+	roles, err := catalog.roles(ctx, user.Name)
+	if err != nil {
+		return err
+	}
+	for _, role := range user.Roles {
+		if role != user.Name && !slices.Contains(roles, role) {
+			report.missing(resource("role", user.Name, role))
+		}
+	}
+	for _, role := range roles {
+		if !slices.Contains(user.Roles, role) {
+			report.extra(resource("role", user.Name, role))
+		}
+	}
+
+	// Grants
+	for _, grant := range user.Grants {
+		resolved, err := m.resolveGrant(user.Name, grant)
+		if err != nil {
+			return err
+		}
+
+		if exists, err := catalog.databaseExists(ctx, resolved.database); err != nil {
+			return err
+		} else if !exists {
+			report.missing(resource("grant", user.Name, resolved.target))
+			continue
+		}
+
+		if hasPermissions, err := catalog.hasGrant(ctx, resolved); err != nil {
+			return err
+		} else if !hasPermissions {
+			report.missing(resource("grant", user.Name, resolved.target))
+		}
+	}
+
+	return nil
+}
+
+// diffDatabase adds any differences between a database and the server to the report.
+func (m *postgresManager) diffDatabase(ctx context.Context, catalog *postgresCatalog, report *DriftReport, database Database) error {
+	owner, exists, err := catalog.databaseOwner(ctx, database.Name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		report.missing(resource("database", database.Name))
+		return nil
+	}
+
+	if database.Owner != "" && owner != database.Owner {
+		report.changed(resource("database", database.Name), "owner", database.Owner, owner)
+	}
+
+	for _, privilege := range database.DefaultPrivileges {
+		if hasPrivilege, err := catalog.hasDefaultPrivilege(ctx, database.Name, privilege); err != nil {
+			return err
+		} else if !hasPrivilege {
+			report.missing(resource("default_privilege", database.Name, privilege.Schema, privilege.To))
+		}
+	}
+
+	return nil
+}
+
+// listUsers returns the names of all users and roles on the server, excluding the built in "pg_" roles
+// and the user we're connected as.
+func (m *postgresManager) listUsers(ctx context.Context) ([]string, error) {
+	query := "SELECT rolname FROM pg_catalog.pg_roles WHERE rolname NOT LIKE 'pg\\_%' AND rolname <> $1 ORDER BY rolname"
+	rows, err := m.db.QueryContext(ctx, query, m.connection.Username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []string
+	for rows.Next() {
+		var user string
+		if err := rows.Scan(&user); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
@@ -0,0 +1,209 @@
+package dbmanager
+
+import (
+	"context"
+	"slices"
+)
+
+// defaultPrivilegeObjectNames maps the object types stored in pg_default_acl to the object types used in
+// DefaultPrivilege.On.
+var defaultPrivilegeObjectNames = map[string]string{
+	"r": "TABLES",
+	"S": "SEQUENCES",
+	"f": "FUNCTIONS",
+	"T": "TYPES",
+	"n": "SCHEMAS",
+}
+
+// Export reads the databases and users on the server and returns them as configuration that can be passed
+// to Manage. Passwords can't be read from the server so they are left empty, system users and databases
+// and the user we're connected as are not included.
+func (m *postgresManager) Export() ([]Database, []User, error) {
+	return m.ExportContext(context.Background())
+}
+
+// ExportContext is like Export but uses the provided context for all queries.
+func (m *postgresManager) ExportContext(ctx context.Context) ([]Database, []User, error) {
+	names, err := m.listDatabases(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	usernames, err := m.listUsers(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	usernames = slices.DeleteFunc(usernames, func(name string) bool { return matchesAny(name, postgresSystemUsers) })
+
+	// Users, their role memberships and the privileges that aren't tied to a single database
+	users := make([]User, 0, len(usernames))
+	privileges := map[string][]postgresPrivilege{}
+	for _, name := range usernames {
+		user, err := m.getUser(ctx, name)
+		if err != nil {
+			return nil, nil, err
+		}
+		if user.Roles, err = m.getRoles(ctx, name); err != nil {
+			return nil, nil, err
+		}
+		user.Options = exportedOptions(user.Options)
+		users = append(users, user)
+
+		databasePrivileges, err := m.getDatabasePrivileges(ctx, name)
+		if err != nil {
+			return nil, nil, err
+		}
+		parameterPrivileges, err := m.getParameterPrivileges(ctx, name)
+		if err != nil {
+			return nil, nil, err
+		}
+		privileges[name] = append(parameterPrivileges, databasePrivileges...)
+	}
+
+	// Databases, their default privileges and the privileges on the objects in them
+	var databases []Database
+	for _, name := range names {
+		db, err := m.database(ctx, name)
+		if err != nil {
+			return nil, nil, err
+		}
+		database, err := db.exportDatabase(ctx, privileges, usernames)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if !matchesAny(name, postgresSystemDatabases) {
+			databases = append(databases, database)
+		}
+	}
+
+	for i := range users {
+		users[i].Grants = privilegesToGrants(privileges[users[i].Name])
+	}
+
+	return databases, users, nil
+}
+
+// exportDatabase returns the current database with its owner and default privileges, and adds the object
+// privileges granted to each of the users in it to privileges.
+func (m *postgresManager) exportDatabase(ctx context.Context, privileges map[string][]postgresPrivilege, usernames []string) (Database, error) {
+	database := Database{Name: m.connection.Database}
+
+	owner, err := m.getDatabaseOwner(ctx, database.Name)
+	if err != nil {
+		return Database{}, err
+	}
+	database.Owner = owner
+
+	for _, name := range usernames {
+		objectPrivileges, err := m.getObjectPrivileges(ctx, name)
+		if err != nil {
+			return Database{}, err
+		}
+		privileges[name] = append(privileges[name], objectPrivileges...)
+	}
+
+	if database.DefaultPrivileges, err = m.getDefaultPrivileges(ctx); err != nil {
+		return Database{}, err
+	}
+
+	return database, nil
+}
+
+// getDefaultPrivileges returns the default privileges set for each schema in the current database. Global
+// default privileges, set without IN SCHEMA, can't be expressed as a DefaultPrivilege so are ignored.
+func (m *postgresManager) getDefaultPrivileges(ctx context.Context) ([]DefaultPrivilege, error) {
+	query := `SELECT pg_catalog.pg_get_userbyid(d.defaclrole), n.nspname, d.defaclobjtype,
+		pg_catalog.pg_get_userbyid(a.grantee), a.privilege_type, a.is_grantable
+		FROM pg_catalog.pg_default_acl d
+		JOIN pg_catalog.pg_namespace n ON n.oid = d.defaclnamespace
+		CROSS JOIN LATERAL aclexplode(d.defaclacl) a
+		WHERE a.grantee <> 0 AND a.grantee <> d.defaclrole
+		ORDER BY 1, 2, 3, 4, 6, 5`
+	rows, err := m.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var privileges []DefaultPrivilege
+	for rows.Next() {
+		var privilege DefaultPrivilege
+		var objectType, grant string
+		if err := rows.Scan(&privilege.Role, &privilege.Schema, &objectType, &privilege.To, &grant, &privilege.WithGrant); err != nil {
+			return nil, err
+		}
+		privilege.On = defaultPrivilegeObjectNames[objectType]
+
+		// Rows are ordered so privileges for the same role, schema, object type and grantee are together
+		if last := len(privileges) - 1; last >= 0 {
+			previous := &privileges[last]
+			if previous.Role == privilege.Role && previous.Schema == privilege.Schema && previous.On == privilege.On &&
+				previous.To == privilege.To && previous.WithGrant == privilege.WithGrant {
+				previous.Grant = append(previous.Grant, grant)
+				continue
+			}
+		}
+
+		privilege.Grant = []string{grant}
+		privileges = append(privileges, privilege)
+	}
+
+	return privileges, rows.Err()
+}
+
+// privilegesToGrants groups privileges on the same object into grants.
+func privilegesToGrants(privileges []postgresPrivilege) []Grant {
+	var grants []Grant
+	index := map[postgresPrivilege]int{}
+
+	for _, privilege := range privileges {
+		key := privilege
+		key.privilege = ""
+		if i, ok := index[key]; ok {
+			grants[i].Privileges = append(grants[i].Privileges, privilege.privilege)
+			continue
+		}
+
+		grant := Grant{Privileges: []string{privilege.privilege}, WithGrant: privilege.grantable}
+		switch privilege.kind {
+		case "parameter":
+			grant.Parameter = privilege.name
+		case "database":
+			grant.Database = privilege.database
+		case "schema":
+			grant.Database, grant.Schema = privilege.database, privilege.schema
+		case "table":
+			grant.Database, grant.Schema, grant.Table = privilege.database, privilege.schema, privilege.name
+		case "sequence":
+			grant.Database, grant.Schema, grant.Sequence = privilege.database, privilege.schema, privilege.name
+		}
+
+		index[key] = len(grants)
+		grants = append(grants, grant)
+	}
+
+	return grants
+}
+
+// exportedOptions returns the options that differ from the defaults a role is created with, so the exported
+// configuration stays short and creates the same role when applied. Passwords aren't exported so a role is
+// created without LOGIN unless login is set.
+func exportedOptions(options UserOptions) UserOptions {
+	changed := func(value *bool, defaultValue bool) *bool {
+		if value != nil && *value != defaultValue {
+			return value
+		}
+		return nil
+	}
+
+	return UserOptions{
+		Login:          changed(options.Login, false),
+		Superuser:      changed(options.Superuser, false),
+		CreateDatabase: changed(options.CreateDatabase, false),
+		CreateRole:     changed(options.CreateRole, false),
+		Inherit:        changed(options.Inherit, true),
+		Replication:    changed(options.Replication, false),
+		BypassRLS:      changed(options.BypassRLS, false),
+	}
+}
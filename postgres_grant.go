@@ -1,48 +1,70 @@
 package dbmanager
 
 import (
-	"database/sql"
+	"context"
 	"fmt"
-	"log"
 	"slices"
 	"strings"
 )
 
 // GrantPermissions grants permissions to a user based on the provided Grant options.
 func (m *postgresManager) GrantPermissions(user User) error {
-	// Check if the user exists
-	if exists, err := m.userExists(user.Name); err != nil {
+	return m.GrantPermissionsContext(context.Background(), user)
+}
+
+// GrantPermissionsContext is like GrantPermissions but uses the provided context for all queries.
+func (m *postgresManager) GrantPermissionsContext(ctx context.Context, user User) error {
+	if err := user.Validate(m.engine); err != nil {
 		return err
-	} else if !exists {
-		log.Printf("User %s does not exist, skipping\n", user.Name)
-		return nil
+	}
+
+	plan := NewPlan()
+	if err := m.planPermissions(ctx, plan, user); err != nil {
+		return err
+	}
+
+	_, err := m.ApplyContext(ctx, plan)
+	return err
+}
+
+// planPermissions adds the changes needed to grant permissions and roles to a user to the plan.
+func (m *postgresManager) planPermissions(ctx context.Context, plan *Plan, user User) error {
+	// Check if the user exists
+	if !plan.users[user.Name] {
+		if exists, err := m.catalog(plan).userExists(ctx, user.Name); err != nil {
+			return err
+		} else if !exists {
+			m.Logger().Debug("User does not exist, skipping", "user", user.Name)
+			plan.skipped(resource("user", user.Name), "user does not exist")
+			return nil
+		}
 	}
 
 	// Grant permissions
 	for _, grant := range user.Grants {
-		log.Printf("Processing grant: %v", grant)
+		m.Logger().Debug("Processing grant", "user", user.Name, "grant", grant)
 
-		if err := m.grantPermission(user.Name, grant); err != nil {
+		if err := m.grantPermission(ctx, plan, user.Name, grant); err != nil {
 			return fmt.Errorf("error granting permissions: %w", err)
 		}
 	}
 
 	// Add to roles
 	for _, role := range user.Roles {
-		if err := m.addRole(user.Name, role); err != nil {
+		if err := m.addRole(ctx, plan, user.Name, role, "role is set in config"); err != nil {
 			return fmt.Errorf("error adding user to role: %w", err)
 		}
 	}
 
 	// Remove user from roles not specified in the config
-	roles, err := m.getRoles(user.Name)
+	roles, err := m.catalog(plan).roles(ctx, user.Name)
 	if err != nil {
 		return err
 	}
 
 	for _, role := range roles {
 		if !slices.Contains(user.Roles, role) {
-			if err := m.removeRole(user.Name, role); err != nil {
+			if err := m.removeRole(ctx, plan, user.Name, role, "role is not set in config"); err != nil {
 				return fmt.Errorf("error removing user from role: %w", err)
 			}
 		}
@@ -52,10 +74,10 @@ func (m *postgresManager) GrantPermissions(user User) error {
 }
 
 // getRoles returns a list of roles for the specified user.
-func (m *postgresManager) getRoles(username string) ([]string, error) {
+func (m *postgresManager) getRoles(ctx context.Context, username string) ([]string, error) {
 	var roles []string
 	query := "SELECT r.rolname FROM pg_roles r JOIN pg_auth_members m ON r.oid = m.roleid JOIN pg_roles u ON m.member = u.oid WHERE u.rolname = $1"
-	rows, err := m.db.Query(query, strings.ToLower(username))
+	rows, err := m.db.QueryContext(ctx, query, strings.ToLower(username))
 	if err != nil {
 		return nil, err
 	}
@@ -72,321 +94,353 @@ func (m *postgresManager) getRoles(username string) ([]string, error) {
 	return roles, nil
 }
 
-// addRole adds a user to a role.
-func (m *postgresManager) addRole(username, role string) error {
+// addRole adds the change that adds a user to a role to the plan.
+func (m *postgresManager) addRole(ctx context.Context, plan *Plan, username, role, reason string) error {
+	if err := m.requireUser(ctx, plan, role); err != nil {
+		return err
+	}
+
+	added, err := m.planAddRole(ctx, plan, username, role, reason)
+	if err == nil && !added {
+		plan.unchanged(resource("role", username, role), "user already has role")
+	}
+	return err
+}
+
+// planAddRole adds the change that adds a user to a role to the plan and returns true if a change was
+// added.
+func (m *postgresManager) planAddRole(ctx context.Context, plan *Plan, username, role, reason string) (bool, error) {
 	// Check if the user is trying to add themselves to the role
 	if username == role {
-		log.Printf("User %s is trying to add themselves to role %s, skipping\n", username, role)
-		return nil
+		m.Logger().Debug("User is trying to add themselves to role, skipping", "user", username, "role", role)
+		return false, nil
 	}
 
 	// Check if the user already has the role
-	if hasRole, err := m.hasRole(username, role); err != nil {
-		return err
+	if hasRole, err := m.catalog(plan).hasRole(ctx, username, role); err != nil {
+		return false, err
 	} else if hasRole {
-		log.Printf("User %s already has role %s, skipping\n", username, role)
-		return nil
+		m.Logger().Debug("User already has role, skipping", "user", username, "role", role)
+		return false, nil
 	}
 
 	// Add the user to the role
-	query := fmt.Sprintf("GRANT %s TO %s", QuoteIdentifier(role), QuoteIdentifier(username))
-	if _, err := m.db.Exec(query); err != nil {
+	plan.Add(Change{
+		Resource: resource("role", username, role),
+		Action:   ActionGrant,
+		SQL:      fmt.Sprintf("GRANT %s TO %s", QuoteIdentifier(role), QuoteIdentifier(username)),
+		Reason:   reason,
+	})
+
+	return true, nil
+}
+
+// asMemberOf adds the changes added by fn to the plan, wrapped in changes that make the current user a
+// member of role while they are run. If the current user is already a member of role no membership changes
+// are added.
+func (m *postgresManager) asMemberOf(ctx context.Context, plan *Plan, role string, fn func()) error {
+	added, err := m.planAddRole(ctx, plan, m.connection.Username, role, "temporary membership required to act as role")
+	if err != nil {
 		return err
 	}
 
-	log.Printf("Added user %s to role %s\n", username, role)
+	fn()
 
-	return nil
-}
-
-// hasRole checks if the specified user has the specified role.
-func (m *postgresManager) hasRole(username, role string) (bool, error) {
-	if username == role {
-		return true, nil
+	if added {
+		plan.Add(Change{
+			Resource: resource("role", m.connection.Username, role),
+			Action:   ActionRevoke,
+			SQL:      fmt.Sprintf("REVOKE %s FROM %s", QuoteIdentifier(role), QuoteIdentifier(m.connection.Username)),
+			Reason:   "remove temporary membership",
+			Cleanup:  true,
+		})
 	}
 
-	var exists bool
-	query := "SELECT 1 FROM pg_roles r JOIN pg_auth_members m ON r.oid = m.roleid JOIN pg_roles u ON m.member = u.oid WHERE r.rolname = $1 AND u.rolname = $2"
-	err := m.db.QueryRow(query, strings.ToLower(role), username).Scan(&exists)
-	if err != nil && err != sql.ErrNoRows {
-		return false, err
-	}
-	return exists, nil
+	return nil
 }
 
-// removeRole removes a user from a role.
-func (m *postgresManager) removeRole(username, role string) error {
+// removeRole adds the change that removes a user from a role to the plan.
+func (m *postgresManager) removeRole(ctx context.Context, plan *Plan, username, role, reason string) error {
 	// Check if the user is trying to remove themselves from the role
 	if username == role {
-		log.Printf("User %s is trying to remove themselves from role %s, skipping\n", username, role)
+		m.Logger().Debug("User is trying to remove themselves from role, skipping", "user", username, "role", role)
 		return nil
 	}
 
 	// Check if the user has the role
-	if hasRole, err := m.hasRole(username, role); err != nil {
+	if hasRole, err := m.catalog(plan).hasRole(ctx, username, role); err != nil {
 		return err
 	} else if !hasRole {
-		log.Printf("User %s does not have role %s, skipping\n", username, role)
+		m.Logger().Debug("User does not have role, skipping", "user", username, "role", role)
 		return nil
 	}
 
 	// Remove the user from the role
-	query := fmt.Sprintf("REVOKE %s FROM %s", QuoteIdentifier(role), QuoteIdentifier(username))
-	if _, err := m.db.Exec(query); err != nil {
-		return err
-	}
-
-	log.Printf("Removed user %s from role %s\n", username, role)
+	plan.Add(Change{
+		Resource: resource("role", username, role),
+		Action:   ActionRevoke,
+		SQL:      fmt.Sprintf("REVOKE %s FROM %s", QuoteIdentifier(role), QuoteIdentifier(username)),
+		Reason:   reason,
+	})
 
 	return nil
 }
 
-// grantPermission grants a single permission to a user.
-func (m *postgresManager) grantPermission(username string, grant Grant) error {
-	var query string
-
-	database := grant.Database
-	if database == "" {
-		database = "postgres"
-	}
-
-	// Create new client using the database where permissions are being granted,
-	// we also use this client to check if the user already has the permissions
-	db := &postgresManager{
-		databaseManager: databaseManager{
-			connection: Connection{
-				Host:     m.connection.Host,
-				Database: database,
-				Port:     m.connection.Port,
-				Username: m.connection.Username,
-				Password: m.connection.Password,
-				SSLMode:  m.connection.SSLMode,
-			},
-		},
-	}
-	// Connect to the database
-	if err := db.Connect(); err != nil {
-		return err
+// postgresGrant is a Grant resolved to the database it applies to, the query that grants it and the object
+// and privileges used to see if it has already been granted.
+type postgresGrant struct {
+	username   string
+	database   string
+	target     string
+	query      string
+	object     postgresObject
+	columns    []string
+	privileges []string
+	withGrant  bool
+}
+
+// resolveGrant resolves a Grant for a user.
+func (m *postgresManager) resolveGrant(username string, grant Grant) (postgresGrant, error) {
+	resolved := postgresGrant{username: username, database: grant.Database, columns: grant.Columns, privileges: grant.Privileges, withGrant: grant.WithGrant}
+	var err error
+	if resolved.database == "" {
+		resolved.database = "postgres"
 	}
-	defer db.Disconnect()
 
 	// Construct the grant query based on the provided options
 	if grant.Database == "" && grant.Parameter != "" {
-		if hasPermissions, err := db.hasParameterPrivilege(username, grant.Parameter, grant.Privileges[0]); err != nil {
-			return err
-		} else if hasPermissions {
-			log.Printf("User %s already has permissions on parameter %s, skipping\n", username, grant.Parameter)
-			return nil
-		}
-		query = m.grantParameterPermissionQuery(username, grant)
+		resolved.target = "parameter:" + grant.Parameter
+		resolved.object = postgresObject{kind: "parameter", name: grant.Parameter}
+		resolved.query, err = m.grantParameterPermissionQuery(username, grant)
 	} else if grant.Database != "" && grant.Schema == "" {
-		if hasPermissions, err := db.hasDatabasePrivilege(username, grant.Database, grant.Privileges); err != nil {
-			return err
-		} else if hasPermissions {
-			log.Printf("User %s already has permissions on database %s, skipping\n", username, grant.Database)
-			return nil
-		}
-		query = m.grantDatabasePermissionQuery(username, grant)
+		resolved.target = "database:" + grant.Database
+		resolved.object = postgresObject{kind: "database", name: grant.Database}
+		resolved.query, err = m.grantDatabasePermissionQuery(username, grant)
 	} else if grant.Database != "" && grant.Schema != "" {
-		if grant.Table != "" {
-			if hasPermissions, err := db.hasTablePrivilege(username, grant.Schema, grant.Table, grant.Privileges); err != nil {
-				return err
-			} else if hasPermissions {
-				log.Printf("User %s already has permissions on table %s in database %s, skipping\n", username, grant.Table, grant.Database)
-				return nil
-			}
+		// Wildcards never match an object so they're always granted
+		if len(grant.Columns) > 0 {
+			resolved.target = fmt.Sprintf("table:%s.%s.%s(%s)", grant.Database, grant.Schema, grant.Table, strings.Join(grant.Columns, ","))
+			resolved.object = postgresObject{kind: "table", database: grant.Database, schema: grant.Schema, name: grant.Table}
+		} else if grant.Table != "" {
+			resolved.target = fmt.Sprintf("table:%s.%s.%s", grant.Database, grant.Schema, grant.Table)
+			resolved.object = postgresObject{kind: "table", database: grant.Database, schema: grant.Schema, name: grant.Table}
+		} else if grant.Function != "" {
+			resolved.target = fmt.Sprintf("function:%s.%s.%s", grant.Database, grant.Schema, grant.Function)
+			resolved.object = postgresObject{kind: "function", database: grant.Database, schema: grant.Schema, name: grant.Function}
+		} else if grant.Procedure != "" {
+			resolved.target = fmt.Sprintf("procedure:%s.%s.%s", grant.Database, grant.Schema, grant.Procedure)
+			resolved.object = postgresObject{kind: "procedure", database: grant.Database, schema: grant.Schema, name: grant.Procedure}
 		} else if grant.Sequence != "" {
-			if hasPermissions, err := db.hasSequencePrivilege(username, grant.Schema, grant.Sequence, grant.Privileges); err != nil {
-				return err
-			} else if hasPermissions {
-				log.Printf("User %s already has permissions on sequence %s in database %s, skipping\n", username, grant.Table, grant.Database)
-				return nil
-			}
+			resolved.target = fmt.Sprintf("sequence:%s.%s.%s", grant.Database, grant.Schema, grant.Sequence)
+			resolved.object = postgresObject{kind: "sequence", database: grant.Database, schema: grant.Schema, name: grant.Sequence}
 		} else {
-			if hasPermissions, err := db.hasSchemaPrivilege(username, grant.Schema, grant.Privileges); err != nil {
-				return err
-			} else if hasPermissions {
-				log.Printf("User %s already has permissions on schema %s in database %s, skipping\n", username, grant.Table, grant.Database)
-				return nil
-			}
+			resolved.target = fmt.Sprintf("schema:%s.%s", grant.Database, grant.Schema)
+			resolved.object = postgresObject{kind: "schema", database: grant.Database, name: grant.Schema}
 		}
-		query = m.grantSchemaPermissionQuery(username, grant)
+		resolved.query, err = m.grantSchemaPermissionQuery(username, grant)
 	} else {
-		return fmt.Errorf("invalid grant options")
+		return postgresGrant{}, fmt.Errorf("%w: a parameter or database is required", ErrInvalidGrant)
 	}
-
-	// Execute the grant query
-	if _, err := db.db.Exec(query); err != nil {
-		return err
+	if err != nil {
+		return postgresGrant{}, err
 	}
 
-	return nil
+	return resolved, nil
 }
 
-// hasDatabasePrivilege checks if a user has the specified privileges on a database.
-func (m *postgresManager) hasDatabasePrivilege(username, database string, privileges []string) (bool, error) {
-	if privileges[0] == "ALL" {
-		privileges = []string{"CREATE", "CONNECT", "TEMPORARY", "TEMP"}
-	}
-
-	for _, privilege := range privileges {
-		query := fmt.Sprintf("SELECT has_database_privilege('%s', '%s', '%s')",
-			username, database, privilege)
-		var hasPermission bool
-		if err := m.db.QueryRow(query).Scan(&hasPermission); err != nil {
-			return false, err
-		}
-		if !hasPermission {
-			return false, nil // If any privilege is not granted, return false
-		}
+// grantPermission adds the change that grants a single permission to a user to the plan.
+func (m *postgresManager) grantPermission(ctx context.Context, plan *Plan, username string, grant Grant) error {
+	resolved, err := m.resolveGrant(username, grant)
+	if err != nil {
+		return err
 	}
 
-	return true, nil // All privileges are granted
-}
+	reason := "privileges are missing"
 
-// hasParameterPrivilege checks if a user has the specified privileges on a parameter.
-func (m *postgresManager) hasParameterPrivilege(username, parameter string, privilege string) (bool, error) {
-	query := fmt.Sprintf("SELECT has_parameter_privilege('%s', '%s', '%s')",
-		username, parameter, privilege)
-	var hasPermission bool
-	if err := m.db.QueryRow(query).Scan(&hasPermission); err != nil {
-		return false, err
-	}
-	if !hasPermission {
-		return false, nil // If any privilege is not granted, return false
+	// We can't check the privileges of users or databases that don't exist yet, so these are always granted
+	if plan.users[username] {
+		reason = "user will be created"
+	} else if plan.databases[resolved.database] {
+		reason = "database will be created"
+	} else if hasPermissions, err := m.catalog(plan).hasGrant(ctx, resolved); err != nil {
+		return err
+	} else if hasPermissions {
+		m.Logger().Debug("User already has permissions, skipping", "user", username, "target", resolved.target)
+		plan.unchanged(resource("grant", username, resolved.target), "user already has privileges")
+		return nil
 	}
 
-	return true, nil // All privileges are granted
+	plan.Add(Change{
+		Resource: resource("grant", username, resolved.target),
+		Action:   ActionGrant,
+		Database: resolved.database,
+		SQL:      resolved.query,
+		Reason:   reason,
+	})
+
+	return nil
 }
 
-// hasTablePrivilege checks if a user has the specified privileges on a table.
-func (m *postgresManager) hasTablePrivilege(username, schema, table string, privileges []string) (bool, error) {
-	// We can't check privileges using has_table_privilege if the table is a wildcard
-	// because it will return an error, so we'll just return false and let the grantPermission
-	// function reapply the permissions.
-	if table == "*" {
-		return false, nil
+// grantDatabasePermissionQuery returns the query that grants a permission on a database to a user.
+func (m *postgresManager) grantDatabasePermissionQuery(username string, grant Grant) (string, error) {
+	privileges, err := privilegeList(grant.Privileges, grantPrivileges(m.engine, grant))
+	if err != nil {
+		return "", err
 	}
 
-	if privileges[0] == "ALL" {
-		privileges = []string{"SELECT", "INSERT", "UPDATE", "DELETE", "TRUNCATE", "REFERENCES", "TRIGGER"}
+	query := fmt.Sprintf("GRANT %s ON %s TO %s", privileges, databaseObject(grant), QuoteIdentifier(username))
+	// Add WITH GRANT OPTION if GrantOption is true
+	if grant.WithGrant {
+		query += " WITH GRANT OPTION"
 	}
+	return query, nil
+}
 
-	for _, privilege := range privileges {
-		query := fmt.Sprintf("SELECT has_table_privilege('%s', '%s.%s', '%s')",
-			username, schema, table, privilege)
-		var hasPermission bool
-		if err := m.db.QueryRow(query).Scan(&hasPermission); err != nil {
-			return false, err
-		}
-		if !hasPermission {
-			return false, nil // If any privilege is not granted, return false
-		}
+// grantParameterPermissionQuery returns the query that grants a permission on a parameter to a user.
+func (m *postgresManager) grantParameterPermissionQuery(username string, grant Grant) (string, error) {
+	privileges, err := privilegeList(grant.Privileges, grantPrivileges(m.engine, grant))
+	if err != nil {
+		return "", err
 	}
 
-	return true, nil // All privileges are granted
+	return fmt.Sprintf("GRANT %s ON %s TO %s", privileges, parameterObject(grant), QuoteIdentifier(username)), nil
 }
 
-// hasSequencePrivilege checks if a user has the specified privileges on a sequence.
-func (m *postgresManager) hasSequencePrivilege(username, schema, sequence string, privileges []string) (bool, error) {
-	// We can't check privileges using has_sequence_privilege if the sequence is a wildcard
-	// because it will return an error, so we'll just return false and let the grantPermission
-	// function reapply the permissions.
-	if sequence == "*" {
-		return false, nil
+// grantSchemaPermissionQuery returns the query that grants a permission on a schema, or an object in a
+// schema, to a user.
+func (m *postgresManager) grantSchemaPermissionQuery(username string, grant Grant) (string, error) {
+	privileges, err := privilegeList(grant.Privileges, grantPrivileges(m.engine, grant))
+	if err != nil {
+		return "", err
 	}
+	privileges = withColumns(privileges, grant.Columns, QuoteIdentifier)
 
-	if privileges[0] == "ALL" {
-		privileges = []string{"SELECT", "UPDATE"}
-	}
+	query := fmt.Sprintf("GRANT %s ON %s TO %s", privileges, schemaObject(grant), QuoteIdentifier(username))
 
-	for _, privilege := range privileges {
-		query := fmt.Sprintf("SELECT has_sequence_privilege('%s', '%s.%s', '%s')",
-			username, schema, sequence, privilege)
-		var hasPermission bool
-		if err := m.db.QueryRow(query).Scan(&hasPermission); err != nil {
-			return false, err
-		}
-		if !hasPermission {
-			return false, nil // If any privilege is not granted, return false
-		}
+	if grant.WithGrant {
+		query += " WITH GRANT OPTION"
 	}
 
-	return true, nil // All privileges are granted
+	return query, nil
 }
 
-// hasSchemaPrivilege checks if a user has the specified privileges on a schema.
-func (m *postgresManager) hasSchemaPrivilege(username, schema string, privileges []string) (bool, error) {
-	if privileges[0] == "ALL" {
-		privileges = []string{"CREATE", "USAGE"}
+// revokePermissionQuery returns the query that revokes a permission from a user.
+func (m *postgresManager) revokePermissionQuery(username string, grant Grant) (string, error) {
+	privileges, err := privilegeList(grant.Privileges, grantPrivileges(m.engine, grant))
+	if err != nil {
+		return "", err
 	}
 
-	for _, privilege := range privileges {
-		query := fmt.Sprintf("SELECT has_schema_privilege('%s', '%s', '%s')",
-			username, schema, privilege)
-		var hasPermission bool
-		if err := m.db.QueryRow(query).Scan(&hasPermission); err != nil {
-			return false, err
-		}
-		if !hasPermission {
-			return false, nil // If any privilege is not granted, return false
-		}
+	var object string
+	switch {
+	case grant.Database == "" && grant.Parameter != "":
+		object = parameterObject(grant)
+	case grant.Schema == "":
+		object = databaseObject(grant)
+	default:
+		object = schemaObject(grant)
 	}
 
-	return true, nil // All privileges are granted
+	// Revoking the privileges also revokes the grant option, so WithGrant is ignored
+	return fmt.Sprintf("REVOKE %s ON %s FROM %s", withColumns(privileges, grant.Columns, QuoteIdentifier), object, QuoteIdentifier(username)), nil
 }
 
-// grantDatabasePermission grants a permission on a database to a user.
-func (m *postgresManager) grantDatabasePermissionQuery(username string, grant Grant) string {
-	log.Printf("Granting %s permission to %s database", username, grant.Database)
-	query := fmt.Sprintf("GRANT %s ON DATABASE %s TO %s", strings.Join(grant.Privileges, ", "), QuoteIdentifier(grant.Database), QuoteIdentifier(username))
-	// Add WITH GRANT OPTION if GrantOption is true
-	if grant.WithGrant {
-		query += " WITH GRANT OPTION"
-	}
-	return query
+// databaseObject returns the object a database grant applies to.
+func databaseObject(grant Grant) string {
+	return fmt.Sprintf("DATABASE %s", QuoteIdentifier(grant.Database))
 }
 
-// grantParameterPermission grants a permission on a parameter to a user.
-func (m *postgresManager) grantParameterPermissionQuery(username string, grant Grant) string {
-	log.Printf("Granting %s permission to %s parameter", username, grant.Parameter)
-	query := fmt.Sprintf("GRANT %s ON", strings.Join(grant.Privileges, ", "))
+// parameterObject returns the object a parameter grant applies to.
+func parameterObject(grant Grant) string {
 	if grant.Parameter == "*" {
-		query += " ALL PARAMETERS"
-	} else {
-		query += fmt.Sprintf(" PARAMETER %s", QuoteIdentifier(grant.Parameter))
+		return "ALL PARAMETERS"
 	}
-	query += fmt.Sprintf(" TO %s", QuoteIdentifier(username))
-	return query
+	return fmt.Sprintf("PARAMETER %s", QuoteIdentifier(grant.Parameter))
 }
 
-// grantSchemaPermission grants a permission on a schema to a user.
-func (m *postgresManager) grantSchemaPermissionQuery(username string, grant Grant) string {
-	query := fmt.Sprintf("GRANT %s ON", strings.Join(grant.Privileges, ", "))
-
+// schemaObject returns the object a schema, table, sequence, function or procedure grant applies to.
+func schemaObject(grant Grant) string {
 	switch {
+	case grant.Function == "*":
+		return fmt.Sprintf("ALL FUNCTIONS IN SCHEMA %s", QuoteIdentifier(grant.Schema))
+
+	case grant.Function != "":
+		return fmt.Sprintf("FUNCTION %s.%s", QuoteIdentifier(grant.Schema), QuoteIdentifier(grant.Function))
+
+	case grant.Procedure == "*":
+		return fmt.Sprintf("ALL PROCEDURES IN SCHEMA %s", QuoteIdentifier(grant.Schema))
+
+	case grant.Procedure != "":
+		return fmt.Sprintf("PROCEDURE %s.%s", QuoteIdentifier(grant.Schema), QuoteIdentifier(grant.Procedure))
+
 	case grant.Sequence == "*":
-		log.Printf("Granting permissions to all sequences in schema %s", grant.Schema)
-		query += fmt.Sprintf(" ALL SEQUENCES IN SCHEMA %s", QuoteIdentifier(grant.Schema))
+		return fmt.Sprintf("ALL SEQUENCES IN SCHEMA %s", QuoteIdentifier(grant.Schema))
 
 	case grant.Sequence != "":
-		log.Printf("Granting permissions to sequence in schema %s", grant.Schema)
-		query += fmt.Sprintf(" SEQUENCE %s.%s", QuoteIdentifier(grant.Schema), QuoteIdentifier(grant.Sequence))
+		return fmt.Sprintf("SEQUENCE %s.%s", QuoteIdentifier(grant.Schema), QuoteIdentifier(grant.Sequence))
 
 	case grant.Table == "*":
-		log.Printf("Granting permissions to all tables in schema %s", grant.Schema)
-		query += fmt.Sprintf(" ALL TABLES IN SCHEMA %s", QuoteIdentifier(grant.Schema))
+		return fmt.Sprintf("ALL TABLES IN SCHEMA %s", QuoteIdentifier(grant.Schema))
 
 	case grant.Table != "":
-		log.Printf("Granting permissions to table in schema %s", grant.Schema)
-		query += fmt.Sprintf(" TABLE %s.%s", QuoteIdentifier(grant.Schema), QuoteIdentifier(grant.Table))
+		return fmt.Sprintf("TABLE %s.%s", QuoteIdentifier(grant.Schema), QuoteIdentifier(grant.Table))
 	}
 
-	query += fmt.Sprintf(" TO %s", QuoteIdentifier(username))
+	return fmt.Sprintf("SCHEMA %s", QuoteIdentifier(grant.Schema))
+}
+
+// RevokePermissions revokes the provided grants from a user.
+func (m *postgresManager) RevokePermissions(user User, grants []Grant) error {
+	return m.RevokePermissionsContext(context.Background(), user, grants)
+}
 
-	if grant.WithGrant {
-		query += " WITH GRANT OPTION"
+// RevokePermissionsContext is like RevokePermissions but uses the provided context for all queries.
+func (m *postgresManager) RevokePermissionsContext(ctx context.Context, user User, grants []Grant) error {
+	plan := NewPlan()
+	if err := m.planRevokePermissions(ctx, plan, user, grants); err != nil {
+		return err
 	}
 
-	return query
+	_, err := m.ApplyContext(ctx, plan)
+	return err
+}
+
+// planRevokePermissions adds the changes that revoke grants from a user to the plan.
+func (m *postgresManager) planRevokePermissions(ctx context.Context, plan *Plan, user User, grants []Grant) error {
+	// Check if the user exists
+	if exists, err := m.catalog(plan).userExists(ctx, user.Name); err != nil {
+		return err
+	} else if !exists {
+		m.Logger().Debug("User does not exist, skipping", "user", user.Name)
+		return nil
+	}
+
+	for _, grant := range grants {
+		resolved, err := m.resolveGrant(user.Name, grant)
+		if err != nil {
+			return fmt.Errorf("error revoking permissions: %w", err)
+		}
+
+		// Privileges on databases that don't exist have nothing to revoke
+		if exists, err := m.catalog(plan).databaseExists(ctx, resolved.database); err != nil {
+			return err
+		} else if !exists {
+			m.Logger().Debug("Database does not exist, skipping", "database", resolved.database)
+			continue
+		}
+
+		query, err := m.revokePermissionQuery(user.Name, grant)
+		if err != nil {
+			return err
+		}
+		plan.Add(Change{
+			Resource: resource("grant", user.Name, resolved.target),
+			Action:   ActionRevoke,
+			Database: resolved.database,
+			SQL:      query,
+			Reason:   "revoke requested",
+		})
+	}
+
+	return nil
 }
@@ -0,0 +1,347 @@
+package dbmanager
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+var (
+	// postgresSystemUsers are users and roles that are never pruned.
+	postgresSystemUsers = []string{"postgres", "pg_*", "rdsadmin", "rdsrepladmin", "rdstopmgr", "rds_*"}
+
+	// postgresSystemDatabases are databases that are never pruned.
+	postgresSystemDatabases = []string{"postgres", "template0", "template1", "rdsadmin"}
+)
+
+// postgresPrivilege is a single privilege granted to a user on an object.
+type postgresPrivilege struct {
+	kind      string
+	database  string
+	schema    string
+	name      string
+	privilege string
+	grantable bool
+}
+
+// planPrune adds the changes that remove grants, databases and users that aren't in the config to the plan.
+// Extra grants are revoked first, then extra databases are dropped and finally extra users are dropped.
+func (m *postgresManager) planPrune(ctx context.Context, plan *Plan, databases []Database, users []User, exclude []string) error {
+	excludeUsers := append(slices.Clone(postgresSystemUsers), exclude...)
+	excludeDatabases := append(slices.Clone(postgresSystemDatabases), exclude...)
+
+	existing, err := m.listDatabases(ctx)
+	if err != nil {
+		return err
+	}
+
+	var remaining, dropped []string
+	for _, name := range existing {
+		if name == m.connection.Database || matchesAny(name, excludeDatabases) ||
+			slices.ContainsFunc(databases, func(database Database) bool { return database.Name == name }) {
+			remaining = append(remaining, name)
+		} else {
+			dropped = append(dropped, name)
+		}
+	}
+
+	// Revoke grants that aren't in the config, grants in excluded databases are left alone
+	var managed []string
+	for _, name := range remaining {
+		if !matchesAny(name, exclude) {
+			managed = append(managed, name)
+		}
+	}
+	for _, user := range users {
+		if plan.users[user.Name] || matchesAny(user.Name, excludeUsers) {
+			continue
+		}
+		if err := m.pruneGrants(ctx, plan, user, managed); err != nil {
+			return err
+		}
+	}
+
+	// Drop databases that aren't in the config
+	for _, name := range dropped {
+		plan.Add(Change{
+			Resource: resource("database", name),
+			Action:   ActionDrop,
+			SQL:      fmt.Sprintf("DROP DATABASE %s", QuoteIdentifier(name)),
+			Reason:   "database is not in config",
+		})
+	}
+
+	// Drop users that aren't in the config
+	existingUsers, err := m.listUsers(ctx)
+	if err != nil {
+		return err
+	}
+	for _, name := range prunedUsers(existingUsers, databases, users, excludeUsers) {
+		if err := m.dropUser(ctx, plan, name, remaining, m.connection.Username); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// prunedUsers returns the existing users and roles that aren't in the config. Roles the config refers to,
+// as a user's role, a database's owner or in default privileges, are kept as they're used by the plan.
+func prunedUsers(existing []string, databases []Database, users []User, exclude []string) []string {
+	referenced := map[string]bool{}
+	for _, user := range users {
+		referenced[user.Name] = true
+		for _, role := range user.Roles {
+			referenced[role] = true
+		}
+	}
+	for _, database := range databases {
+		referenced[database.Owner] = true
+		for _, defaultPrivilege := range database.DefaultPrivileges {
+			referenced[defaultPrivilege.Role] = true
+			referenced[defaultPrivilege.To] = true
+		}
+	}
+
+	var pruned []string
+	for _, name := range existing {
+		if !referenced[name] && !matchesAny(name, exclude) {
+			pruned = append(pruned, name)
+		}
+	}
+	return pruned
+}
+
+// pruneGrants adds the changes that revoke any privileges a user has that aren't in the user's grants to
+// the plan. Only privileges on the specified databases are checked, parameter privileges are always checked.
+func (m *postgresManager) pruneGrants(ctx context.Context, plan *Plan, user User, databases []string) error {
+	privileges, err := m.getDatabasePrivileges(ctx, user.Name)
+	if err != nil {
+		return err
+	}
+
+	parameterPrivileges, err := m.getParameterPrivileges(ctx, user.Name)
+	if err != nil {
+		return err
+	}
+	privileges = append(privileges, parameterPrivileges...)
+
+	for _, database := range databases {
+		db, err := m.database(ctx, database)
+		if err != nil {
+			return err
+		}
+		objectPrivileges, err := db.getObjectPrivileges(ctx, user.Name)
+		if err != nil {
+			return err
+		}
+		privileges = append(privileges, objectPrivileges...)
+	}
+
+	for _, privilege := range privileges {
+		if privilege.kind == "database" && !slices.Contains(databases, privilege.database) {
+			continue
+		}
+		if slices.ContainsFunc(user.Grants, func(grant Grant) bool { return grantCovers(grant, privilege) }) {
+			continue
+		}
+		plan.Add(revokePrivilegeChange(user.Name, privilege))
+	}
+
+	return nil
+}
+
+// grantCovers returns true if the grant includes the privilege.
+func grantCovers(grant Grant, privilege postgresPrivilege) bool {
+	switch privilege.kind {
+	case "parameter":
+		if grant.Database != "" || (grant.Parameter != "*" && !strings.EqualFold(grant.Parameter, privilege.name)) {
+			return false
+		}
+	case "database":
+		if grant.Database != privilege.database || grant.Schema != "" || grant.Parameter != "" {
+			return false
+		}
+	case "schema":
+		if grant.Database != privilege.database || grant.Schema != privilege.schema || grant.Table != "" || grant.Sequence != "" || grant.Function != "" || grant.Procedure != "" {
+			return false
+		}
+	case "table":
+		if grant.Database != privilege.database || grant.Schema != privilege.schema || (grant.Table != "*" && grant.Table != privilege.name) || len(grant.Columns) > 0 {
+			return false
+		}
+	case "sequence":
+		if grant.Database != privilege.database || grant.Schema != privilege.schema || (grant.Sequence != "*" && grant.Sequence != privilege.name) {
+			return false
+		}
+	default:
+		return false
+	}
+
+	return slices.ContainsFunc(grant.Privileges, func(p string) bool {
+		p = strings.ToUpper(p)
+		return p == "ALL" || p == "ALL PRIVILEGES" || p == privilege.privilege || (p == "TEMP" && privilege.privilege == "TEMPORARY")
+	})
+}
+
+// revokePrivilegeChange returns the change that revokes a privilege from a user.
+func revokePrivilegeChange(username string, privilege postgresPrivilege) Change {
+	var object, target, database string
+
+	switch privilege.kind {
+	case "parameter":
+		object = "PARAMETER " + QuoteIdentifier(privilege.name)
+		target = "parameter:" + privilege.name
+	case "database":
+		object = "DATABASE " + QuoteIdentifier(privilege.database)
+		target = "database:" + privilege.database
+	case "schema":
+		object = "SCHEMA " + QuoteIdentifier(privilege.schema)
+		target = fmt.Sprintf("schema:%s.%s", privilege.database, privilege.schema)
+		database = privilege.database
+	default:
+		object = fmt.Sprintf("%s %s.%s", strings.ToUpper(privilege.kind), QuoteIdentifier(privilege.schema), QuoteIdentifier(privilege.name))
+		target = fmt.Sprintf("%s:%s.%s.%s", privilege.kind, privilege.database, privilege.schema, privilege.name)
+		database = privilege.database
+	}
+
+	return Change{
+		Resource: resource("grant", username, target),
+		Action:   ActionRevoke,
+		Database: database,
+		SQL:      fmt.Sprintf("REVOKE %s ON %s FROM %s", privilege.privilege, object, QuoteIdentifier(username)),
+		Reason:   fmt.Sprintf("%s privilege is not in config", privilege.privilege),
+	}
+}
+
+// dropUser adds the changes that drop a user to the plan. Objects owned by the user are reassigned to the
+// reassignTo role and any remaining objects and privileges are dropped in each of the databases first. If
+// reassignTo is empty the objects are dropped instead.
+func (m *postgresManager) dropUser(ctx context.Context, plan *Plan, name string, databases []string, reassignTo string) error {
+	// RDS wants the user reassigning objects to be a member of the role that owns them. The membership
+	// doesn't need to be removed after as it is dropped along with the role.
+	if _, err := m.planAddRole(ctx, plan, m.connection.Username, name, "membership required to reassign owned objects"); err != nil {
+		return err
+	}
+
+	for _, database := range databases {
+		if reassignTo != "" {
+			plan.Add(Change{
+				Resource: resource("user", name),
+				Action:   ActionUpdate,
+				Database: database,
+				SQL:      fmt.Sprintf("REASSIGN OWNED BY %s TO %s", QuoteIdentifier(name), QuoteIdentifier(reassignTo)),
+				Reason:   "reassign owned objects before dropping user",
+			})
+		}
+		plan.Add(Change{
+			Resource: resource("user", name),
+			Action:   ActionRevoke,
+			Database: database,
+			SQL:      fmt.Sprintf("DROP OWNED BY %s", QuoteIdentifier(name)),
+			Reason:   "drop remaining privileges before dropping user",
+		})
+	}
+
+	plan.Add(Change{
+		Resource: resource("user", name),
+		Action:   ActionDrop,
+		SQL:      fmt.Sprintf("DROP ROLE %s", QuoteIdentifier(name)),
+		Reason:   "user is not in config",
+	})
+
+	return nil
+}
+
+// listDatabases returns the names of all databases on the server that can be connected to.
+func (m *postgresManager) listDatabases(ctx context.Context) ([]string, error) {
+	query := "SELECT datname FROM pg_catalog.pg_database WHERE datallowconn AND NOT datistemplate AND datname <> 'rdsadmin' ORDER BY datname"
+	rows, err := m.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var databases []string
+	for rows.Next() {
+		var database string
+		if err := rows.Scan(&database); err != nil {
+			return nil, err
+		}
+		databases = append(databases, database)
+	}
+
+	return databases, rows.Err()
+}
+
+// getDatabasePrivileges returns the database privileges granted to a user, excluding privileges on
+// databases the user owns.
+func (m *postgresManager) getDatabasePrivileges(ctx context.Context, username string) ([]postgresPrivilege, error) {
+	query := `SELECT 'database', d.datname, '', '', a.privilege_type, a.is_grantable
+		FROM pg_catalog.pg_database d
+		CROSS JOIN LATERAL aclexplode(d.datacl) a
+		JOIN pg_catalog.pg_roles r ON r.oid = a.grantee
+		WHERE r.rolname = $1 AND a.grantee <> d.datdba
+		ORDER BY 2, 5`
+	return m.queryPrivileges(ctx, query, username)
+}
+
+// getParameterPrivileges returns the parameter privileges granted to a user. Parameter privileges were
+// added in PostgreSQL 15 so no privileges are returned on older servers.
+func (m *postgresManager) getParameterPrivileges(ctx context.Context, username string) ([]postgresPrivilege, error) {
+	var supported bool
+	if err := m.db.QueryRowContext(ctx, "SELECT to_regclass('pg_catalog.pg_parameter_acl') IS NOT NULL").Scan(&supported); err != nil {
+		return nil, err
+	}
+	if !supported {
+		return nil, nil
+	}
+
+	query := `SELECT 'parameter', '', '', p.parname, a.privilege_type, a.is_grantable
+		FROM pg_catalog.pg_parameter_acl p
+		CROSS JOIN LATERAL aclexplode(p.paracl) a
+		JOIN pg_catalog.pg_roles r ON r.oid = a.grantee
+		WHERE r.rolname = $1
+		ORDER BY 4, 5`
+	return m.queryPrivileges(ctx, query, username)
+}
+
+// getObjectPrivileges returns the schema, table and sequence privileges granted to a user in the current
+// database, excluding privileges on objects the user owns.
+func (m *postgresManager) getObjectPrivileges(ctx context.Context, username string) ([]postgresPrivilege, error) {
+	query := `SELECT 'schema', current_database(), n.nspname, '', a.privilege_type, a.is_grantable
+		FROM pg_catalog.pg_namespace n
+		CROSS JOIN LATERAL aclexplode(n.nspacl) a
+		JOIN pg_catalog.pg_roles r ON r.oid = a.grantee
+		WHERE r.rolname = $1 AND a.grantee <> n.nspowner
+		UNION ALL
+		SELECT CASE c.relkind WHEN 'S' THEN 'sequence' ELSE 'table' END, current_database(), n.nspname, c.relname, a.privilege_type, a.is_grantable
+		FROM pg_catalog.pg_class c
+		JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+		CROSS JOIN LATERAL aclexplode(c.relacl) a
+		JOIN pg_catalog.pg_roles r ON r.oid = a.grantee
+		WHERE r.rolname = $1 AND a.grantee <> c.relowner AND c.relkind IN ('r', 'v', 'm', 'p', 'f', 'S')
+		ORDER BY 1, 3, 4, 5`
+	return m.queryPrivileges(ctx, query, username)
+}
+
+// queryPrivileges runs a query returning kind, database, schema, name, privilege and grantable columns.
+func (m *postgresManager) queryPrivileges(ctx context.Context, query string, args ...any) ([]postgresPrivilege, error) {
+	rows, err := m.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var privileges []postgresPrivilege
+	for rows.Next() {
+		var p postgresPrivilege
+		if err := rows.Scan(&p.kind, &p.database, &p.schema, &p.name, &p.privilege, &p.grantable); err != nil {
+			return nil, err
+		}
+		privileges = append(privileges, p)
+	}
+
+	return privileges, rows.Err()
+}
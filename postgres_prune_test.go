@@ -0,0 +1,29 @@
+package dbmanager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrunedUsers(t *testing.T) {
+	databases := []Database{{Name: "mydb", Owner: "owner"}}
+	users := []User{{Name: "myuser", Roles: []string{"readers"}}}
+	existing := []string{"myuser", "readers", "owner", "olduser", "pg_monitor", "keepme"}
+
+	// Roles that are only referenced by the config are kept along with the users in it
+	assert.Equal(t, []string{"olduser"}, prunedUsers(existing, databases, users, []string{"pg_*", "keep*"}))
+
+	// Default privileges refer to roles too
+	databases[0].DefaultPrivileges = []DefaultPrivilege{{Role: "olduser", Schema: "public", Grant: []string{"SELECT"}, On: "tables", To: "readers"}}
+	assert.Empty(t, prunedUsers(existing, databases, users, []string{"pg_*", "keep*"}))
+}
+
+func TestRevokePrivilegeChange(t *testing.T) {
+	change := revokePrivilegeChange("myuser", postgresPrivilege{kind: "parameter", name: `work_mem"; DROP ROLE x; --`, privilege: "SET"})
+	assert.Equal(t, `REVOKE SET ON PARAMETER "work_mem""; DROP ROLE x; --" FROM "myuser"`, change.SQL)
+
+	change = revokePrivilegeChange("myuser", postgresPrivilege{kind: "table", database: "mydb", schema: "public", name: "mytable", privilege: "SELECT"})
+	assert.Equal(t, `REVOKE SELECT ON TABLE "public"."mytable" FROM "myuser"`, change.SQL)
+	assert.Equal(t, "mydb", change.Database)
+}
@@ -3,11 +3,16 @@
 package dbmanager
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"os"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
@@ -28,7 +33,7 @@ var (
 
 func testPostgresQuery(username, password, database, query string) (sql.Result, error) {
 	m := &postgresManager{
-		databaseManager: databaseManager{
+		BaseManager: BaseManager{
 			connection: Connection{
 				Host:     "localhost",
 				Database: database,
@@ -128,7 +133,7 @@ func TestPostgresManager_CreateUserIntegration_Basic(t *testing.T) {
 	assert.NoError(t, err, "Error creating user")
 
 	// Check if the user was created successfully
-	exists, err := postgresTestManagerChecker.userExists(username)
+	exists, err := postgresTestManagerChecker.userExists(context.Background(), username)
 	assert.True(t, exists, "User not found after CreateUser operation")
 	assert.NoError(t, err, "Error checking if user exists")
 
@@ -141,10 +146,10 @@ func TestPostgresManager_CreateUserIntegration_Basic(t *testing.T) {
 	err = postgresTestManager.CreateUser(User{Name: username, Password: password})
 	assert.NoError(t, err, "Error creating user when it already exists")
 
-	created, err := postgresTestManagerChecker.getUser(username)
+	created, err := postgresTestManagerChecker.getUser(context.Background(), username)
 	assert.NoError(t, err, "Error getting user options")
 	assert.Equal(t, username, created.Name, "User name does not match")
-	assert.True(t, created.Options.Login, "User login does not match") // Login shouold be true when a password is set
+	assert.Equal(t, Bool(true), created.Options.Login, "User login does not match") // Login shouold be true when a password is set
 }
 
 func TestPostgresManager_CreateUserIntegration_BasicDashes(t *testing.T) {
@@ -155,7 +160,7 @@ func TestPostgresManager_CreateUserIntegration_BasicDashes(t *testing.T) {
 	assert.NoError(t, err, "Error creating user")
 
 	// Check if the user was created successfully
-	exists, err := postgresTestManagerChecker.userExists(username)
+	exists, err := postgresTestManagerChecker.userExists(context.Background(), username)
 	assert.True(t, exists, "User not found after CreateUser operation")
 	assert.NoError(t, err, "Error checking if user exists")
 
@@ -172,7 +177,7 @@ func TestPostgresManager_CreateUserIntegration_BasicMixedCase(t *testing.T) {
 	assert.NoError(t, err, "Error creating user")
 
 	// Check if the user was created successfully
-	exists, err := postgresTestManagerChecker.userExists(username)
+	exists, err := postgresTestManagerChecker.userExists(context.Background(), username)
 	assert.True(t, exists, "User not found after CreateUser operation")
 	assert.NoError(t, err, "Error checking if user exists")
 
@@ -189,7 +194,7 @@ func TestPostgresManager_CreateUserIntegration_BasicUnderscores(t *testing.T) {
 	assert.NoError(t, err, "Error creating user")
 
 	// Check if the user was created successfully
-	exists, err := postgresTestManagerChecker.userExists(username)
+	exists, err := postgresTestManagerChecker.userExists(context.Background(), username)
 	assert.True(t, exists, "User not found after CreateUser operation")
 	assert.NoError(t, err, "Error checking if user exists")
 
@@ -204,13 +209,13 @@ func TestPostgresManager_CreateUserIntegration_WithOptions(t *testing.T) {
 	user := User{
 		Name: username,
 		Options: UserOptions{
-			Login:          true,
-			Superuser:      true,
-			CreateDatabase: true,
-			CreateRole:     true,
-			Inherit:        true,
-			Replication:    true,
-			BypassRLS:      true,
+			Login:          Bool(true),
+			Superuser:      Bool(true),
+			CreateDatabase: Bool(true),
+			CreateRole:     Bool(true),
+			Inherit:        Bool(true),
+			Replication:    Bool(true),
+			BypassRLS:      Bool(true),
 		},
 	}
 
@@ -219,11 +224,11 @@ func TestPostgresManager_CreateUserIntegration_WithOptions(t *testing.T) {
 	assert.NoError(t, err, "Error creating user")
 
 	// Check if the user was created successfully
-	exists, err := postgresTestManagerChecker.userExists(username)
+	exists, err := postgresTestManagerChecker.userExists(context.Background(), username)
 	assert.True(t, exists, "User not found after CreateUser operation")
 	assert.NoError(t, err, "Error checking if user exists")
 
-	created, err := postgresTestManagerChecker.getUser(username)
+	created, err := postgresTestManagerChecker.getUser(context.Background(), username)
 	assert.NoError(t, err, "Error getting user options")
 	assert.Equal(t, user.Name, created.Name, "User name does not match")
 	assert.Equal(t, user.Options.Login, created.Options.Login, "User login does not match")
@@ -237,6 +242,29 @@ func TestPostgresManager_CreateUserIntegration_WithOptions(t *testing.T) {
 	// Attempting to create the user again should not return an error
 	err = postgresTestManager.CreateUser(User{Name: username, Password: password})
 	assert.NoError(t, err, "Error creating user when it already exists")
+
+	// Options that aren't set should have been left alone
+	updated, err := postgresTestManagerChecker.getUser(context.Background(), username)
+	assert.NoError(t, err, "Error getting user options")
+	assert.Equal(t, created.Options, updated.Options, "User options should not change")
+}
+
+func TestPostgresManager_CreateUserIntegration_DefaultOptions(t *testing.T) {
+	username := "mytestuserdefaults"
+
+	// A role created without options should get the server's defaults, including INHERIT
+	err := postgresTestManager.CreateUser(User{Name: username})
+	assert.NoError(t, err, "Error creating user")
+
+	created, err := postgresTestManagerChecker.getUser(context.Background(), username)
+	assert.NoError(t, err, "Error getting user options")
+	assert.Equal(t, Bool(true), created.Options.Inherit, "User should inherit by default")
+	assert.Equal(t, Bool(false), created.Options.Login, "Roles without a password should not log in by default")
+
+	// Setting one option should only change that option
+	plan, err := postgresTestManager.Plan(nil, []User{{Name: username, Options: UserOptions{CreateDatabase: Bool(true)}}})
+	assert.NoError(t, err, "Error planning user")
+	assert.Equal(t, fmt.Sprintf("ALTER USER %s WITH CREATEDB", QuoteIdentifier(username)), plan.Changes[0].SQL)
 }
 
 func TestPostgresManager_CreateDatabaseIntegration_Basic(t *testing.T) {
@@ -245,7 +273,7 @@ func TestPostgresManager_CreateDatabaseIntegration_Basic(t *testing.T) {
 	assert.NoError(t, err, "Error creating database")
 
 	// Check if the database was created successfully
-	exists, err := postgresTestManagerChecker.databaseExists(database)
+	exists, err := postgresTestManagerChecker.databaseExists(context.Background(), database)
 	assert.True(t, exists, "Database not found after CreateDatabase operation")
 	assert.NoError(t, err, "Error checking if database exists")
 
@@ -261,7 +289,7 @@ func TestPostgresManager_CreateDatabaseIntegration_AlterDefaultPrivileges(t *tes
 		{Role: "postgres", Schema: "public", Grant: []string{"USAGE", "SELECT"}, On: "SEQUENCES", To: "username"},
 	}
 	err := postgresTestManager.CreateDatabase(Database{Name: database, DefaultPrivileges: defaultPrivileges})
-	assert.Error(t, err, "Creating database with default privileges should have failed if user does not exist")
+	assert.ErrorIs(t, err, ErrUserNotFound, "Creating database with default privileges should have failed if user does not exist")
 
 	// Create database with default privileges again should succeed when user exists
 	defaultPrivileges = []DefaultPrivilege{
@@ -272,13 +300,18 @@ func TestPostgresManager_CreateDatabaseIntegration_AlterDefaultPrivileges(t *tes
 	assert.NoError(t, err, "Error creating database with default privileges")
 
 	// Check if the database was created successfully
-	exists, err := postgresTestManagerChecker.databaseExists(database)
+	exists, err := postgresTestManagerChecker.databaseExists(context.Background(), database)
 	assert.True(t, exists, "Database not found after CreateDatabase operation with default privileges")
 	assert.NoError(t, err, "Error checking if database exists")
 
 	// Attempting to create the database again should not return an error
 	err = postgresTestManager.CreateDatabase(Database{Name: database})
 	assert.NoError(t, err, "Error creating database with default privileges when it already exists")
+
+	// Default privileges that are already set shouldn't be applied again
+	plan, err := postgresTestManager.Plan([]Database{{Name: database, DefaultPrivileges: defaultPrivileges}}, nil)
+	assert.NoError(t, err, "Error planning database with default privileges")
+	assert.NotContains(t, plan.String(), "ALTER DEFAULT PRIVILEGES", "Default privileges that are set should be unchanged")
 }
 
 func TestPostgresManager_CreateDatabaseIntegration_Owner(t *testing.T) {
@@ -293,10 +326,10 @@ func TestPostgresManager_CreateDatabaseIntegration_Owner(t *testing.T) {
 	assert.NoError(t, err, "Error creating database with existing owner set")
 
 	// Check if the database was created successfully
-	exists, err := postgresTestManagerChecker.databaseExists(owneddb)
+	exists, err := postgresTestManagerChecker.databaseExists(context.Background(), owneddb)
 	assert.True(t, exists, "Database not found after CreateDatabase operation with owner set")
 	assert.NoError(t, err, "Error checking if database exists")
-	set, err := postgresTestManagerChecker.getDatabaseOwner(owneddb)
+	set, err := postgresTestManagerChecker.getDatabaseOwner(context.Background(), owneddb)
 	assert.Equal(t, username, set, "Owner not set after CreateDatabase operation with owner set")
 	assert.NoError(t, err, "Error checking if owner is set")
 
@@ -309,7 +342,7 @@ func TestPostgresManager_CreateDatabaseIntegration_UpdateOwner(t *testing.T) {
 	owneddb := "owneddb"
 
 	// Check current owner
-	current, err := postgresTestManagerChecker.getDatabaseOwner(owneddb)
+	current, err := postgresTestManagerChecker.getDatabaseOwner(context.Background(), owneddb)
 	assert.Equal(t, username, current, "Owner not set after CreateDatabase operation with owner set")
 	assert.NoError(t, err, "Error checking if owner is set")
 
@@ -318,7 +351,7 @@ func TestPostgresManager_CreateDatabaseIntegration_UpdateOwner(t *testing.T) {
 	assert.NoError(t, err, "Error updating database with new owner set")
 
 	// Check if the database was updated successfully
-	updated, err := postgresTestManagerChecker.getDatabaseOwner(owneddb)
+	updated, err := postgresTestManagerChecker.getDatabaseOwner(context.Background(), owneddb)
 	assert.Equal(t, "postgres", updated, "Owner not set after CreateDatabase operation with owner set")
 	assert.NoError(t, err, "Error checking if owner is set")
 }
@@ -380,7 +413,7 @@ func TestPostgresManager_GrantPermissionsIntegration_AddRole(t *testing.T) {
 	assert.NoError(t, err, "Error granting permissions")
 
 	// Check if the role was assigned successfully
-	set, err := postgresTestManagerChecker.hasRole(username, role)
+	set, err := newPostgresCatalog(postgresTestManagerChecker).hasRole(context.Background(), username, role)
 	assert.NoError(t, err, "Error checking if user has role")
 	assert.True(t, set, "User does not have role after GrantPermissions operation")
 
@@ -400,7 +433,7 @@ func TestPostgresManager_GrantPermissionsIntegration_AddRoleWithDashes(t *testin
 	assert.NoError(t, err, "Error granting permissions")
 
 	// Check if the role was assigned successfully
-	set, err := postgresTestManagerChecker.hasRole(username, role)
+	set, err := newPostgresCatalog(postgresTestManagerChecker).hasRole(context.Background(), username, role)
 	assert.NoError(t, err, "Error checking if user has role")
 	assert.True(t, set, "User does not have role after GrantPermissions operation")
 
@@ -420,7 +453,7 @@ func TestPostgresManager_GrantPermissionsIntegration_AddRoleWithUnderscores(t *t
 	assert.NoError(t, err, "Error granting permissions")
 
 	// Check if the role was assigned successfully
-	set, err := postgresTestManagerChecker.hasRole(username, role)
+	set, err := newPostgresCatalog(postgresTestManagerChecker).hasRole(context.Background(), username, role)
 	assert.NoError(t, err, "Error checking if user has role")
 	assert.True(t, set, "User does not have role after GrantPermissions operation")
 
@@ -442,7 +475,7 @@ func TestPostgresManager_GrantPermissionsIntegration_AddSetParameter(t *testing.
 	assert.NoError(t, err, "Error granting permissions")
 
 	// Check if the role was assigned successfully
-	set, err := postgresTestManagerChecker.hasParameterPrivilege(username, "session_replication_role", "SET")
+	set, err := newPostgresCatalog(postgresTestManagerChecker).hasPrivileges(context.Background(), username, postgresObject{kind: "parameter", name: "session_replication_role"}, []string{"SET"}, false)
 	assert.NoError(t, err, "Error checking if user has parameter set")
 	assert.True(t, set, "User does not have session_replication_role parameter after GrantPermissions operation")
 
@@ -470,7 +503,7 @@ func TestPostgresManager_GrantPermissionsIntegration_RemoveRole(t *testing.T) {
 	assert.NoError(t, postgresTestManager.GrantPermissions(User{Name: username, Roles: []string{role}}), "Error granting permissions")
 
 	// Check if the role was removed successfully
-	set, err := postgresTestManagerChecker.hasRole(username, extraRole)
+	set, err := newPostgresCatalog(postgresTestManagerChecker).hasRole(context.Background(), username, extraRole)
 	assert.NoError(t, err, "Error checking if user has role")
 	assert.False(t, set, "User still has \"myextrarole\" role after GrantPermissions operation")
 }
@@ -505,21 +538,551 @@ func TestPostgresManager_ManagerIntegration(t *testing.T) {
 	assert.NoError(t, err, "Error managing databases and users")
 
 	// Check if the database was created successfully
-	exists, err := postgresTestManagerChecker.databaseExists(managedDatabase)
+	exists, err := postgresTestManagerChecker.databaseExists(context.Background(), managedDatabase)
 	assert.True(t, exists, "Database not found after Manage operation")
 	assert.NoError(t, err, "Error checking if database exists")
 
 	// Check if the user was created successfully
-	exists, err = postgresTestManagerChecker.userExists(managedUser)
+	exists, err = postgresTestManagerChecker.userExists(context.Background(), managedUser)
 	assert.True(t, exists, "User not found after Manage operation")
 	assert.NoError(t, err, "Error checking if user exists")
 
 	// Check if the owner was created successfully
-	exists, err = postgresTestManagerChecker.userExists(managedOwner)
+	exists, err = postgresTestManagerChecker.userExists(context.Background(), managedOwner)
 	assert.True(t, exists, "Owner not found after Manage operation")
 	assert.NoError(t, err, "Error checking if owner exists")
 }
 
+func TestPostgresManager_PlanIntegration(t *testing.T) {
+	plannedUser := "planneduser"
+	plannedDatabase := "planneddb"
+
+	databases := []Database{{Name: plannedDatabase, Owner: plannedUser}}
+	users := []User{{Name: plannedUser, Password: password, Grants: []Grant{{Database: plannedDatabase, Privileges: []string{"ALL"}}}}}
+
+	// Planning should not change anything on the server
+	plan, err := postgresTestManager.Plan(databases, users)
+	assert.NoError(t, err, "Error planning databases and users")
+	assert.False(t, plan.Empty(), "Plan should contain changes")
+	assert.NotContains(t, plan.String(), password, "Plan should not contain passwords")
+
+	exists, err := postgresTestManagerChecker.userExists(context.Background(), plannedUser)
+	assert.NoError(t, err, "Error checking if user exists")
+	assert.False(t, exists, "User should not exist after Plan operation")
+
+	// Applying the plan should create the user and database
+	report, err := postgresTestManager.Apply(plan)
+	assert.NoError(t, err, "Error applying plan")
+	assert.True(t, report.Changed(), "Report should show changes")
+	assert.NotContains(t, report.String(), password, "Report should not contain passwords")
+
+	exists, err = postgresTestManagerChecker.userExists(context.Background(), plannedUser)
+	assert.NoError(t, err, "Error checking if user exists")
+	assert.True(t, exists, "User not found after Apply operation")
+
+	exists, err = postgresTestManagerChecker.databaseExists(context.Background(), plannedDatabase)
+	assert.NoError(t, err, "Error checking if database exists")
+	assert.True(t, exists, "Database not found after Apply operation")
+}
+
+func TestPostgresManager_DiffIntegration(t *testing.T) {
+	// The managed database and user created by Manage should have no drift
+	report, err := postgresTestManager.Diff(
+		[]Database{{Name: "manageddb", Owner: "manageduser"}},
+		[]User{{Name: "manageduser", Password: password, Grants: []Grant{{Database: "manageddb", Privileges: []string{"ALL"}}}}},
+	)
+	assert.NoError(t, err, "Error comparing databases and users")
+	assert.NotContains(t, report.Drifts, Drift{Resource: "user/manageduser", Type: DriftMissing})
+	assert.NotContains(t, report.Drifts, Drift{Resource: "grant/manageduser/database:manageddb", Type: DriftMissing})
+
+	// A user that doesn't exist and an owner that differs should be reported
+	report, err = postgresTestManager.Diff(
+		[]Database{{Name: "manageddb", Owner: "postgres"}},
+		[]User{{Name: "missinguser"}},
+	)
+	assert.NoError(t, err, "Error comparing databases and users")
+	assert.True(t, report.HasDrift(), "Expected drift to be reported")
+	assert.Contains(t, report.Drifts, Drift{Resource: "user/missinguser", Type: DriftMissing})
+	assert.Contains(t, report.Drifts, Drift{Resource: "database/manageddb", Type: DriftChanged, Attribute: "owner", Expected: "postgres", Actual: "manageduser"})
+}
+
+func TestPostgresManager_ManagerIntegration_Report(t *testing.T) {
+	reportUser := "reportuser"
+	users := []User{{Name: reportUser, Options: UserOptions{Inherit: Bool(true)}}}
+	var report Report
+
+	// The first run should create the user
+	err := postgresTestManager.Manage(nil, users, WithReport(&report))
+	assert.NoError(t, err, "Error managing users")
+	assert.True(t, report.Changed(), "Report should show changes")
+	assert.Equal(t, StatusCreated, report.Resources[0].Status, "User should be reported as created")
+
+	// The second run should change nothing
+	err = postgresTestManager.Manage(nil, users, WithReport(&report))
+	assert.NoError(t, err, "Error managing users")
+	assert.False(t, report.Changed(), "Report should show no changes")
+	assert.Equal(t, []ResourceReport{{Resource: "user/" + reportUser, Status: StatusUnchanged, Reason: "user options match config"}}, report.Resources)
+
+	// Setting the password again every run isn't reported as a change
+	users[0].Password = password
+	for range 2 {
+		err = postgresTestManager.Manage(nil, users, WithReport(&report))
+		assert.NoError(t, err, "Error managing users")
+	}
+	assert.False(t, report.Changed(), "Report should show no changes")
+	assert.Equal(t, StatusUnchanged, report.Resources[0].Status, "User should be reported as unchanged")
+}
+
+func TestPostgresManager_PlanIntegration_Prune(t *testing.T) {
+	prunedUser := "pruneduser"
+	prunedDatabase := "pruneddb"
+
+	assert.NoError(t, postgresTestManager.CreateUser(User{Name: prunedUser}), "Error creating user")
+	assert.NoError(t, postgresTestManager.CreateDatabase(Database{Name: prunedDatabase}), "Error creating database")
+
+	// Without pruning nothing should be removed
+	plan, err := postgresTestManager.Plan(nil, nil)
+	assert.NoError(t, err, "Error planning without pruning")
+	assert.True(t, plan.Empty(), "Plan without pruning should be empty")
+
+	// With pruning the user and database should be dropped, but not the excluded user or system users
+	plan, err = postgresTestManager.Plan(nil, nil, WithPrune(username))
+	assert.NoError(t, err, "Error planning with pruning")
+	assert.Contains(t, plan.String(), fmt.Sprintf("DROP ROLE %s", QuoteIdentifier(prunedUser)))
+	assert.Contains(t, plan.String(), fmt.Sprintf("DROP DATABASE %s", QuoteIdentifier(prunedDatabase)))
+	assert.NotContains(t, plan.String(), fmt.Sprintf("DROP ROLE %s", QuoteIdentifier(username)))
+	assert.NotContains(t, plan.String(), fmt.Sprintf("DROP ROLE %s", QuoteIdentifier(adminUser)))
+}
+
+func TestPostgresManager_DropIntegration(t *testing.T) {
+	droppedUser := "droppeduser"
+	droppedDatabase := "droppeddb"
+
+	assert.NoError(t, postgresTestManager.Manage(
+		[]Database{{Name: droppedDatabase, Owner: droppedUser}},
+		[]User{{Name: droppedUser, Password: password, Grants: []Grant{{Database: droppedDatabase, Privileges: []string{"ALL"}}}}},
+	), "Error managing databases and users")
+
+	// Revoking the grant should remove the privileges
+	assert.NoError(t, postgresTestManager.RevokePermissions(User{Name: droppedUser}, []Grant{{Database: droppedDatabase, Privileges: []string{"CREATE"}}}), "Error revoking permissions")
+	set, err := newPostgresCatalog(postgresTestManagerChecker).hasPrivileges(context.Background(), droppedUser, postgresObject{kind: "database", name: droppedDatabase}, []string{"CREATE"}, false)
+	assert.NoError(t, err, "Error checking database privileges")
+	assert.False(t, set, "User still has CREATE privilege after RevokePermissions operation")
+
+	// Dropping the user should reassign the database it owns
+	assert.NoError(t, postgresTestManager.DropUser(droppedUser), "Error dropping user")
+	exists, err := postgresTestManagerChecker.userExists(context.Background(), droppedUser)
+	assert.NoError(t, err, "Error checking if user exists")
+	assert.False(t, exists, "User still exists after DropUser operation")
+	owner, err := postgresTestManagerChecker.getDatabaseOwner(context.Background(), droppedDatabase)
+	assert.NoError(t, err, "Error getting database owner")
+	assert.Equal(t, adminUser, owner, "Database not reassigned after DropUser operation")
+
+	// Dropping the database should work with connections open
+	_, err = testPostgresQuery(adminUser, adminPassword, droppedDatabase, "SELECT 1")
+	assert.NoError(t, err, "Error connecting to database")
+	assert.NoError(t, postgresTestManager.DropDatabase(droppedDatabase, WithTerminateConnections()), "Error dropping database")
+	exists, err = postgresTestManagerChecker.databaseExists(context.Background(), droppedDatabase)
+	assert.NoError(t, err, "Error checking if database exists")
+	assert.False(t, exists, "Database still exists after DropDatabase operation")
+
+	// Dropping things that don't exist should not return an error
+	assert.NoError(t, postgresTestManager.DropUser(droppedUser), "Error dropping user that doesn't exist")
+	assert.NoError(t, postgresTestManager.DropDatabase(droppedDatabase), "Error dropping database that doesn't exist")
+}
+
+func TestPostgresManager_ManageContextIntegration_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// A cancelled context should stop the run before anything is changed
+	err := postgresTestManager.ManageContext(ctx, nil, []User{{Name: "cancelleduser"}})
+	assert.ErrorIs(t, err, context.Canceled, "Expected context cancelled error")
+
+	exists, err := postgresTestManagerChecker.userExists(context.Background(), "cancelleduser")
+	assert.NoError(t, err, "Error checking if user exists")
+	assert.False(t, exists, "User should not exist after cancelled Manage operation")
+}
+
+func TestPostgresManager_ManagerIntegration_Logger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	manager, err := New("postgres",
+		WithHost("localhost"),
+		WithPort(postgresResource.GetPort("5432/tcp")),
+		WithUsername(adminUser),
+		WithPassword(adminPassword),
+		WithLogger(logger),
+	)
+	assert.NoError(t, err, "Error creating manager")
+	assert.NoError(t, manager.Connect(), "Error connecting to database")
+	defer manager.Disconnect()
+
+	err = manager.Manage([]Database{{Name: "loggerdb"}}, []User{{Name: "loggeruser", Password: "loggersecret"}})
+	assert.NoError(t, err, "Error managing databases and users")
+
+	// Every line should be structured, tagged with the engine and free of passwords
+	assert.NotEmpty(t, buf.String(), "Expected log output")
+	assert.NotContains(t, buf.String(), "loggersecret", "Log output should not contain the user password")
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		assert.Contains(t, line, `"engine":"postgres"`, "Log line should include the engine")
+	}
+}
+
+func TestPostgresManager_ManagerIntegration_ContinueOnError(t *testing.T) {
+	users := []User{
+		{Name: "continueuser1", Grants: []Grant{{Database: database, Schema: "public", Table: "missingtable", Privileges: []string{"SELECT"}}}},
+		{Name: "continueuser2", Roles: []string{"missingrole"}},
+		{Name: "continueuser3"},
+	}
+
+	// Without the option the run should stop at the first failure
+	plan, err := postgresTestManager.Plan(nil, users)
+	assert.ErrorIs(t, err, ErrUserNotFound, "Expected missing role error")
+	assert.Nil(t, plan, "Plan should not be returned when planning fails")
+
+	// With the option everything that can be planned is returned along with the errors
+	plan, err = postgresTestManager.Plan(nil, users, WithContinueOnError())
+	assert.ErrorIs(t, err, ErrUserNotFound, "Expected missing role error")
+	var resourceErr *ResourceError
+	assert.ErrorAs(t, err, &resourceErr, "Expected a resource error")
+	assert.Equal(t, "user/continueuser2", resourceErr.Resource)
+
+	// The failed grant should not stop the remaining users being created
+	report, err := postgresTestManager.Apply(plan)
+	assert.ErrorAs(t, err, &resourceErr, "Expected a resource error")
+	assert.Equal(t, "grant/continueuser1/table:mytestdb.public.missingtable", resourceErr.Resource)
+	assert.Equal(t, "42P01", resourceErr.SQLState, "Expected undefined table SQLSTATE")
+	assert.True(t, report.Failed(), "Report should show failures")
+
+	for _, name := range []string{"continueuser1", "continueuser2", "continueuser3"} {
+		exists, err := postgresTestManagerChecker.userExists(context.Background(), name)
+		assert.NoError(t, err, "Error checking if user exists")
+		assert.True(t, exists, "User %s not found after Apply operation", name)
+	}
+}
+
+func TestPostgresManager_RegisterIntegration(t *testing.T) {
+	// An engine registered outside of New should be usable through New
+	Register("postgres-custom", func(options ...func(*Connection)) Manager {
+		return &postgresManager{
+			BaseManager: NewBaseManager("postgres-custom", Connection{Database: "postgres", SSLMode: "disable"}, options...),
+		}
+	})
+	assert.Contains(t, Engines(), "postgres-custom")
+
+	manager, err := New("postgres-custom",
+		WithHost("localhost"),
+		WithPort(postgresResource.GetPort("5432/tcp")),
+		WithUsername(adminUser),
+		WithPassword(adminPassword),
+	)
+	assert.NoError(t, err, "Error creating manager")
+	assert.NoError(t, manager.Connect(), "Error connecting to database")
+	assert.NoError(t, manager.Disconnect(), "Error disconnecting from database")
+
+	// Registering the same name twice should panic
+	assert.Panics(t, func() { Register("postgres", newPostgresManager) })
+
+	_, err = New("unknown")
+	assert.ErrorIs(t, err, ErrUnsupportedEngine)
+}
+
+func TestPostgresManager_ExportIntegration(t *testing.T) {
+	exportedUser := "exporteduser"
+	exportedDatabase := "exporteddb"
+
+	assert.NoError(t, postgresTestManager.Manage(
+		[]Database{{
+			Name:  exportedDatabase,
+			Owner: exportedUser,
+			DefaultPrivileges: []DefaultPrivilege{
+				{Role: exportedUser, Schema: "public", Grant: []string{"SELECT"}, On: "TABLES", To: username},
+			},
+		}},
+		[]User{{
+			Name:     exportedUser,
+			Password: password,
+			Options:  UserOptions{CreateDatabase: Bool(true), Inherit: Bool(true)},
+			Grants:   []Grant{{Database: database, Privileges: []string{"CONNECT"}, WithGrant: true}},
+			Roles:    []string{"myrole"},
+		}},
+	), "Error managing databases and users")
+
+	databases, users, err := postgresTestManager.Export()
+	assert.NoError(t, err, "Error exporting databases and users")
+
+	assert.Contains(t, databases, Database{
+		Name:  exportedDatabase,
+		Owner: exportedUser,
+		DefaultPrivileges: []DefaultPrivilege{
+			{Role: exportedUser, Schema: "public", Grant: []string{"SELECT"}, On: "TABLES", To: username},
+		},
+	})
+	assert.Contains(t, users, User{
+		Name:    exportedUser,
+		Options: UserOptions{Login: Bool(true), CreateDatabase: Bool(true)},
+		Grants:  []Grant{{Database: database, Privileges: []string{"CONNECT"}, WithGrant: true}},
+		Roles:   []string{"myrole"},
+	})
+
+	// The exported configuration should match the server and re-apply cleanly
+	report, err := postgresTestManager.Diff(databases, users)
+	assert.NoError(t, err, "Error comparing exported databases and users")
+	assert.False(t, report.HasDrift(), "Exported configuration should have no drift: %s", report)
+	assert.NoError(t, postgresTestManager.Manage(databases, users), "Error managing exported databases and users")
+}
+
+func TestPostgresManager_ManagerIntegration_Quoting(t *testing.T) {
+	quotedUser := "quoted'user"
+	quotedPassword := "it's-a-secret"
+	quotedDatabase := "Quoted'Db"
+
+	// Names and passwords with quotes and mixed case should be quoted, not break the statements
+	databases := []Database{{
+		Name:              quotedDatabase,
+		DefaultPrivileges: []DefaultPrivilege{{Schema: "public", Grant: []string{"select"}, On: "tables", To: quotedUser}},
+	}}
+	users := []User{{Name: quotedUser, Password: quotedPassword, Grants: []Grant{{Database: quotedDatabase, Privileges: []string{"connect"}}}}}
+
+	plan, err := postgresTestManager.Plan(databases, users)
+	assert.NoError(t, err)
+	assert.NotContains(t, plan.String(), quotedPassword, "Plan should not contain passwords")
+	assert.Contains(t, plan.String(), "PASSWORD '********'")
+
+	_, err = postgresTestManager.Apply(plan)
+	assert.NoError(t, err, "Error applying plan")
+
+	_, err = testPostgresQuery(quotedUser, quotedPassword, quotedDatabase, "SELECT 1")
+	assert.NoError(t, err, "User should be able to connect to the database")
+
+	// Nothing should have drifted, which checks the privilege lookups handle the names too
+	report, err := postgresTestManager.Diff(databases, users)
+	assert.NoError(t, err)
+	assert.False(t, report.HasDrift(), "There should be no drift: %s", report)
+
+	// Privileges that aren't allowed should be rejected before they're added to a statement
+	err = postgresTestManager.RevokePermissions(User{Name: quotedUser}, []Grant{{Database: quotedDatabase, Privileges: []string{"CONNECT; DROP DATABASE postgres"}}})
+	assert.ErrorIs(t, err, ErrInvalidGrant)
+
+	assert.NoError(t, postgresTestManager.DropDatabase(quotedDatabase))
+	assert.NoError(t, postgresTestManager.DropUser(quotedUser))
+}
+
+func TestPostgresManager_ManagerIntegration_ConnectionCache(t *testing.T) {
+	manager := newPostgresManager(
+		WithHost("localhost"),
+		WithPort(postgresResource.GetPort("5432/tcp")),
+		WithUsername(adminUser),
+		WithPassword(adminPassword),
+	).(*postgresManager)
+	assert.NoError(t, manager.Connect(), "Error connecting to database")
+
+	cachedDatabase := "cacheddb"
+	databases := []Database{{Name: cachedDatabase, DefaultPrivileges: []DefaultPrivilege{{Schema: "public", Grant: []string{"SELECT"}, On: "TABLES", To: username}}}}
+	users := []User{
+		{Name: "cacheduser1", Grants: []Grant{{Database: cachedDatabase, Schema: "public", Privileges: []string{"USAGE"}}}},
+		{Name: "cacheduser2", Grants: []Grant{{Database: cachedDatabase, Schema: "public", Privileges: []string{"USAGE"}}}},
+	}
+
+	// Every grant and default privilege in the database should share one connection
+	assert.NoError(t, manager.Manage(databases, users), "Error managing databases and users")
+	assert.Len(t, manager.databases, 1, "There should be one cached connection")
+	cached := manager.databases[cachedDatabase]
+	assert.NotNil(t, cached, "The connection to the database should be cached")
+
+	assert.NoError(t, manager.Manage(databases, users), "Error managing databases and users")
+	assert.Same(t, cached, manager.databases[cachedDatabase], "The cached connection should be reused")
+
+	// Dropping the database should close the cached connection first
+	assert.NoError(t, manager.DropDatabase(cachedDatabase), "Error dropping database")
+	assert.Empty(t, manager.databases, "The cached connection should be closed")
+
+	assert.NoError(t, manager.Manage(databases, users), "Error managing databases and users")
+	assert.NoError(t, manager.Disconnect(), "Error disconnecting")
+	assert.Empty(t, manager.databases, "Disconnect should close every cached connection")
+}
+
+func TestPostgresManager_PlanIntegration_Catalog(t *testing.T) {
+	catalogDatabase := "catalogdb"
+	catalogRole := "catalogrole"
+	catalogUser := "cataloguser"
+
+	databases := []Database{{Name: catalogDatabase, Owner: catalogRole}}
+	users := []User{
+		{Name: catalogRole, Grants: []Grant{{Database: catalogDatabase, Schema: "public", Privileges: []string{"USAGE"}}}},
+		{
+			Name:    catalogUser,
+			Options: UserOptions{Login: Bool(true)},
+			Roles:   []string{catalogRole},
+			Grants: []Grant{
+				{Database: catalogDatabase, Privileges: []string{"CONNECT", "TEMP"}},
+				{Database: catalogDatabase, Schema: "public", Privileges: []string{"ALL"}},
+			},
+		},
+	}
+	assert.NoError(t, postgresTestManager.Manage(databases, users), "Error managing databases and users")
+
+	// Planning again should find everything in the snapshot and have nothing left to do
+	plan, err := postgresTestManager.Plan(databases, users)
+	assert.NoError(t, err, "Error planning databases and users")
+	assert.True(t, plan.Empty(), "Plan should not contain changes: %s", plan)
+
+	report, err := postgresTestManager.Diff(databases, users)
+	assert.NoError(t, err, "Error comparing databases and users")
+	assert.False(t, report.HasDrift(), "There should be no drift")
+
+	// Privileges inherited from a role count towards the user's
+	catalog := newPostgresCatalog(postgresTestManagerChecker)
+	set, err := catalog.hasPrivileges(context.Background(), catalogUser, postgresObject{kind: "schema", database: catalogDatabase, name: "public"}, []string{"USAGE"}, false)
+	assert.NoError(t, err, "Error checking privileges")
+	assert.True(t, set, "User should inherit USAGE from the role")
+
+	// Without the role the owner's privileges aren't inherited so they need granting
+	plan, err = postgresTestManager.Plan(databases, []User{{Name: catalogUser, Grants: []Grant{{Database: catalogDatabase, Privileges: []string{"CREATE"}}}}})
+	assert.NoError(t, err, "Error planning databases and users")
+	assert.Contains(t, plan.String(), "grant/"+catalogUser+"/database:"+catalogDatabase, "Missing privileges should be planned")
+	assert.Contains(t, plan.String(), "role/"+catalogUser+"/"+catalogRole, "The role should be removed")
+}
+
+func TestPostgresManager_ManagerIntegration_Concurrency(t *testing.T) {
+	var databases []Database
+	var grants []Grant
+	for i := range 4 {
+		name := fmt.Sprintf("concurrentdb%d", i)
+		databases = append(databases, Database{Name: name, DefaultPrivileges: []DefaultPrivilege{{Schema: "public", Grant: []string{"SELECT"}, On: "TABLES", To: "concurrentuser"}}})
+		grants = append(grants, Grant{Database: name, Schema: "public", Privileges: []string{"USAGE"}})
+	}
+	users := []User{{Name: "concurrentuser", Grants: grants}}
+
+	// The plan should be the same whether the databases are planned one at a time or in parallel
+	sequential, err := postgresTestManager.Plan(databases, users)
+	assert.NoError(t, err, "Error planning databases and users")
+	parallel, err := postgresTestManager.Plan(databases, users, WithConcurrency(4))
+	assert.NoError(t, err, "Error planning databases and users")
+	assert.Equal(t, sequential.String(), parallel.String(), "Plans should match")
+
+	// The report should be in the order of the plan
+	report, err := postgresTestManager.Apply(parallel)
+	assert.NoError(t, err, "Error applying plan")
+	var resources []string
+	for _, change := range parallel.Changes {
+		if !slices.Contains(resources, change.Resource) {
+			resources = append(resources, change.Resource)
+		}
+	}
+	for i, resource := range resources {
+		assert.Equal(t, resource, report.Resources[i].Resource, "Report should be in plan order")
+	}
+
+	// Running again should find everything in place
+	assert.NoError(t, postgresTestManager.Manage(databases, users, WithConcurrency(4)), "Error managing databases and users")
+	for _, grant := range grants {
+		exists, err := postgresTestManagerChecker.databaseExists(context.Background(), grant.Database)
+		assert.NoError(t, err, "Error checking if database exists")
+		assert.True(t, exists, "Database does not exist after Manage operation")
+	}
+}
+
+func TestPostgresManager_ManagerIntegration_Lock(t *testing.T) {
+	// Hold the lock as if another run was in progress
+	unlock, err := postgresTestManagerChecker.lock(context.Background(), time.Second)
+	assert.NoError(t, err, "Error taking lock")
+
+	err = postgresTestManager.Manage(nil, []User{{Name: "lockeduser"}}, WithLockTimeout(100*time.Millisecond))
+	assert.ErrorIs(t, err, ErrLocked, "Expected lock error")
+	exists, err := postgresTestManagerChecker.userExists(context.Background(), "lockeduser")
+	assert.NoError(t, err, "Error checking if user exists")
+	assert.False(t, exists, "User should not exist when the lock is held")
+
+	// Applying a plan takes the same lock
+	plan, err := postgresTestManager.Plan(nil, []User{{Name: "lockeduser"}}, WithLockTimeout(100*time.Millisecond))
+	assert.NoError(t, err, "Error planning users")
+	_, err = postgresTestManager.Apply(plan)
+	assert.ErrorIs(t, err, ErrLocked, "Expected lock error")
+
+	// Once released the run can go ahead and releases the lock again when it's done
+	assert.NoError(t, unlock(context.Background()), "Error releasing lock")
+	assert.NoError(t, postgresTestManager.Manage(nil, []User{{Name: "lockeduser"}}, WithLockTimeout(time.Second)), "Error managing users")
+	assert.NoError(t, postgresTestManager.Manage(nil, []User{{Name: "lockeduser"}}, WithLockTimeout(time.Second)), "Lock should be released after Manage")
+
+	// Runs connected to different databases take the same lock
+	other := postgresTestManagerChecker.forDatabase("template1")
+	assert.NoError(t, other.Connect(), "Error connecting to database")
+	unlock, err = other.lock(context.Background(), time.Second)
+	assert.NoError(t, err, "Error taking lock")
+	err = postgresTestManager.Manage(nil, []User{{Name: "lockeduser"}}, WithLockTimeout(100*time.Millisecond))
+	assert.ErrorIs(t, err, ErrLocked, "Expected lock error")
+	assert.NoError(t, unlock(context.Background()), "Error releasing lock")
+	assert.NoError(t, other.Disconnect(), "Error disconnecting")
+}
+
+func TestPostgresManager_ManagerIntegration_ColumnAndRoutineGrants(t *testing.T) {
+	routineUser := "routineuser"
+	routineDatabase := "routinedb"
+	assert.NoError(t, postgresTestManager.CreateDatabase(Database{Name: routineDatabase}))
+
+	_, err := testPostgresQuery(adminUser, adminPassword, routineDatabase, "CREATE TABLE public.mytable (a int, b int, c int)")
+	assert.NoError(t, err)
+	_, err = testPostgresQuery(adminUser, adminPassword, routineDatabase, "CREATE PROCEDURE public.myproc() LANGUAGE SQL AS 'SELECT 1'")
+	assert.NoError(t, err)
+	_, err = testPostgresQuery(adminUser, adminPassword, routineDatabase, "REVOKE EXECUTE ON PROCEDURE public.myproc() FROM PUBLIC")
+	assert.NoError(t, err)
+
+	users := []User{{Name: routineUser, Grants: []Grant{
+		{Database: routineDatabase, Schema: "public", Table: "mytable", Columns: []string{"a", "b"}, Privileges: []string{"SELECT", "UPDATE"}},
+		{Database: routineDatabase, Schema: "public", Procedure: "myproc", Privileges: []string{"EXECUTE"}},
+	}}}
+	assert.NoError(t, postgresTestManager.Manage(nil, users))
+
+	db, err := postgresTestManagerChecker.database(context.Background(), routineDatabase)
+	assert.NoError(t, err)
+	var hasColumns, hasTable, hasProcedure bool
+	err = db.db.QueryRow(`SELECT has_column_privilege($1, 'public.mytable', 'b', 'UPDATE'), has_table_privilege($1, 'public.mytable', 'UPDATE'),
+		has_function_privilege($1, 'public.myproc()', 'EXECUTE')`, routineUser).Scan(&hasColumns, &hasTable, &hasProcedure)
+	assert.NoError(t, err)
+	assert.True(t, hasColumns)
+	assert.False(t, hasTable, "Only the columns should be granted")
+	assert.True(t, hasProcedure)
+
+	// Planning again should find the column and routine privileges and have nothing left to do
+	plan, err := postgresTestManager.Plan(nil, users)
+	assert.NoError(t, err)
+	assert.True(t, plan.Empty(), "Plan should not contain changes: %s", plan)
+
+	// Columns that haven't been granted are still planned
+	users[0].Grants[0].Columns = append(users[0].Grants[0].Columns, "c")
+	plan, err = postgresTestManager.Plan(nil, users)
+	assert.NoError(t, err)
+	assert.Contains(t, plan.String(), `GRANT SELECT ("a", "b", "c"), UPDATE ("a", "b", "c") ON TABLE "public"."mytable" TO "routineuser"`)
+
+	assert.NoError(t, postgresTestManager.DropUser(routineUser))
+	assert.NoError(t, postgresTestManager.DropDatabase(routineDatabase))
+}
+
+func TestPostgresManager_DiffIntegration_ReferencedRoles(t *testing.T) {
+	diffRole := "diffrole"
+	diffOwner := "diffowner"
+	diffUser := "diffuser"
+	assert.NoError(t, postgresTestManager.CreateUser(User{Name: diffRole}), "Error creating role")
+	assert.NoError(t, postgresTestManager.CreateUser(User{Name: diffOwner}), "Error creating role")
+
+	databases := []Database{{Name: "diffdb", Owner: diffOwner}}
+	users := []User{{Name: diffUser, Roles: []string{diffRole}}}
+	assert.NoError(t, postgresTestManager.Manage(databases, users), "Error managing databases and users")
+
+	// Roles that are only referred to by the config aren't extra users
+	report, err := postgresTestManager.Diff(databases, users)
+	assert.NoError(t, err, "Error comparing databases and users")
+	assert.NotContains(t, report.String(), "user/"+diffRole)
+	assert.NotContains(t, report.String(), "user/"+diffOwner)
+
+	report, err = postgresTestManager.Diff(nil, users)
+	assert.NoError(t, err, "Error comparing databases and users")
+	assert.Contains(t, report.String(), "user/"+diffOwner, "A role the config no longer refers to is extra")
+}
+
 func TestPostgresManager_DisconnectIntegration(t *testing.T) {
 	// Test disconnection
 	assert.NoError(t, postgresTestManager.Disconnect(), "Error disconnecting from database")
@@ -1,45 +1,108 @@
 package dbmanager
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
 	"strings"
 )
 
 // CreateUser creates and manages a user. It will create the user if it doesn't already exist.
 func (m *postgresManager) CreateUser(user User) error {
+	return m.CreateUserContext(context.Background(), user)
+}
+
+// CreateUserContext is like CreateUser but uses the provided context for all queries.
+func (m *postgresManager) CreateUserContext(ctx context.Context, user User) error {
+	if err := user.Validate(m.engine); err != nil {
+		return err
+	}
+
+	plan := NewPlan()
+	if err := m.planUser(ctx, plan, user); err != nil {
+		return err
+	}
+
+	_, err := m.ApplyContext(ctx, plan)
+	return err
+}
+
+// planUser adds the changes needed to create or update a user to the plan.
+func (m *postgresManager) planUser(ctx context.Context, plan *Plan, user User) error {
 	// If the user already exists, we'll update it, otherwise we'll create it
-	exists, err := m.userExists(user.Name)
+	exists, err := m.catalog(plan).userExists(ctx, user.Name)
 	if err != nil {
 		return err
 	}
 	if !exists {
-		if _, err := m.createUser(user); err != nil {
-			return err
-		}
-	} else {
-		if _, err := m.updateUser(user); err != nil {
-			return err
-		}
+		plan.Add(m.createUser(user))
+		plan.users[user.Name] = true
+		return nil
+	}
+
+	change, err := m.updateUser(ctx, plan, user)
+	if err != nil {
+		return err
+	}
+	if change != nil {
+		plan.Add(*change)
 	}
 
 	// We can't read back the user's password, so if one is set, we'll just set it again
 	if user.Password != "" {
-		if err := m.setPassword(user.Name, user.Password); err != nil {
-			return err
-		}
+		plan.Add(m.setPassword(user.Name, user.Password))
 	}
 
 	return nil
 }
 
-// createUser creates a new user.
-func (m *postgresManager) createUser(user User) (bool, error) {
+// postgresAttribute is a role attribute managed by one of the user's options.
+type postgresAttribute struct {
+	// name is the option's name in the configuration.
+	name string
+
+	// keyword sets the attribute, prefixing it with NO unsets it.
+	keyword string
+
+	// value is the value in the configuration, nil if it isn't managed.
+	value *bool
+}
+
+// clause returns the keyword that sets the attribute to its value.
+func (a postgresAttribute) clause() string {
+	if *a.value {
+		return a.keyword
+	}
+	return "NO" + a.keyword
+}
+
+// postgresAttributes returns the role attributes for a user's options. A password implies LOGIN unless login
+// is set.
+func postgresAttributes(user User) []postgresAttribute {
+	login := user.Options.Login
+	if login == nil && user.Password != "" {
+		login = Bool(true)
+	}
+
+	return []postgresAttribute{
+		{"login", "LOGIN", login},
+		{"superuser", "SUPERUSER", user.Options.Superuser},
+		{"create_role", "CREATEROLE", user.Options.CreateRole},
+		{"create_database", "CREATEDB", user.Options.CreateDatabase},
+		{"inherit", "INHERIT", user.Options.Inherit},
+		{"replication", "REPLICATION", user.Options.Replication},
+		{"bypass_rls", "BYPASSRLS", user.Options.BypassRLS},
+	}
+}
+
+// createUser returns the change that creates a new user. Options that aren't set are left to the server's
+// defaults.
+func (m *postgresManager) createUser(user User) Change {
+	attributes := postgresAttributes(user)
 	query := "CREATE"
 
-	// If the user has a password or explicitly set login to true, we'll create a user, otherwise we'll create a role
-	if user.Options.Login || user.Password != "" {
+	// If the user can log in we'll create a user, otherwise we'll create a role
+	if login := attributes[0].value; login != nil && *login {
 		query += " USER"
 	} else {
 		query += " ROLE"
@@ -53,65 +116,89 @@ func (m *postgresManager) createUser(user User) (bool, error) {
 		query += " " + option
 	}
 
-	if user.Password != "" {
-		addOption(fmt.Sprintf("LOGIN PASSWORD '%s'", user.Password))
-	}
-
-	if user.Options.Superuser {
-		addOption("SUPERUSER")
-	}
-
-	if user.Options.CreateRole {
-		addOption("CREATEROLE")
-	}
-
-	if user.Options.CreateDatabase {
-		addOption("CREATEDB")
-	}
-
-	if user.Options.Inherit {
-		addOption("INHERIT")
+	for _, attribute := range attributes {
+		if attribute.value != nil {
+			addOption(attribute.clause())
+		}
 	}
 
-	if user.Options.Replication {
-		addOption("REPLICATION")
+	if user.Password != "" {
+		addOption("PASSWORD " + QuoteLiteral(user.Password))
 	}
 
-	if user.Options.BypassRLS {
-		addOption("BYPASSRLS")
+	change := Change{
+		Resource: resource("user", user.Name),
+		Action:   ActionCreate,
+		SQL:      query,
+		Reason:   "user does not exist",
 	}
-
-	if _, err := m.db.Exec(query); err != nil {
-		return false, err
+	if user.Password != "" {
+		change = change.withSecret(QuoteLiteral(user.Password))
 	}
+	return change
+}
 
-	log.Printf("Created user: %s\n", user.Name)
+// postgresUserColumns are the columns of pg_roles read by scanUser.
+const postgresUserColumns = "rolname, rolsuper, rolcreaterole, rolcreatedb, rolcanlogin, rolinherit, rolreplication, rolbypassrls"
 
-	return true, nil
+// getUser returns the user with the specified name.
+func (m *postgresManager) getUser(ctx context.Context, name string) (User, error) {
+	query := "SELECT " + postgresUserColumns + " FROM pg_roles WHERE rolname = $1"
+	return scanUser(m.db.QueryRowContext(ctx, query, name))
 }
 
-// getUser returns the user with the specified name.
-func (m *postgresManager) getUser(name string) (User, error) {
+// scanUser reads a user from a row of postgresUserColumns.
+func scanUser(row interface{ Scan(...any) error }) (User, error) {
 	var user User
-	query := "SELECT rolname, rolsuper, rolcreaterole, rolcreatedb, rolcanlogin, rolinherit, rolreplication, rolbypassrls FROM pg_roles WHERE rolname = $1"
-	err := m.db.QueryRow(query, name).Scan(&user.Name, &user.Options.Superuser, &user.Options.CreateRole, &user.Options.CreateDatabase, &user.Options.Login, &user.Options.Inherit, &user.Options.Replication, &user.Options.BypassRLS)
+	var superuser, createRole, createDatabase, login, inherit, replication, bypassRLS bool
+	err := row.Scan(&user.Name, &superuser, &createRole, &createDatabase, &login, &inherit, &replication, &bypassRLS)
 	if err != nil {
 		return User{}, err
 	}
+
+	// Every option is set as they're all read from the server
+	user.Options = UserOptions{
+		Login:          &login,
+		Superuser:      &superuser,
+		CreateDatabase: &createDatabase,
+		CreateRole:     &createRole,
+		Inherit:        &inherit,
+		Replication:    &replication,
+		BypassRLS:      &bypassRLS,
+	}
 	return user, nil
 }
 
-// setPassword sets the password for the specified user.
-func (m *postgresManager) setPassword(name, password string) error {
-	query := fmt.Sprintf("ALTER USER %s WITH LOGIN PASSWORD '%s'", QuoteIdentifier(name), password)
-	if _, err := m.db.Exec(query); err != nil {
+// requireUser returns ErrUserNotFound if a user or role doesn't exist and won't be created by the plan. An
+// empty name is ignored.
+func (m *postgresManager) requireUser(ctx context.Context, plan *Plan, name string) error {
+	if name == "" || plan.users[name] {
+		return nil
+	}
+
+	if exists, err := m.catalog(plan).userExists(ctx, name); err != nil {
 		return err
+	} else if !exists {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, name)
 	}
+
 	return nil
 }
 
-// updateUser updates the specified user.
-func (m *postgresManager) updateUser(user User) (bool, error) {
+// setPassword returns the change that sets the password for the specified user.
+func (m *postgresManager) setPassword(name, password string) Change {
+	return Change{
+		Resource: resource("user", name),
+		Action:   ActionUpdate,
+		SQL:      fmt.Sprintf("ALTER USER %s WITH PASSWORD %s", QuoteIdentifier(name), QuoteLiteral(password)),
+		Reason:   "password is set in config and can't be compared",
+		Reset:    true,
+	}.withSecret(QuoteLiteral(password))
+}
+
+// updateUser returns the change that updates the specified user, or nil if the user's options
+// already match. Options that aren't set are left alone.
+func (m *postgresManager) updateUser(ctx context.Context, plan *Plan, user User) (*Change, error) {
 	query := fmt.Sprintf("ALTER USER %s", QuoteIdentifier(user.Name))
 
 	addOption := func(option string) {
@@ -122,83 +209,83 @@ func (m *postgresManager) updateUser(user User) (bool, error) {
 	}
 
 	// Compare with real user
-	realUser, err := m.getUser(user.Name)
+	realUser, _, err := m.catalog(plan).user(ctx, user.Name)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
-	if user.Options.Superuser != realUser.Options.Superuser {
-		if user.Options.Superuser {
-			addOption("SUPERUSER")
-		} else {
-			addOption("NOSUPERUSER")
+	// Only the options that are set are managed
+	actual := postgresAttributes(realUser)
+	for i, attribute := range postgresAttributes(user) {
+		if attribute.value != nil && *attribute.value != *actual[i].value {
+			addOption(attribute.clause())
 		}
 	}
 
-	if user.Options.CreateRole != realUser.Options.CreateRole {
-		if user.Options.CreateRole {
-			addOption("CREATEROLE")
-		} else {
-			addOption("NOCREATEROLE")
-		}
+	if strings.HasSuffix(query, QuoteIdentifier(user.Name)) {
+		m.Logger().Debug("User is up to date, skipping", "user", user.Name)
+		plan.unchanged(resource("user", user.Name), "user options match config")
+		return nil, nil
 	}
 
-	if user.Options.CreateDatabase != realUser.Options.CreateDatabase {
-		if user.Options.CreateDatabase {
-			addOption("CREATEDB")
-		} else {
-			addOption("NOCREATEDB")
-		}
-	}
+	return &Change{
+		Resource: resource("user", user.Name),
+		Action:   ActionUpdate,
+		SQL:      query,
+		Reason:   "user options differ from config",
+	}, nil
+}
 
-	if user.Options.Login != realUser.Options.Login {
-		if user.Options.Login {
-			addOption("LOGIN")
-		} else {
-			addOption("NOLOGIN")
-		}
+// userExists checks if the specified user exists.
+func (m *postgresManager) userExists(ctx context.Context, name string) (bool, error) {
+	var exists bool
+	query := "SELECT 1 FROM pg_roles WHERE rolname = $1 LIMIT 1"
+	err := m.db.QueryRowContext(ctx, query, name).Scan(&exists)
+	if err != nil && err != sql.ErrNoRows {
+		return false, err
 	}
+	return exists, nil
+}
 
-	if user.Options.Inherit != realUser.Options.Inherit {
-		if user.Options.Inherit {
-			addOption("INHERIT")
-		} else {
-			addOption("NOINHERIT")
-		}
-	}
+// DropUser drops a user if it exists. Objects owned by the user in every database are reassigned to the user
+// we're connected as, or the role set with WithReassignOwnedTo, before the user is dropped.
+func (m *postgresManager) DropUser(name string, options ...DropOption) error {
+	return m.DropUserContext(context.Background(), name, options...)
+}
 
-	if user.Options.Replication != realUser.Options.Replication {
-		if user.Options.Replication {
-			addOption("REPLICATION")
-		} else {
-			addOption("NOREPLICATION")
-		}
+// DropUserContext is like DropUser but uses the provided context for all queries.
+func (m *postgresManager) DropUserContext(ctx context.Context, name string, options ...DropOption) error {
+	plan := NewPlan()
+	if err := m.planDropUser(ctx, plan, name, NewDropSettings(options...)); err != nil {
+		return err
 	}
 
-	if user.Options.BypassRLS != realUser.Options.BypassRLS {
-		if user.Options.BypassRLS {
-			addOption("BYPASSRLS")
-		} else {
-			addOption("NOBYPASSRLS")
-		}
-	}
+	_, err := m.ApplyContext(ctx, plan)
+	return err
+}
 
-	if _, err := m.db.Exec(query); err != nil {
-		return false, err
+// planDropUser adds the changes that drop a user to the plan.
+func (m *postgresManager) planDropUser(ctx context.Context, plan *Plan, name string, settings DropSettings) error {
+	if exists, err := m.userExists(ctx, name); err != nil {
+		return err
+	} else if !exists {
+		m.Logger().Debug("User does not exist, skipping", "user", name)
+		return nil
 	}
 
-	log.Printf("Updated user: %s\n", user.Name)
-
-	return true, nil
-}
+	reassignTo := m.connection.Username
+	if settings.ReassignOwnedTo != "" {
+		reassignTo = settings.ReassignOwnedTo
+	}
+	if settings.DropOwnedObjects {
+		reassignTo = ""
+	}
 
-// userExists checks if the specified user exists.
-func (m *postgresManager) userExists(name string) (bool, error) {
-	var exists bool
-	query := "SELECT 1 FROM pg_roles WHERE rolname = $1 LIMIT 1"
-	err := m.db.QueryRow(query, name).Scan(&exists)
-	if err != nil && err != sql.ErrNoRows {
-		return false, err
+	// Owned objects need to be reassigned or dropped in every database
+	databases, err := m.listDatabases(ctx)
+	if err != nil {
+		return err
 	}
-	return exists, nil
+
+	return m.dropUser(ctx, plan, name, databases, reassignTo)
 }
@@ -0,0 +1,42 @@
+package dbmanager
+
+import (
+	"slices"
+	"sync"
+)
+
+// Factory creates a Manager for an engine with the provided connection options applied.
+type Factory func(options ...func(*Connection)) Manager
+
+var (
+	enginesMu sync.RWMutex
+	engines   = map[string]Factory{}
+)
+
+// Register makes an engine available to New under the provided name. Engines usually register themselves
+// in an init function. If Register is called twice with the same name or if factory is nil, it panics.
+func Register(name string, factory Factory) {
+	enginesMu.Lock()
+	defer enginesMu.Unlock()
+
+	if factory == nil {
+		panic("dbmanager: Register factory is nil")
+	}
+	if _, dup := engines[name]; dup {
+		panic("dbmanager: Register called twice for engine " + name)
+	}
+	engines[name] = factory
+}
+
+// Engines returns a sorted list of the names of the registered engines.
+func Engines() []string {
+	enginesMu.RLock()
+	defer enginesMu.RUnlock()
+
+	names := make([]string, 0, len(engines))
+	for name := range engines {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,263 @@
+package dbmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// cleanupTimeout is how long cleanup changes are given to run after a change fails.
+const cleanupTimeout = 30 * time.Second
+
+// ResourceStatus describes what happened to a resource when a plan was applied.
+type ResourceStatus string
+
+const (
+	StatusCreated   ResourceStatus = "created"
+	StatusUpdated   ResourceStatus = "updated"
+	StatusDropped   ResourceStatus = "dropped"
+	StatusUnchanged ResourceStatus = "unchanged"
+	StatusSkipped   ResourceStatus = "skipped"
+	StatusFailed    ResourceStatus = "failed"
+)
+
+// ResourceReport describes what happened to a single resource when a plan was applied.
+type ResourceReport struct {
+	// Resource identifies the object, e.g. "user/myuser" or "database/mydb".
+	Resource string `json:"resource"`
+
+	// Status is what happened to the resource.
+	Status ResourceStatus `json:"status"`
+
+	// Statements lists the statements that were run against the resource, with any secrets masked.
+	Statements []string `json:"statements,omitempty"`
+
+	// Reason explains why a resource was unchanged or skipped.
+	Reason string `json:"reason,omitempty"`
+
+	// Error is the error returned when a statement failed.
+	Error string `json:"error,omitempty"`
+}
+
+// Report lists every resource touched when a plan was applied. Resources that were changed are listed first,
+// in the order they were changed, followed by the resources that needed no changes.
+type Report struct {
+	Resources []ResourceReport `json:"resources"`
+}
+
+// newReport returns an empty report.
+func newReport() *Report {
+	return &Report{Resources: []ResourceReport{}}
+}
+
+// Changed returns true if any resource was created, updated or dropped.
+func (r *Report) Changed() bool {
+	for _, resource := range r.Resources {
+		switch resource.Status {
+		case StatusCreated, StatusUpdated, StatusDropped:
+			return true
+		}
+	}
+	return false
+}
+
+// Failed returns true if any statement failed.
+func (r *Report) Failed() bool {
+	for _, resource := range r.Resources {
+		if resource.Status == StatusFailed {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns a human readable version of the report, one resource per line.
+func (r *Report) String() string {
+	var sb strings.Builder
+	for _, resource := range r.Resources {
+		fmt.Fprintf(&sb, "%s %s", resource.Status, resource.Resource)
+		switch {
+		case resource.Error != "":
+			fmt.Fprintf(&sb, ": %s", resource.Error)
+		case resource.Reason != "":
+			fmt.Fprintf(&sb, " (%s)", resource.Reason)
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// resource returns the report for a resource, adding it if it hasn't been touched yet.
+func (r *Report) resource(name string) *ResourceReport {
+	for i := range r.Resources {
+		if r.Resources[i].Resource == name {
+			return &r.Resources[i]
+		}
+	}
+	r.Resources = append(r.Resources, ResourceReport{Resource: name})
+	return &r.Resources[len(r.Resources)-1]
+}
+
+// applied records a change that was run successfully.
+func (r *Report) applied(change Change) {
+	resource := r.resource(change.Resource)
+	resource.Statements = append(resource.Statements, change.displaySQL())
+
+	switch {
+	case resource.Status == StatusFailed:
+	case change.Reset:
+		if resource.Status == "" {
+			resource.Status = StatusUnchanged
+			resource.Reason = change.Reason
+		}
+	case change.Action == ActionCreate:
+		resource.Status = StatusCreated
+	case change.Action == ActionDrop:
+		resource.Status = StatusDropped
+	case resource.Status != StatusCreated && resource.Status != StatusDropped:
+		resource.Status, resource.Reason = StatusUpdated, ""
+	}
+}
+
+// failed records a change that returned an error.
+func (r *Report) failed(change Change, err error) {
+	resource := r.resource(change.Resource)
+	resource.Statements = append(resource.Statements, change.displaySQL())
+	resource.Status = StatusFailed
+	resource.Error = err.Error()
+}
+
+// note records a resource that needed no changes, unless changes have already been recorded for it.
+func (r *Report) note(status ResourceStatus, name, reason string) {
+	resource := r.resource(name)
+	if resource.Status == "" {
+		resource.Status = status
+		resource.Reason = reason
+	}
+}
+
+// ApplyPlan runs the changes in the plan in order using exec and returns a report of what was done. If a
+// change fails the remaining cleanup changes are still run, so temporary changes aren't left behind, and
+// every other remaining change is reported as skipped. If the plan continues on error every change is run
+// and all failures are returned together. Engines use this to implement Manager.ApplyContext.
+//
+// When the plan was created with WithConcurrency, consecutive changes that are run in a database are run
+// for up to that many databases at a time, so exec must be safe to call concurrently for different
+// databases. The changes for each database are still run in order and the report and errors are in the
+// same order as the plan.
+func ApplyPlan(ctx context.Context, plan *Plan, exec func(ctx context.Context, change Change) error) (*Report, error) {
+	report := newReport()
+	var errs []error
+
+	for _, change := range plan.Changes {
+		if change.masked {
+			return report, fmt.Errorf("%w: %s", ErrRedactedPlan, change.Resource)
+		}
+	}
+
+	for start := 0; start < len(plan.Changes); {
+		end := start + 1
+		if plan.concurrency > 1 && plan.Changes[start].Database != "" {
+			for end < len(plan.Changes) && plan.Changes[end].Database != "" {
+				end++
+			}
+		}
+
+		results := applyChanges(ctx, plan, plan.Changes[start:end], exec)
+
+		failed := false
+		for i, err := range results {
+			change := plan.Changes[start+i]
+			switch {
+			case errors.Is(err, errSkipped):
+				report.note(StatusSkipped, change.Resource, "an earlier change failed")
+			case err != nil:
+				report.failed(change, err)
+				errs = append(errs, newResourceError(change.Resource, change.displaySQL(), err))
+				failed = true
+			default:
+				report.applied(change)
+			}
+		}
+		start = end
+
+		if !failed || (plan.continueOnError && ctx.Err() == nil) {
+			continue
+		}
+
+		// Cleanup changes are run even if the context has been cancelled, but with their own timeout
+		cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cleanupTimeout)
+		defer cancel()
+
+		for _, remaining := range plan.Changes[start:] {
+			if !remaining.Cleanup {
+				report.note(StatusSkipped, remaining.Resource, "an earlier change failed")
+				continue
+			}
+			if err := exec(cleanupCtx, remaining); err != nil {
+				report.failed(remaining, err)
+				errs = append(errs, newResourceError(remaining.Resource, remaining.displaySQL(), err))
+				continue
+			}
+			report.applied(remaining)
+		}
+		report.addNotes(plan)
+
+		return report, errors.Join(errs...)
+	}
+	report.addNotes(plan)
+
+	return report, errors.Join(errs...)
+}
+
+// errSkipped is returned by applyChanges for changes that weren't run because an earlier change for the
+// same database failed.
+var errSkipped = errors.New("skipped")
+
+// applyChanges runs the changes using exec, grouped by database with up to the plan's concurrency groups
+// running at the same time, and returns the result of each change. Once a change in a group fails the rest
+// of the group is skipped, apart from cleanup changes, unless the plan continues on error.
+func applyChanges(ctx context.Context, plan *Plan, changes []Change, exec func(ctx context.Context, change Change) error) []error {
+	var databases []string
+	groups := map[string][]int{}
+	for i, change := range changes {
+		if _, ok := groups[change.Database]; !ok {
+			databases = append(databases, change.Database)
+		}
+		groups[change.Database] = append(groups[change.Database], i)
+	}
+
+	results := make([]error, len(changes))
+	forEach(plan.concurrency, len(databases), func(d int) {
+		var cleanupCtx context.Context
+		for _, i := range groups[databases[d]] {
+			if cleanupCtx != nil {
+				if changes[i].Cleanup {
+					results[i] = exec(cleanupCtx, changes[i])
+				} else {
+					results[i] = errSkipped
+				}
+				continue
+			}
+
+			results[i] = exec(ctx, changes[i])
+			if results[i] != nil && (!plan.continueOnError || ctx.Err() != nil) {
+				// Cleanup changes are run even if the context has been cancelled, but with their own timeout
+				var cancel context.CancelFunc
+				cleanupCtx, cancel = context.WithTimeout(context.WithoutCancel(ctx), cleanupTimeout)
+				defer cancel()
+			}
+		}
+	})
+
+	return results
+}
+
+// addNotes records the resources in the plan that needed no changes.
+func (r *Report) addNotes(plan *Plan) {
+	for _, note := range plan.notes {
+		r.note(note.Status, note.Resource, note.Reason)
+	}
+}
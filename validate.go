@@ -0,0 +1,333 @@
+package dbmanager
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+)
+
+// postgresPrivileges lists the privileges that can be granted on each type of object in PostgreSQL.
+var postgresPrivileges = map[string][]string{
+	"parameter": {"SET", "ALTER SYSTEM"},
+	"database":  {"CREATE", "CONNECT", "TEMPORARY", "TEMP"},
+	"schema":    {"USAGE", "CREATE"},
+	"table":     {"SELECT", "INSERT", "UPDATE", "DELETE", "TRUNCATE", "REFERENCES", "TRIGGER", "MAINTAIN"},
+	"sequence":  {"USAGE", "SELECT", "UPDATE"},
+	"column":    {"SELECT", "INSERT", "UPDATE", "REFERENCES"},
+	"function":  {"EXECUTE"},
+	"procedure": {"EXECUTE"},
+}
+
+// Validate checks that the grant can be applied by the engine, e.g. "postgres" or "mysql". If engine is
+// empty only the rules shared by every engine are checked. Every problem found is returned, each wrapping
+// ErrInvalidGrant.
+func (g Grant) Validate(engine string) error {
+	var errs []error
+	invalid := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%w: %s", ErrInvalidGrant, fmt.Sprintf(format, args...)))
+	}
+
+	if len(g.Privileges) == 0 {
+		invalid("at least one privilege is required")
+	}
+	objects := 0
+	for _, object := range []string{g.Table, g.Sequence, g.Function, g.Procedure} {
+		if object != "" {
+			objects++
+		}
+	}
+	if objects > 1 {
+		invalid("only one of table, sequence, function or procedure can be set")
+	}
+	if objects > 0 && g.Schema == "" && engine != "mysql" {
+		invalid("a schema is required when a table, sequence, function or procedure is set")
+	}
+	if len(g.Columns) > 0 && (g.Table == "" || g.Table == "*") {
+		invalid("columns can only be set on a single table")
+	}
+	if slices.Contains(g.Columns, "") {
+		invalid("a column name is required")
+	}
+
+	switch engine {
+	case "postgres":
+		if g.Parameter != "" {
+			if g.Database != "" || g.Schema != "" || objects > 0 {
+				invalid("a parameter grant can't also set a database, schema, table or sequence")
+			}
+		} else if g.Database == "" {
+			invalid("a parameter or database is required")
+		}
+	case "mysql":
+		if g.Database == "" {
+			invalid("a database is required")
+		}
+		if g.Parameter != "" || g.Schema != "" || g.Sequence != "" {
+			invalid("schemas, sequences and parameters are not supported by MySQL")
+		}
+		if g.Table == "*" || g.Function == "*" || g.Procedure == "*" {
+			invalid("wildcards are not supported by MySQL, leave the table unset to grant on the database")
+		}
+	}
+
+	for _, err := range validatePrivileges(g.Privileges, grantPrivileges(engine, g)) {
+		invalid("%v", err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// grantPrivileges returns the privileges that can be granted by a grant for the engine, or nil if they
+// aren't known.
+func grantPrivileges(engine string, g Grant) []string {
+	switch engine {
+	case "postgres":
+		switch {
+		case g.Parameter != "":
+			return postgresPrivileges["parameter"]
+		case len(g.Columns) > 0:
+			return postgresPrivileges["column"]
+		case g.Function != "":
+			return postgresPrivileges["function"]
+		case g.Procedure != "":
+			return postgresPrivileges["procedure"]
+		case g.Table != "":
+			return postgresPrivileges["table"]
+		case g.Sequence != "":
+			return postgresPrivileges["sequence"]
+		case g.Schema != "":
+			return postgresPrivileges["schema"]
+		default:
+			return postgresPrivileges["database"]
+		}
+	case "mysql":
+		_, allowed := mysqlObject(g)
+		return allowed
+	}
+	return nil
+}
+
+// validatePrivileges checks that each privilege is in allowed, ALL is always allowed but can't be combined
+// with other privileges. If allowed is nil any privilege is allowed.
+func validatePrivileges(privileges, allowed []string) []error {
+	var errs []error
+	for _, privilege := range privileges {
+		switch upper := strings.ToUpper(strings.TrimSpace(privilege)); {
+		case upper == "ALL" || upper == "ALL PRIVILEGES":
+			if len(privileges) > 1 {
+				errs = append(errs, fmt.Errorf("%s can't be combined with other privileges", privilege))
+			}
+		case allowed != nil && !slices.Contains(allowed, upper):
+			errs = append(errs, fmt.Errorf("privilege %q can't be granted, expected one of %s", privilege, strings.Join(allowed, ", ")))
+		}
+	}
+	return errs
+}
+
+// privilegeList checks every privilege is in allowed and returns them ready to be used in a GRANT or REVOKE
+// statement, so only known keywords are ever added to a statement. ALL is always allowed on its own.
+func privilegeList(privileges, allowed []string) (string, error) {
+	if len(privileges) == 0 {
+		return "", fmt.Errorf("%w: at least one privilege is required", ErrInvalidGrant)
+	}
+	if errs := validatePrivileges(privileges, allowed); len(errs) > 0 {
+		return "", fmt.Errorf("%w: %w", ErrInvalidGrant, errs[0])
+	}
+
+	normalized := make([]string, len(privileges))
+	for i, privilege := range privileges {
+		normalized[i] = strings.ToUpper(strings.TrimSpace(privilege))
+	}
+	return strings.Join(normalized, ", "), nil
+}
+
+// withColumns adds the columns to each privilege in a list returned by privilegeList, e.g. "SELECT (a, b)",
+// so the privileges only apply to those columns. The list is returned as it is if there are no columns.
+func withColumns(privileges string, columns []string, quote func(string) string) string {
+	if len(columns) == 0 {
+		return privileges
+	}
+
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = quote(column)
+	}
+
+	list := strings.Split(privileges, ", ")
+	for i, privilege := range list {
+		list[i] = fmt.Sprintf("%s (%s)", privilege, strings.Join(quoted, ", "))
+	}
+	return strings.Join(list, ", ")
+}
+
+// defaultPrivilegeObject returns DefaultPrivilege.On ready to be used in an ALTER DEFAULT PRIVILEGES
+// statement and the privileges that can be granted on it.
+func defaultPrivilegeObject(on string) (string, []string, error) {
+	upper := strings.ToUpper(strings.TrimSpace(on))
+	allowed, ok := defaultPrivilegeAll[upper]
+	if !ok || upper == "SCHEMAS" {
+		return "", nil, fmt.Errorf("%w: default privileges can't be set on %q, expected one of TABLES, SEQUENCES, FUNCTIONS, ROUTINES, TYPES", ErrInvalidGrant, on)
+	}
+	return upper, allowed, nil
+}
+
+// Validate checks that the default privilege can be applied by the engine, e.g. "postgres" or "mysql".
+// Default privileges are only supported by PostgreSQL. Every problem found is returned, each wrapping
+// ErrInvalidGrant.
+func (p DefaultPrivilege) Validate(engine string) error {
+	var errs []error
+	invalid := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%w: %s", ErrInvalidGrant, fmt.Sprintf(format, args...)))
+	}
+
+	if engine == "mysql" {
+		invalid("default privileges are not supported by MySQL")
+		return errors.Join(errs...)
+	}
+
+	if p.Schema == "" {
+		invalid("a schema is required")
+	}
+	if p.To == "" {
+		invalid("a user or role to grant to is required")
+	}
+	if len(p.Grant) == 0 {
+		invalid("at least one privilege is required")
+	}
+
+	if _, allowed, err := defaultPrivilegeObject(p.On); err != nil {
+		errs = append(errs, err)
+	} else {
+		for _, err := range validatePrivileges(p.Grant, allowed) {
+			invalid("%v", err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Validate checks that the database, and its default privileges, can be applied by the engine, e.g.
+// "postgres" or "mysql". Every problem found is returned.
+func (d Database) Validate(engine string) error {
+	var errs []error
+
+	if d.Name == "" {
+		errs = append(errs, errors.New("a database name is required"))
+	}
+	if d.Owner != "" && engine == "mysql" {
+		errs = append(errs, errors.New("database owners are not supported by MySQL"))
+	}
+	for i, privilege := range d.DefaultPrivileges {
+		errs = append(errs, prefixErrors(fmt.Sprintf("default_privileges[%d]", i), privilege.Validate(engine))...)
+	}
+
+	return errors.Join(prefixErrors(strings.TrimSpace("database "+d.Name), errors.Join(errs...))...)
+}
+
+// Validate checks that the user, and its grants and roles, can be applied by the engine, e.g. "postgres"
+// or "mysql". Every problem found is returned.
+func (u User) Validate(engine string) error {
+	var errs []error
+
+	if u.Name == "" {
+		errs = append(errs, errors.New("a user name is required"))
+	}
+	if engine != "mysql" && u.Host != "" {
+		errs = append(errs, errors.New("a host is only supported by MySQL"))
+	}
+	if engine != "mysql" && u.AuthPlugin != "" {
+		errs = append(errs, errors.New("an authentication plugin is only supported by MySQL"))
+	} else if u.AuthPlugin != "" && !mysqlPluginPattern.MatchString(u.AuthPlugin) {
+		errs = append(errs, fmt.Errorf("invalid authentication plugin %q", u.AuthPlugin))
+	}
+	errs = append(errs, u.Options.validate(engine)...)
+	for i, role := range u.Roles {
+		if role == "" {
+			errs = append(errs, fmt.Errorf("roles[%d]: a role name is required", i))
+		}
+	}
+	for i, grant := range u.Grants {
+		errs = append(errs, prefixErrors(fmt.Sprintf("grants[%d]", i), grant.Validate(engine))...)
+	}
+
+	return errors.Join(prefixErrors(strings.TrimSpace("user "+u.Name), errors.Join(errs...))...)
+}
+
+// validate checks that the options can be applied by the engine, each engine supports its own options.
+func (o UserOptions) validate(engine string) []error {
+	var errs []error
+
+	for _, attribute := range postgresAttributes(User{Options: o}) {
+		if engine == "mysql" && attribute.value != nil {
+			errs = append(errs, fmt.Errorf("user option %s is only supported by PostgreSQL", attribute.name))
+		}
+	}
+	for _, attribute := range mysqlAttributes(o) {
+		if engine != "mysql" && attribute.clause != "" {
+			errs = append(errs, fmt.Errorf("user option %s is only supported by MySQL", attribute.name))
+		}
+	}
+	if engine != "mysql" {
+		return errs
+	}
+
+	limits := []struct {
+		name     string
+		value    *int
+		min, max int
+	}{
+		{"max_queries_per_hour", o.MaxQueriesPerHour, 0, math.MaxInt32},
+		{"max_user_connections", o.MaxUserConnections, 0, math.MaxInt32},
+		{"password_expire_days", o.PasswordExpireDays, -1, math.MaxUint16},
+		{"failed_login_attempts", o.FailedLoginAttempts, 0, math.MaxInt16},
+		{"password_lock_time", o.PasswordLockTime, -1, math.MaxInt16},
+	}
+	for _, limit := range limits {
+		if limit.value != nil && (*limit.value < limit.min || *limit.value > limit.max) {
+			errs = append(errs, fmt.Errorf("user option %s must be between %d and %d", limit.name, limit.min, limit.max))
+		}
+	}
+
+	if o.Require != nil && !slices.Contains([]string{"NONE", "SSL", "X509"}, strings.ToUpper(strings.TrimSpace(*o.Require))) {
+		errs = append(errs, fmt.Errorf("user option require must be one of NONE, SSL, X509, got %q", *o.Require))
+	}
+	if o.Require != nil && o.RequireSubject != nil {
+		errs = append(errs, errors.New("user options require and require_subject can't both be set"))
+	}
+
+	return errs
+}
+
+// prefixErrors splits errors joined with errors.Join and adds prefix to each of them, so every problem is
+// reported on its own line with the object it relates to.
+func prefixErrors(prefix string, err error) []error {
+	if err == nil {
+		return nil
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		var errs []error
+		for _, err := range joined.Unwrap() {
+			errs = append(errs, prefixErrors(prefix, err)...)
+		}
+		return errs
+	}
+	return []error{fmt.Errorf("%s: %w", prefix, err)}
+}
+
+// validate checks every database and user can be applied by the engine and returns every problem found.
+func validate(engine string, databases []Database, users []User) error {
+	var errs []error
+	for _, database := range databases {
+		if err := database.Validate(engine); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, user := range users {
+		if err := user.Validate(engine); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
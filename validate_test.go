@@ -0,0 +1,117 @@
+package dbmanager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGrant_Validate(t *testing.T) {
+	tests := []struct {
+		name   string
+		engine string
+		grant  Grant
+		err    string
+	}{
+		{"database", "postgres", Grant{Database: "db", Privileges: []string{"CONNECT"}}, ""},
+		{"all tables", "postgres", Grant{Database: "db", Schema: "public", Table: "*", Privileges: []string{"all"}}, ""},
+		{"parameter", "postgres", Grant{Parameter: "work_mem", Privileges: []string{"SET"}}, ""},
+		{"mysql database", "mysql", Grant{Database: "db", Privileges: []string{"SELECT", "INSERT"}}, ""},
+		{"no privileges", "postgres", Grant{Database: "db"}, "invalid grant: at least one privilege is required"},
+		{"table and sequence", "postgres", Grant{Database: "db", Schema: "public", Table: "t", Sequence: "s", Privileges: []string{"SELECT"}}, "invalid grant: only one of table, sequence, function or procedure can be set"},
+		{"table without schema", "postgres", Grant{Database: "db", Table: "t", Privileges: []string{"SELECT"}}, "invalid grant: a schema is required when a table, sequence, function or procedure is set"},
+		{"parameter with database", "postgres", Grant{Database: "db", Parameter: "work_mem", Privileges: []string{"SET"}}, "invalid grant: a parameter grant can't also set a database, schema, table or sequence"},
+		{"wrong object type", "postgres", Grant{Database: "db", Privileges: []string{"SELECT"}}, `invalid grant: privilege "SELECT" can't be granted, expected one of CREATE, CONNECT, TEMPORARY, TEMP`},
+		{"all combined", "postgres", Grant{Database: "db", Privileges: []string{"ALL", "CONNECT"}}, "invalid grant: ALL can't be combined with other privileges"},
+		{"mysql schema", "mysql", Grant{Database: "db", Schema: "public", Privileges: []string{"SELECT"}}, "invalid grant: schemas, sequences and parameters are not supported by MySQL"},
+		{"mysql table", "mysql", Grant{Database: "db", Table: "t", Privileges: []string{"SELECT", "SHOW VIEW"}}, ""},
+		{"mysql columns", "mysql", Grant{Database: "db", Table: "t", Columns: []string{"a"}, Privileges: []string{"SELECT"}}, ""},
+		{"mysql column privilege", "mysql", Grant{Database: "db", Table: "t", Columns: []string{"a"}, Privileges: []string{"DELETE"}}, `invalid grant: privilege "DELETE" can't be granted, expected one of INSERT, REFERENCES, SELECT, UPDATE`},
+		{"mysql procedure", "mysql", Grant{Database: "db", Procedure: "p", Privileges: []string{"EXECUTE"}}, ""},
+		{"mysql wildcard", "mysql", Grant{Database: "db", Table: "*", Privileges: []string{"SELECT"}}, "invalid grant: wildcards are not supported by MySQL, leave the table unset to grant on the database"},
+		{"postgres columns", "postgres", Grant{Database: "db", Schema: "public", Table: "t", Columns: []string{"a"}, Privileges: []string{"SELECT", "UPDATE"}}, ""},
+		{"postgres function", "postgres", Grant{Database: "db", Schema: "public", Function: "*", Privileges: []string{"EXECUTE"}}, ""},
+		{"columns without table", "postgres", Grant{Database: "db", Schema: "public", Columns: []string{"a"}, Privileges: []string{"SELECT"}}, "invalid grant: columns can only be set on a single table"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.grant.Validate(tt.engine)
+			if tt.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, ErrInvalidGrant)
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+}
+
+func TestUser_Validate(t *testing.T) {
+	user := User{
+		Name:    "myuser",
+		Options: UserOptions{Login: Bool(true)},
+		Roles:   []string{"myrole"},
+		Grants:  []Grant{{Privileges: []string{"SELECT"}}},
+	}
+
+	// Every problem should be returned with the object it relates to
+	assert.EqualError(t, user.Validate("mysql"), "user myuser: user option login is only supported by PostgreSQL\n"+
+		"user myuser: grants[0]: invalid grant: a database is required")
+	assert.EqualError(t, user.Validate("postgres"), "user myuser: grants[0]: invalid grant: a parameter or database is required\n"+
+		`user myuser: grants[0]: invalid grant: privilege "SELECT" can't be granted, expected one of CREATE, CONNECT, TEMPORARY, TEMP`)
+
+	// A host is only supported by MySQL
+	user = User{Name: "myuser", Host: "localhost"}
+	assert.NoError(t, user.Validate("mysql"))
+	assert.EqualError(t, user.Validate("postgres"), "user myuser: a host is only supported by MySQL")
+
+	// An authentication plugin is only supported by MySQL and is used unquoted
+	user = User{Name: "myuser", AuthPlugin: "auth_socket"}
+	assert.NoError(t, user.Validate("mysql"))
+	assert.EqualError(t, user.Validate("postgres"), "user myuser: an authentication plugin is only supported by MySQL")
+	user = User{Name: "myuser", AuthPlugin: "auth_socket; DROP USER root"}
+	assert.EqualError(t, user.Validate("mysql"), `user myuser: invalid authentication plugin "auth_socket; DROP USER root"`)
+
+	// Account options are only supported by MySQL and must be in range
+	user = User{Name: "myuser", Options: UserOptions{MaxUserConnections: Int(5), PasswordLockTime: Int(-1), Require: String("ssl")}}
+	assert.NoError(t, user.Validate("mysql"))
+	assert.EqualError(t, user.Validate("postgres"), "user myuser: user option require is only supported by MySQL\n"+
+		"user myuser: user option max_user_connections is only supported by MySQL\n"+
+		"user myuser: user option password_lock_time is only supported by MySQL")
+
+	user = User{Name: "myuser", Options: UserOptions{FailedLoginAttempts: Int(-1), Require: String("TLS"), RequireSubject: String("/CN=myuser")}}
+	assert.EqualError(t, user.Validate("mysql"), "user myuser: user option failed_login_attempts must be between 0 and 32767\n"+
+		`user myuser: user option require must be one of NONE, SSL, X509, got "TLS"`+"\n"+
+		"user myuser: user options require and require_subject can't both be set")
+}
+
+func TestDatabase_Validate(t *testing.T) {
+	database := Database{
+		Name: "mydb",
+		DefaultPrivileges: []DefaultPrivilege{
+			{Schema: "public", Grant: []string{"SELECT"}, On: "tables", To: "myuser"},
+			{Schema: "public", Grant: []string{"SELECT"}, On: "schemas", To: "myuser"},
+		},
+	}
+
+	assert.NoError(t, Database{Name: "mydb", DefaultPrivileges: database.DefaultPrivileges[:1]}.Validate("postgres"))
+	assert.EqualError(t, database.Validate("postgres"), `database mydb: default_privileges[1]: invalid grant: default privileges can't be set on "schemas", expected one of TABLES, SEQUENCES, FUNCTIONS, ROUTINES, TYPES`)
+	assert.ErrorIs(t, database.Validate("mysql"), ErrInvalidGrant, "Default privileges are not supported by MySQL")
+}
+
+func TestPrivilegeList(t *testing.T) {
+	list, err := privilegeList([]string{"select", " Update "}, postgresPrivileges["table"])
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT, UPDATE", list)
+
+	list, err = privilegeList([]string{"all"}, postgresPrivileges["table"])
+	assert.NoError(t, err)
+	assert.Equal(t, "ALL", list)
+
+	// Anything that isn't a known privilege keyword should never reach a statement
+	_, err = privilegeList([]string{"SELECT ON pg_authid TO PUBLIC; --"}, postgresPrivileges["table"])
+	assert.ErrorIs(t, err, ErrInvalidGrant)
+	_, err = privilegeList(nil, postgresPrivileges["table"])
+	assert.ErrorIs(t, err, ErrInvalidGrant)
+}